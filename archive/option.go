@@ -0,0 +1,43 @@
+package archive
+
+import "go.uber.org/zap"
+
+type options struct {
+	logger  *zap.Logger
+	dir     string // WARC 文件的存放目录
+	prefix  string // WARC 文件名前缀
+	maxSize int64  // 单个 WARC 文件的最大字节数，超过后轮转
+}
+
+var defaultOptions = options{
+	logger:  zap.NewNop(),
+	dir:     "warc",
+	prefix:  "crawler",
+	maxSize: 1 << 30,
+}
+
+type Option func(opts *options)
+
+func WithLogger(logger *zap.Logger) Option {
+	return func(opts *options) {
+		opts.logger = logger
+	}
+}
+
+func WithDir(dir string) Option {
+	return func(opts *options) {
+		opts.dir = dir
+	}
+}
+
+func WithPrefix(prefix string) Option {
+	return func(opts *options) {
+		opts.prefix = prefix
+	}
+}
+
+func WithMaxSize(maxSize int64) Option {
+	return func(opts *options) {
+		opts.maxSize = maxSize
+	}
+}
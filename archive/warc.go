@@ -0,0 +1,284 @@
+package archive
+
+/** 本模块将抓取到的原始网页以 WARC 1.1 格式归档，
+**	每条记录单独压缩为一个 gzip member，文件超过指定大小后轮转
+ */
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	warcVersion = "WARC/1.1"
+	warcSuffix  = ".warc.gz"
+	openSuffix  = ".open" // 正在写入的文件的后缀，轮转时去掉
+)
+
+// Record 一次抓取的原始内容
+type Record struct {
+	Url    string
+	Method string
+	// Proto 响应的协议版本，为空时表示 Fetcher 没有返回 HTTP 响应(例如无头浏览器渲染后的页面)，
+	// 此时 Body 以 resource 记录归档
+	Proto     string
+	Status    string      // 响应的状态，例如 200 OK
+	Header    http.Header // 响应头
+	Body      []byte      // 服务器返回的原始正文；没有 HTTP 响应时为 Fetcher 返回的内容
+	FetchTime time.Time   // 抓取完成的时间
+	Task      string      // 任务名
+	Rule      string      // 规则名
+	Depth     int
+}
+
+// Archiver 原始网页的归档接口
+type Archiver interface {
+	Archive(r *Record) error
+	Close() error
+}
+
+// WarcWriter : Archiver 的实现，将记录写入可轮转的 WARC 文件
+type WarcWriter struct {
+	mu       sync.Mutex
+	file     *os.File
+	fileName string
+	size     int64  // 当前文件已写入的字节数
+	serial   int    // 文件序号
+	infoID   string // 当前文件 warcinfo 记录的 ID
+	options
+}
+
+func NewWarcWriter(opts ...Option) (*WarcWriter, error) {
+	options := defaultOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	w := &WarcWriter{}
+	w.options = options
+	if err := os.MkdirAll(w.dir, 0755); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Archive 为一次抓取依次写入 request、response、metadata 三条记录，
+// 没有 HTTP 响应时写入 resource、metadata 两条记录
+func (w *WarcWriter) Archive(r *Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil || w.size >= w.maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	date := r.FetchTime
+	if date.IsZero() {
+		date = time.Now()
+	}
+	respID := newRecordID()
+	type record struct {
+		header []string
+		block  []byte
+	}
+	var records []record
+	if r.Proto != "" {
+		// Fetcher 不返回请求，这里的请求报文是根据网址重新构造的
+		httpReq, err := buildHTTPRequest(r)
+		if err != nil {
+			return err
+		}
+		reqID := newRecordID()
+		records = append(records,
+			record{
+				header: []string{
+					"WARC-Type", "request",
+					"WARC-Record-ID", reqID,
+					"WARC-Concurrent-To", respID,
+					"WARC-Target-URI", r.Url,
+					"Content-Type", "application/http;msgtype=request",
+				},
+				block: httpReq,
+			},
+			record{
+				header: []string{
+					"WARC-Type", "response",
+					"WARC-Record-ID", respID,
+					"WARC-Target-URI", r.Url,
+					"WARC-Payload-Digest", digest(r.Body),
+					"Content-Type", "application/http;msgtype=response",
+				},
+				block: buildHTTPResponse(r),
+			})
+	} else {
+		records = append(records, record{
+			header: []string{
+				"WARC-Type", "resource",
+				"WARC-Record-ID", respID,
+				"WARC-Target-URI", r.Url,
+				"Content-Type", "text/html; charset=utf-8",
+			},
+			block: r.Body,
+		})
+	}
+	records = append(records, record{
+		header: []string{
+			"WARC-Type", "metadata",
+			"WARC-Record-ID", newRecordID(),
+			"WARC-Refers-To", respID,
+			"WARC-Target-URI", r.Url,
+			"Content-Type", "application/warc-fields",
+		},
+		block: warcFields(
+			"task", r.Task,
+			"rule", r.Rule,
+			"depth", strconv.Itoa(r.Depth),
+		),
+	})
+	for _, rec := range records {
+		if err := w.writeRecord(date, rec.header, rec.block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rotate 关闭当前文件，并创建新的 WARC 文件，新文件以一条 warcinfo 记录开头
+func (w *WarcWriter) rotate() error {
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+
+	w.serial++
+	name := fmt.Sprintf("%s-%s-%05d%s", w.prefix, time.Now().UTC().Format("20060102150405"), w.serial, warcSuffix)
+	w.fileName = filepath.Join(w.dir, name)
+	f, err := os.OpenFile(w.fileName+openSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = f
+	w.size = 0
+	w.logger.Debug("create warc file", zap.String("file", w.fileName))
+
+	w.infoID = newRecordID()
+	return w.writeRecord(time.Now(), []string{
+		"WARC-Type", "warcinfo",
+		"WARC-Record-ID", w.infoID,
+		"WARC-Filename", name,
+		"Content-Type", "application/warc-fields",
+	}, warcFields(
+		"software", "github.com/Nrich-sunny/crawler",
+		"format", "WARC File Format 1.1",
+	))
+}
+
+// writeRecord 将一条记录压缩为单独的 gzip member 写入文件
+func (w *WarcWriter) writeRecord(date time.Time, header []string, block []byte) error {
+	var buf bytes.Buffer
+	buf.WriteString(warcVersion + "\r\n")
+	for i := 0; i+1 < len(header); i += 2 {
+		buf.WriteString(header[i] + ": " + header[i+1] + "\r\n")
+		if header[i] == "WARC-Record-ID" {
+			buf.WriteString("WARC-Date: " + date.UTC().Format(time.RFC3339Nano) + "\r\n")
+		}
+	}
+	if w.infoID != "" && header[1] != "warcinfo" {
+		buf.WriteString("WARC-Warcinfo-ID: " + w.infoID + "\r\n")
+	}
+	buf.WriteString("WARC-Block-Digest: " + digest(block) + "\r\n")
+	buf.WriteString("Content-Length: " + strconv.Itoa(len(block)) + "\r\n\r\n")
+	buf.Write(block)
+	buf.WriteString("\r\n\r\n")
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if _, err := zw.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	n, err := w.file.Write(gz.Bytes())
+	w.size += int64(n)
+	return err
+}
+
+func (w *WarcWriter) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+	// 写入完成，去掉 .open 后缀
+	return os.Rename(w.fileName+openSuffix, w.fileName)
+}
+
+// Close 关闭当前正在写入的 WARC 文件
+func (w *WarcWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.closeFile()
+}
+
+func buildHTTPRequest(r *Record) ([]byte, error) {
+	u, err := url.Parse(r.Url)
+	if err != nil {
+		return nil, fmt.Errorf("parse url failed:%w", err)
+	}
+	method := r.Method
+	if method == "" {
+		method = "GET"
+	}
+	var buf bytes.Buffer
+	buf.WriteString(method + " " + u.RequestURI() + " HTTP/1.1\r\n")
+	buf.WriteString("Host: " + u.Host + "\r\n\r\n")
+	return buf.Bytes(), nil
+}
+
+// buildHTTPResponse 按原始的状态行、响应头与正文构造响应报文
+func buildHTTPResponse(r *Record) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(r.Proto + " " + r.Status + "\r\n")
+	_ = r.Header.Write(&buf)
+	buf.WriteString("\r\n")
+	buf.Write(r.Body)
+	return buf.Bytes()
+}
+
+func warcFields(kv ...string) []byte {
+	var buf bytes.Buffer
+	for i := 0; i+1 < len(kv); i += 2 {
+		buf.WriteString(kv[i] + ": " + kv[i+1] + "\r\n")
+	}
+	return buf.Bytes()
+}
+
+// digest 计算 WARC 规范推荐的 sha1 摘要(base32 编码)
+func digest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// newRecordID 生成形如 <urn:uuid:...> 的记录 ID (UUID v4)
+func newRecordID() string {
+	var u [16]byte
+	_, _ = rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", u[0:4], u[4:6], u[6:8], u[8:10], u[10:])
+}
@@ -0,0 +1,116 @@
+package archive_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/base32"
+	"github.com/Nrich-sunny/crawler/archive"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readWarcTypes 读取 WARC 文件中所有记录的 WARC-Type
+func readWarcTypes(t *testing.T, name string) []string {
+	f, err := os.Open(name)
+	require.NoError(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+
+	var types []string
+	scanner := bufio.NewScanner(zr)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "WARC-Type: ") {
+			types = append(types, strings.TrimSpace(strings.TrimPrefix(line, "WARC-Type: ")))
+		}
+	}
+	require.NoError(t, scanner.Err())
+	return types
+}
+
+func TestWarcWriter(t *testing.T) {
+	dir := t.TempDir()
+	w, err := archive.NewWarcWriter(
+		archive.WithDir(dir),
+		archive.WithPrefix("test"),
+		archive.WithMaxSize(100),
+	)
+	require.NoError(t, err)
+
+	for _, u := range []string{"https://book.douban.com/", "https://book.douban.com/tag/小说?start=20"} {
+		err = w.Archive(&archive.Record{
+			Url:       u,
+			Method:    "GET",
+			Proto:     "HTTP/1.1",
+			Status:    "200 OK",
+			Header:    http.Header{"Content-Type": {"text/html"}},
+			Body:      []byte("<html>" + u + "</html>"),
+			FetchTime: time.Now(),
+			Task:      "douban_book_list",
+			Rule:      "数据tag",
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+
+	// 超过 MaxSize 后轮转，每次抓取写入一个新文件
+	files, err := filepath.Glob(filepath.Join(dir, "test-*.warc.gz"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+
+	open, err := filepath.Glob(filepath.Join(dir, "*.open"))
+	require.NoError(t, err)
+	assert.Empty(t, open)
+
+	for _, name := range files {
+		assert.Equal(t, []string{"warcinfo", "request", "response", "metadata"}, readWarcTypes(t, name))
+	}
+}
+
+func TestWarcWriterResponse(t *testing.T) {
+	dir := t.TempDir()
+	w, err := archive.NewWarcWriter(archive.WithDir(dir), archive.WithPrefix("test"))
+	require.NoError(t, err)
+
+	// GBK 编码的原始正文
+	raw := []byte{0xc8, 0xfd, 0xcc, 0xe5}
+	require.NoError(t, w.Archive(&archive.Record{
+		Url:    "https://book.douban.com/subject/1/",
+		Proto:  "HTTP/1.1",
+		Status: "404 Not Found",
+		Header: http.Header{"Content-Type": {"text/html; charset=gbk"}},
+		Body:   raw,
+	}))
+	require.NoError(t, w.Archive(&archive.Record{
+		Url:  "https://book.douban.com/subject/2/",
+		Body: []byte("<html>rendered</html>"),
+	}))
+	require.NoError(t, w.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "test-*.warc.gz"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, []string{"warcinfo", "request", "response", "metadata", "resource", "metadata"}, readWarcTypes(t, files[0]))
+
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	b, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	content := string(b)
+	assert.Contains(t, content, "HTTP/1.1 404 Not Found\r\nContent-Type: text/html; charset=gbk\r\n\r\n"+string(raw))
+	sum := sha1.Sum(raw)
+	assert.Contains(t, content, "WARC-Payload-Digest: sha1:"+base32.StdEncoding.EncodeToString(sum[:]))
+	assert.NotContains(t, content, "200 OK")
+}
@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"github.com/Nrich-sunny/crawler/cmd/master"
+	"github.com/Nrich-sunny/crawler/cmd/parse"
+	"github.com/Nrich-sunny/crawler/cmd/run"
+	"github.com/Nrich-sunny/crawler/cmd/validate"
 	"github.com/Nrich-sunny/crawler/cmd/worker"
 	"github.com/Nrich-sunny/crawler/version"
 	"github.com/spf13/cobra"
@@ -21,6 +24,6 @@ func Execute() {
 	var rootCmd = &cobra.Command{
 		Use: "crawler",
 	}
-	rootCmd.AddCommand(worker.WorkerCmd, master.MasterCmd, versionCmd)
+	rootCmd.AddCommand(worker.WorkerCmd, master.MasterCmd, validate.ValidateCmd, parse.ParseCmd, run.RunCmd, versionCmd)
 	rootCmd.Execute()
 }
@@ -85,7 +85,11 @@ func Run() {
 	if err := cfg.Get("Tasks").Scan(&tConfig); err != nil {
 		logger.Error("init seed tasks,", zap.Error(err))
 	}
-	seeds := worker.ParseTaskConfig(logger, nil, nil, tConfig)
+	seeds, err := worker.ParseTaskConfig(logger, nil, nil, tConfig)
+	if err != nil {
+		logger.Error("parse task config failed", zap.Error(err))
+		return
+	}
 
 	// start master
 	reg := etcdReg.NewRegistry(registry.Addrs(sConfig.RegistryAddress))
@@ -96,6 +100,7 @@ func Run() {
 		master.WithRegistryURL(sConfig.RegistryAddress),
 		master.WithRegistry(reg),
 		master.WithSeeds(seeds),
+		master.WithWorkerServiceName(worker.ServiceName),
 	)
 	if err != nil {
 		logger.Error("init master failed", zap.Error(err))
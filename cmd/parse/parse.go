@@ -0,0 +1,184 @@
+package parse
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/cmd/worker"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/engine"
+	"github.com/Nrich-sunny/crawler/log"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/Nrich-sunny/crawler/taskmodule"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+)
+
+var ParseCmd = &cobra.Command{
+	Use:   "parse",
+	Short: "parse a single page with a rule.",
+	Long:  "fetch or read a single page, run the rule of the task on it and print the items and child requests as JSON.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := Run(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var configPath string
+var taskName string
+var ruleName string
+var pageURL string
+var pageFile string
+var depth int
+var tempData map[string]string
+
+func init() {
+	ParseCmd.Flags().StringVar(&configPath, "config", "config.toml", "set config file")
+	ParseCmd.Flags().StringVar(&taskName, "task", "", "task name")
+	ParseCmd.Flags().StringVar(&ruleName, "rule", "", "rule name")
+	ParseCmd.Flags().StringVar(&pageURL, "url", "", "page url, also used to resolve relative links when --file is set")
+	ParseCmd.Flags().StringVar(&pageFile, "file", "", "read the page from file instead of fetching it")
+	ParseCmd.Flags().IntVar(&depth, "depth", 0, "depth of the request")
+	ParseCmd.Flags().StringToStringVar(&tempData, "temp", nil, "temp data of the request, e.g. --temp book_name=三体")
+	_ = ParseCmd.MarkFlagRequired("task")
+	_ = ParseCmd.MarkFlagRequired("rule")
+	_ = ParseCmd.MarkFlagRequired("url")
+}
+
+// Output 解析结果，Items 中的 DataCell 只输出其中的数据
+type Output struct {
+	Items    []interface{}    `json:"items"`
+	Requests []*RequestOutput `json:"requests"`
+}
+
+type RequestOutput struct {
+	Url      string        `json:"url"`
+	Method   string        `json:"method"`
+	RuleName string        `json:"rule"`
+	Depth    int           `json:"depth"`
+	Priority int           `json:"priority"`
+	TempData *collect.Temp `json:"temp,omitempty"`
+}
+
+func Run() error {
+	cfg, err := worker.LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("load config failed:%w", err)
+	}
+	logger := log.NewLogger(log.NewStdoutPlugin(zapcore.ErrorLevel))
+
+	if dir := cfg.Get("modules", "dir").String(""); dir != "" {
+		if _, errs, err := taskmodule.LoadDir(dir, engine.Store.AddJsTask, nil); err == nil {
+			for name, err := range errs {
+				fmt.Fprintf(os.Stderr, "load task module %s failed:%v\n", name, err)
+			}
+		}
+	}
+
+	fConfigs, err := collect.ParseFetcherConfigs(cfg.Get("fetchers").Bytes())
+	if err != nil {
+		return fmt.Errorf("parse fetchers config failed:%w", err)
+	}
+	var tConfig []collect.TaskConfig
+	if err := cfg.Get("Tasks").Scan(&tConfig); err != nil {
+		return fmt.Errorf("parse tasks config failed:%w", err)
+	}
+	// 只使用指定的任务，不归档网页
+	var selected []collect.TaskConfig
+	for _, c := range tConfig {
+		if c.Name == taskName {
+			c.Archive = collect.ArchiveConfig{}
+			selected = append(selected, c)
+		}
+	}
+	seeds, err := worker.ParseTaskConfig(logger, fConfigs, nil, selected)
+	if err != nil {
+		return fmt.Errorf("parse tasks config failed:%w", err)
+	}
+
+	t, ok := engine.Store.Get(taskName)
+	if !ok {
+		return fmt.Errorf("task %s not found", taskName)
+	}
+	task := *t
+	if len(seeds) > 0 {
+		// 与 Crawler.Schedule 相同，配置中的任务使用注册的规则
+		task = *seeds[0]
+		task.Rule = t.Rule
+	}
+	rule, ok := task.Rule.Trunk[ruleName]
+	if !ok {
+		return fmt.Errorf("rule %s not found in task %s", ruleName, taskName)
+	}
+
+	req := &collect.Request{
+		Task:     &task,
+		Url:      pageURL,
+		Method:   "GET",
+		Depth:    depth,
+		RuleName: ruleName,
+		TempData: &collect.Temp{},
+	}
+	for k, v := range tempData {
+		_ = req.TempData.Set(k, v)
+	}
+
+	var body []byte
+	if pageFile != "" {
+		body, err = os.ReadFile(pageFile)
+	} else {
+		body, err = fetch(&task, req, fConfigs, logger)
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := engine.ParseOnce(rule, &collect.Context{Body: body, Req: req})
+	if err != nil {
+		return fmt.Errorf("parse failed:%w", err)
+	}
+
+	out := Output{Items: make([]interface{}, 0, len(result.Items)), Requests: make([]*RequestOutput, 0, len(result.Requests))}
+	for _, item := range result.Items {
+		if cell, ok := item.(*storage.DataCell); ok {
+			item = cell.Data
+		}
+		out.Items = append(out.Items, item)
+	}
+	for _, r := range result.Requests {
+		out.Requests = append(out.Requests, &RequestOutput{
+			Url:      r.Url,
+			Method:   r.Method,
+			RuleName: r.RuleName,
+			Depth:    r.Depth,
+			Priority: r.Priority,
+			TempData: r.TempData,
+		})
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// fetch 使用任务的 Fetcher 抓取页面，任务没有 Fetcher 时使用默认的 Fetcher
+func fetch(task *collect.Task, req *collect.Request, fConfigs map[string]collect.FetcherConfig, logger *zap.Logger) ([]byte, error) {
+	if task.Fetcher == nil {
+		fCfg := fConfigs[collect.DefaultFetcher]
+		fCfg.Logger = logger
+		f, err := collect.NewFetcher(collect.DefaultFetcher, fCfg)
+		if err != nil {
+			return nil, fmt.Errorf("create default fetcher failed:%w", err)
+		}
+		task.Fetcher = f
+	}
+	body, err := task.Fetcher.Get(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s failed:%w", req.Url, err)
+	}
+	return body, nil
+}
@@ -0,0 +1,117 @@
+package parse
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const config = `logLevel = "error"
+
+[storage]
+type = "jsonl"
+dir = "data/{task}"
+
+[[Tasks]]
+Name = "parse_books"
+Seeds = ["https://books.test/list"]
+RootRule = "书籍列表"
+Storages = ["default"]
+  [[Tasks.Rules]]
+  Name = "书籍列表"
+  Links = [{CSS = "h2 a", Rule = "书籍简介", Priority = 10}]
+  [[Tasks.Rules]]
+  Name = "书籍简介"
+  Fields = [{Name = "书名", CSS = "h1", Required = true}]
+`
+
+// writeFiles 在临时目录中生成配置文件与网页
+func writeFiles(t *testing.T, page string) {
+	dir := t.TempDir()
+	configPath = filepath.Join(dir, "config.toml")
+	require.NoError(t, os.WriteFile(configPath, []byte(config), 0644))
+	pageFile = filepath.Join(dir, "page.html")
+	require.NoError(t, os.WriteFile(pageFile, []byte(page), 0644))
+}
+
+// captureStdout 返回 fn 执行期间写入标准输出的内容
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	fn()
+	w.Close()
+	return <-out
+}
+
+func parse(t *testing.T) Output {
+	var err error
+	out := captureStdout(t, func() {
+		err = Run()
+	})
+	require.NoError(t, err)
+	var res Output
+	require.NoError(t, json.Unmarshal([]byte(out), &res), out)
+	return res
+}
+
+func TestParse(t *testing.T) {
+	writeFiles(t, `<html><body><ul>
+<li><h2><a href="/book/1">三体</a></h2></li>
+<li><h2><a href="/book/2">球状闪电</a></h2></li>
+</ul></body></html>`)
+	taskName = "parse_books"
+	ruleName = "书籍列表"
+	pageURL = "https://books.test/list"
+	depth = 1
+
+	// 相对链接按 --url 解析
+	res := parse(t)
+	assert.Empty(t, res.Items)
+	require.Len(t, res.Requests, 2)
+	assert.Equal(t, "https://books.test/book/1", res.Requests[0].Url)
+	assert.Equal(t, "https://books.test/book/2", res.Requests[1].Url)
+	assert.Equal(t, "书籍简介", res.Requests[1].RuleName)
+	assert.Equal(t, 2, res.Requests[1].Depth)
+	assert.Equal(t, 10, res.Requests[1].Priority)
+
+	writeFiles(t, `<html><body><h1>三体</h1></body></html>`)
+	ruleName = "书籍简介"
+	pageURL = "https://books.test/book/1"
+	res = parse(t)
+	assert.Empty(t, res.Requests)
+	require.Len(t, res.Items, 1)
+	item, err := json.Marshal(res.Items[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(item), `"书名":"三体"`)
+}
+
+func TestParseErrors(t *testing.T) {
+	writeFiles(t, `<html></html>`)
+	taskName = "parse_books"
+	pageURL = "https://books.test/list"
+
+	ruleName = "书籍详情"
+	assert.EqualError(t, Run(), "rule 书籍详情 not found in task parse_books")
+
+	taskName = "no_such_task"
+	assert.EqualError(t, Run(), "task no_such_task not found")
+
+	taskName = "parse_books"
+	ruleName = "书籍列表"
+	pageFile = filepath.Join(t.TempDir(), "missing.html")
+	assert.Error(t, Run())
+}
@@ -0,0 +1,178 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/cmd/worker"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/engine"
+	"github.com/Nrich-sunny/crawler/log"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/Nrich-sunny/crawler/taskmodule"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"time"
+)
+
+var RunCmd = &cobra.Command{
+	Use:   "run",
+	Short: "run tasks in a single process.",
+	Long:  "run tasks in a single process without etcd or master, exit when all requests are done.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := Run(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	},
+}
+
+var configPath string
+var taskNames []string
+var workCount int
+var progress time.Duration
+var useStorage bool
+var historyPath string
+
+func init() {
+	RunCmd.Flags().StringVar(&configPath, "config", "config.toml", "set config file")
+	RunCmd.Flags().StringSliceVar(&taskNames, "task", nil, "tasks to run")
+	RunCmd.Flags().IntVar(&workCount, "workers", 5, "number of concurrent fetchers")
+	RunCmd.Flags().DurationVar(&progress, "progress", 5*time.Second, "interval of printing progress")
+	RunCmd.Flags().BoolVar(&useStorage, "storage", true, "save items into the storage in config")
+	RunCmd.Flags().StringVar(&historyPath, "history", "", "file of fetched pages kept between runs for incremental tasks")
+	_ = RunCmd.MarkFlagRequired("task")
+}
+
+func Run() error {
+	cfg, err := worker.LoadConfig(configPath)
+	if err != nil {
+		return fmt.Errorf("load config failed:%w", err)
+	}
+
+	// log
+	logLevel, err := zapcore.ParseLevel(cfg.Get("logLevel").String("INFO"))
+	if err != nil {
+		return err
+	}
+	logger := log.NewLogger(log.NewStdoutPlugin(logLevel))
+	zap.ReplaceGlobals(logger)
+
+	// 任务模块
+	if dir := cfg.Get("modules", "dir").String(""); dir != "" {
+		if _, errs, err := taskmodule.LoadDir(dir, engine.Store.AddJsTask, nil); err == nil {
+			for name, err := range errs {
+				logger.Error("load task module failed", zap.String("file", name), zap.Error(err))
+			}
+		}
+	}
+
+	// storage
+	var s storage.Storage
+	if useStorage {
+		var stConfig worker.StorageConfig
+		if err := cfg.Get("storage").Scan(&stConfig); err != nil {
+			return fmt.Errorf("parse storage config failed:%w", err)
+		}
+		r, err := worker.NewRouter(logger, stConfig)
+		if err != nil {
+			return fmt.Errorf("create storage failed:%w", err)
+		}
+		if r != nil {
+			s = r
+		}
+	}
+
+	// fetcher
+	fConfigs, err := collect.ParseFetcherConfigs(cfg.Get("fetchers").Bytes())
+	if err != nil {
+		return fmt.Errorf("parse fetchers config failed:%w", err)
+	}
+
+	// 指定的任务优先使用配置，不在配置中的任务使用默认参数
+	var tConfig []collect.TaskConfig
+	if err := cfg.Get("Tasks").Scan(&tConfig); err != nil {
+		return fmt.Errorf("parse tasks config failed:%w", err)
+	}
+	var selected []collect.TaskConfig
+	for _, name := range taskNames {
+		c := collect.TaskConfig{Name: name}
+		for _, tc := range tConfig {
+			if tc.Name == name {
+				c = tc
+			}
+		}
+		selected = append(selected, c)
+	}
+	seeds, err := worker.ParseTaskConfig(logger, fConfigs, s, selected)
+	if err != nil {
+		return fmt.Errorf("parse tasks config failed:%w", err)
+	}
+	for _, seed := range seeds {
+		if _, ok := engine.Store.Get(seed.Name); !ok {
+			return fmt.Errorf("task %s not found", seed.Name)
+		}
+	}
+	if len(seeds) == 0 {
+		return errors.New("no task to run")
+	}
+
+	opts := []engine.Option{
+		engine.WithLogger(logger),
+		engine.WithWorkCount(workCount),
+		engine.WithSeeds(seeds),
+		engine.WithScheduler(engine.NewSchedule()),
+	}
+	// 增量爬取的任务在多次运行之间共享抓取记录
+	if historyPath != "" {
+		h, err := engine.LoadHistory(historyPath)
+		if err != nil {
+			return fmt.Errorf("load history failed:%w", err)
+		}
+		opts = append(opts, engine.WithHistory(h))
+	}
+	crawler := engine.NewEngine(opts...)
+
+	start := time.Now()
+	go printProgress(crawler, start)
+	crawler.Run()
+
+	// 写入缓存的数据并关闭存储
+	if s != nil {
+		if err := s.Close(); err != nil {
+			logger.Error("close storage failed", zap.Error(err))
+		}
+	}
+	// 关闭归档文件
+	for _, seed := range seeds {
+		if seed.Archiver != nil {
+			if err := seed.Archiver.Close(); err != nil {
+				logger.Error("close archiver failed", zap.Error(err))
+			}
+		}
+	}
+	stats := crawler.Stats()
+	fmt.Printf("done in %v: fetched %d, failed %d, items %d, skipped %d, invalid %d, dropped %d\n",
+		time.Since(start).Round(time.Millisecond), stats.Fetched, stats.Failed, stats.Items, stats.Skipped, stats.Invalid, stats.Dropped)
+	return nil
+}
+
+func printProgress(crawler *engine.Crawler, start time.Time) {
+	if progress <= 0 {
+		return
+	}
+	ticker := time.NewTicker(progress)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			stats := crawler.Stats()
+			fmt.Printf("[%v] pending %d, fetched %d, failed %d, items %d\n",
+				time.Since(start).Round(time.Second), stats.Pending, stats.Fetched, stats.Failed, stats.Items)
+		case <-crawler.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package run
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const listPage = `<html><body><ul>
+<li><h2><a href="/book/1">三体</a></h2></li>
+<li><h2><a href="/book/2">球状闪电</a></h2></li>
+</ul></body></html>`
+
+func newBookServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/book/1":
+			fmt.Fprint(w, `<html><body><h1>三体</h1></body></html>`)
+		case "/book/2":
+			fmt.Fprint(w, `<html><body><h1>球状闪电</h1></body></html>`)
+		default:
+			fmt.Fprint(w, listPage)
+		}
+	}))
+}
+
+// writeConfig 在临时目录中生成配置文件，数据写入临时目录下的 jsonl 文件
+func writeConfig(t *testing.T, seed string) (path string, dataDir string) {
+	dir := t.TempDir()
+	dataDir = filepath.Join(dir, "data")
+	content := fmt.Sprintf(`logLevel = "error"
+
+[storage]
+type = "jsonl"
+dir = %q
+
+[[Tasks]]
+Name = "run_books"
+WaitTime = 1
+Seeds = [%q]
+RootRule = "书籍列表"
+Storages = ["default"]
+  [[Tasks.Rules]]
+  Name = "书籍列表"
+  Links = [{CSS = "h2 a", Rule = "书籍简介"}]
+  [[Tasks.Rules]]
+  Name = "书籍简介"
+  Fields = [{Name = "书名", CSS = "h1", Required = true}]
+`, dataDir, seed)
+	path = filepath.Join(dir, "config.toml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path, dataDir
+}
+
+// captureStdout 返回 fn 执行期间写入标准输出的内容
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	fn()
+	w.Close()
+	return <-out
+}
+
+func TestRun(t *testing.T) {
+	srv := newBookServer()
+	defer srv.Close()
+	path, dataDir := writeConfig(t, srv.URL+"/list")
+	configPath = path
+	taskNames = []string{"run_books"}
+	workCount = 2
+	progress = 0
+	useStorage = true
+	historyPath = ""
+
+	var err error
+	out := captureStdout(t, func() {
+		err = Run()
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "fetched 3, failed 0, items 2,")
+
+	files, err := filepath.Glob(filepath.Join(dataDir, "*.jsonl"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	b, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	lines := 0
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		lines++
+	}
+	assert.Equal(t, 2, lines)
+	assert.Contains(t, string(b), "球状闪电")
+}
+
+func TestRunErrors(t *testing.T) {
+	configPath, _ = writeConfig(t, "http://127.0.0.1:1/")
+	useStorage = false
+
+	taskNames = []string{"no_such_task"}
+	err := Run()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "task no_such_task not found")
+
+	taskNames = nil
+	assert.EqualError(t, Run(), "no task to run")
+
+	configPath = filepath.Join(t.TempDir(), "missing.toml")
+	assert.Error(t, Run())
+}
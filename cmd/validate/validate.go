@@ -0,0 +1,146 @@
+package validate
+
+import (
+	"fmt"
+	"github.com/Nrich-sunny/crawler/cmd/worker"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/engine"
+	"github.com/Nrich-sunny/crawler/log"
+	"github.com/Nrich-sunny/crawler/taskmodule"
+	"github.com/spf13/cobra"
+	"go.uber.org/zap/zapcore"
+	"os"
+	"sort"
+)
+
+var ValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "validate task rules before deployment.",
+	Long:  "validate that tasks in config and task modules exist and their rules refer to each other correctly.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !Run() {
+			os.Exit(1)
+		}
+	},
+}
+
+var configPath string
+var taskNames []string
+var samplePages int
+
+func init() {
+	ValidateCmd.Flags().StringVar(&configPath, "config", "config.toml", "set config file")
+	ValidateCmd.Flags().StringSliceVar(&taskNames, "task", nil, "only validate these tasks")
+	// Go 规则引用的规则名只能通过试运行检查，默认抓取一个页面
+	ValidateCmd.Flags().IntVar(&samplePages, "sample", 1, "fetch at most this many pages to dry run the rules, 0 means static check only")
+}
+
+// Run 校验配置中的任务与任务模块，输出发现的问题，没有问题时返回 true
+func Run() bool {
+	cfg, err := worker.LoadConfig(configPath)
+	if err != nil {
+		fmt.Println("load config failed:", err)
+		return false
+	}
+	logger := log.NewLogger(log.NewStdoutPlugin(zapcore.ErrorLevel))
+
+	var problems []engine.Problem
+
+	// 任务模块
+	var modules []*collect.Task
+	if dir := cfg.Get("modules", "dir").String(""); dir != "" {
+		ms, errs, err := taskmodule.LoadDir(dir, engine.Store.AddJsTask, nil)
+		if err != nil {
+			problems = append(problems, engine.Problem{Task: dir, Msg: err.Error()})
+		}
+		names := make([]string, 0, len(errs))
+		for name := range errs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			problems = append(problems, engine.Problem{Task: name, Msg: errs[name].Error()})
+		}
+		for _, m := range ms {
+			t, _ := engine.Store.Get(m.Name)
+			modules = append(modules, t)
+		}
+	}
+
+	fConfigs, err := collect.ParseFetcherConfigs(cfg.Get("fetchers").Bytes())
+	if err != nil {
+		fmt.Println("parse fetchers config failed:", err)
+		return false
+	}
+	var tConfig []collect.TaskConfig
+	if err := cfg.Get("Tasks").Scan(&tConfig); err != nil {
+		fmt.Println("parse tasks config failed:", err)
+		return false
+	}
+	// 校验时不归档网页
+	for i := range tConfig {
+		tConfig[i].Archive = collect.ArchiveConfig{}
+	}
+	seeds, err := worker.ParseTaskConfig(logger, fConfigs, nil, tConfig)
+	if err != nil {
+		fmt.Println("parse tasks config failed:", err)
+		return false
+	}
+	// 不创建存储，只检查任务选择的存储是否已配置
+	var stConfig worker.StorageConfig
+	if err := cfg.Get("storage").Scan(&stConfig); err != nil {
+		fmt.Println("parse storage config failed:", err)
+		return false
+	}
+	var checked []collect.TaskConfig
+	for _, c := range tConfig {
+		if selected(c.Name) {
+			checked = append(checked, c)
+		}
+	}
+	problems = append(problems, worker.CheckTaskStorages(stConfig, checked)...)
+	// 任务模块没有配置 Fetcher，使用默认的 Fetcher
+	if len(modules) > 0 && samplePages > 0 {
+		defaultCfg := fConfigs[collect.DefaultFetcher]
+		defaultCfg.Logger = logger
+		fetcher, err := collect.NewFetcher(collect.DefaultFetcher, defaultCfg)
+		if err != nil {
+			fmt.Println("create default fetcher failed:", err)
+			return false
+		}
+		for _, m := range modules {
+			m.Fetcher = fetcher
+		}
+	}
+
+	var targets []*collect.Task
+	for _, t := range append(seeds, modules...) {
+		if selected(t.Name) {
+			targets = append(targets, t)
+		}
+	}
+	problems = append(problems, engine.Store.ValidateSeeds(targets, samplePages)...)
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		fmt.Printf("%d tasks validated, %d problems found\n", len(targets), len(problems))
+		return false
+	}
+	fmt.Printf("%d tasks validated, ok\n", len(targets))
+	return true
+}
+
+func selected(name string) bool {
+	if len(taskNames) == 0 {
+		return true
+	}
+	for _, n := range taskNames {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
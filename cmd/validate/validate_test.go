@@ -0,0 +1,115 @@
+package validate
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newBookServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/book/1" {
+			fmt.Fprint(w, `<html><body><h1>三体</h1></body></html>`)
+			return
+		}
+		fmt.Fprint(w, `<html><body><h2><a href="/book/1">三体</a></h2></body></html>`)
+	}))
+}
+
+// writeConfig 在临时目录中生成配置文件，任务写入 storages 中的存储
+func writeConfig(t *testing.T, seed string, storages string) {
+	content := fmt.Sprintf(`[storage]
+type = "jsonl"
+dir = "data/{task}"
+
+[[Tasks]]
+Name = "validate_books"
+Seeds = [%q]
+RootRule = "书籍列表"
+Storages = [%s]
+  [[Tasks.Rules]]
+  Name = "书籍列表"
+  Links = [{CSS = "h2 a", Rule = "书籍简介"}]
+  [[Tasks.Rules]]
+  Name = "书籍简介"
+  Fields = [{Name = "书名", CSS = "h1", Required = true}]
+`, seed, storages)
+	configPath = filepath.Join(t.TempDir(), "config.toml")
+	require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+}
+
+// captureStdout 返回 fn 执行期间写入标准输出的内容
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	defer func() {
+		os.Stdout = stdout
+	}()
+	fn()
+	w.Close()
+	return <-out
+}
+
+func validate(t *testing.T) (bool, string) {
+	var ok bool
+	out := captureStdout(t, func() {
+		ok = Run()
+	})
+	return ok, out
+}
+
+func TestValidate(t *testing.T) {
+	srv := newBookServer()
+	defer srv.Close()
+	taskNames = []string{"validate_books"}
+
+	// 试运行种子页面与链接指向的页面
+	writeConfig(t, srv.URL+"/list", `"default"`)
+	samplePages = 2
+	ok, out := validate(t)
+	assert.True(t, ok, out)
+	assert.Contains(t, out, "1 tasks validated, ok\n")
+
+	// 任务选择的存储没有配置
+	writeConfig(t, srv.URL+"/list", `"default", "files"`)
+	ok, out = validate(t)
+	assert.False(t, ok)
+	assert.Contains(t, out, "files")
+	assert.Contains(t, out, "1 tasks validated, 1 problems found")
+}
+
+func TestValidateSample(t *testing.T) {
+	srv := newBookServer()
+	seed := srv.URL + "/list"
+	srv.Close()
+	taskNames = []string{"validate_books"}
+	writeConfig(t, seed, `"default"`)
+
+	// 只做静态检查时不抓取页面
+	samplePages = 0
+	ok, out := validate(t)
+	assert.True(t, ok, out)
+
+	samplePages = 1
+	ok, out = validate(t)
+	assert.False(t, ok)
+	assert.Contains(t, out, "fetch "+seed+" failed")
+
+	configPath = filepath.Join(t.TempDir(), "missing.toml")
+	ok, out = validate(t)
+	assert.False(t, ok)
+	assert.Contains(t, out, "load config failed")
+}
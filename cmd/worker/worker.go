@@ -1,14 +1,20 @@
 package worker
 
 import (
+	"fmt"
+	"github.com/Nrich-sunny/crawler/archive"
 	"github.com/Nrich-sunny/crawler/collect"
 	"github.com/Nrich-sunny/crawler/engine"
 	"github.com/Nrich-sunny/crawler/limiter"
 	"github.com/Nrich-sunny/crawler/log"
+	"github.com/Nrich-sunny/crawler/master"
 	pb "github.com/Nrich-sunny/crawler/proto/greeter"
-	"github.com/Nrich-sunny/crawler/proxy"
 	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/Nrich-sunny/crawler/storage/filestorage"
+	"github.com/Nrich-sunny/crawler/storage/natsstorage"
+	"github.com/Nrich-sunny/crawler/storage/router"
 	"github.com/Nrich-sunny/crawler/storage/sqlstorage"
+	"github.com/Nrich-sunny/crawler/taskmodule"
 	"github.com/go-micro/plugins/v4/config/encoder/toml"
 	etcdReg "github.com/go-micro/plugins/v4/registry/etcd"
 	gs "github.com/go-micro/plugins/v4/server/grpc"
@@ -23,6 +29,7 @@ import (
 	"go-micro.dev/v4/config/source/file"
 	"go-micro.dev/v4/registry"
 	"go-micro.dev/v4/server"
+	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"golang.org/x/net/context"
@@ -61,13 +68,7 @@ var GRPCListenAddress string
 
 func Run() {
 	// load config
-	enc := toml.NewEncoder()
-	cfg, err := config.NewConfig(config.WithReader(json.NewReader(reader.WithEncoder(enc))))
-	err = cfg.Load(file.NewSource(
-		//file.WithPath("github.com/Nrich-sunny/crawler/config.toml"),
-		file.WithPath("config.toml"),
-		source.WithEncoder(enc),
-	))
+	cfg, err := LoadConfig("config.toml")
 	if err != nil {
 		panic(err)
 	}
@@ -85,34 +86,35 @@ func Run() {
 	// set zap global logger
 	zap.ReplaceGlobals(logger)
 
-	// proxy
-	proxyURLs := cfg.Get("fetcher", "proxy").StringSlice([]string{})
-	timeout := cfg.Get("fetcher", "timeout").Int(5000)
-	logger.Sugar().Info("proxy list: ", proxyURLs, " timeout: ", timeout)
-	p, err := proxy.RoundRobinProxySwitcher(proxyURLs...)
-	if err != nil {
-		logger.Error("RoundRobinProxySwitcher failed.", zap.Error(err))
+	// storage
+	var stConfig StorageConfig
+	if err := cfg.Get("storage").Scan(&stConfig); err != nil {
+		logger.Error("get storage config failed", zap.Error(err))
 		return
 	}
-
-	// storage
-	sqlUrl := cfg.Get("storage", "sqlUrl").String("")
-	var storage storage.Storage
-	storage, err = sqlstorage.New(
-		sqlstorage.WithSqlUrl(sqlUrl),
-		sqlstorage.WithLogger(logger.Named("sqlDB")),
-		sqlstorage.WithBatchCount(2),
-	)
+	storageRouter, err := NewRouter(logger, stConfig)
 	if err != nil {
-		logger.Error("create sqlstorage failed", zap.Error(err))
+		logger.Error("create storage failed", zap.Error(err))
+		return
+	}
+	if storageRouter == nil {
+		logger.Error("storage is not configured")
 		return
 	}
+	var storage storage.Storage = storageRouter
 
 	// fetcher
-	var fetcher collect.Fetcher = &collect.BrowserFetch{
-		Timeout: time.Duration(timeout) * time.Millisecond,
-		Proxy:   p,
-		Logger:  logger,
+	fConfigs, err := collect.ParseFetcherConfigs(cfg.Get("fetchers").Bytes())
+	if err != nil {
+		logger.Error("parse fetchers config failed", zap.Error(err))
+		return
+	}
+	defaultCfg := fConfigs[collect.DefaultFetcher]
+	defaultCfg.Logger = logger
+	fetcher, err := collect.NewFetcher(collect.DefaultFetcher, defaultCfg)
+	if err != nil {
+		logger.Error("create default fetcher failed", zap.Error(err))
+		return
 	}
 
 	// init tasks
@@ -120,30 +122,67 @@ func Run() {
 	if err := cfg.Get("Tasks").Scan(&tConfig); err != nil {
 		logger.Error("init seed tasks ", zap.Error(err))
 	}
-	seeds := ParseTaskConfig(logger, fetcher, storage, tConfig)
+	seeds, err := ParseTaskConfig(logger, fConfigs, storage, tConfig)
+	if err != nil {
+		logger.Error("parse task config failed", zap.Error(err))
+		return
+	}
+
+	var sConfig ServerConfig
+	if err := cfg.Get("GRPCServer").Scan(&sConfig); err != nil {
+		logger.Error("get GRPC Server config failed", zap.Error(err))
+	}
+	logger.Sugar().Debugf("grpc server config,%+v", sConfig)
+
+	etcdCli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{sConfig.RegistryAddress},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		logger.Error("create etcd client failed", zap.Error(err))
+		return
+	}
 
-	_ = engine.NewEngine(
+	crawler := engine.NewEngine(
 		engine.WithFetcher(fetcher),
 		engine.WithLogger(logger),
 		engine.WithWorkCount(5),
 		engine.WithSeeds(seeds),
 		engine.WithScheduler(engine.NewSchedule()),
+		engine.WithTaskListener(master.ReportTaskState(logger, etcdCli)),
+		engine.WithDaemon(true),
 	)
 
-	// worker start
-	//go crawler.Run()
+	// 执行客户端通过 Master 对任务的操作，节点 ID 与注册中心中的一致
+	go master.WatchTaskControl(context.Background(), etcdCli, sConfig.Name+"-"+workerID, master.ControlTask(logger, crawler))
 
-	var sConfig ServerConfig
-	if err := cfg.Get("GRPCServer").Scan(&sConfig); err != nil {
-		logger.Error("get GRPC Server config failed", zap.Error(err))
+	// 加载目录中和通过 Master 上传的 JS 任务模块
+	if err := LoadTaskModules(logger, cfg.Get("modules", "dir").String(""),
+		time.Duration(cfg.Get("modules", "interval").Int(10))*time.Second, sConfig.RegistryAddress); err != nil {
+		logger.Error("load task modules failed", zap.Error(err))
 	}
-	logger.Sugar().Debugf("grpc server config,%+v", sConfig)
+
+	// worker start，任务模块加载后再启动，种子任务可以引用 JS 任务
+	go crawler.Run()
 
 	// start http proxy to GRPC
 	go RunHTTPServer(sConfig)
 
 	// start grpc server
 	RunGRPCServer(logger, sConfig)
+
+	// 关闭归档文件，去掉正在写入的文件的 .open 后缀
+	closeArchivers(logger, seeds)
+}
+
+func closeArchivers(logger *zap.Logger, seeds []*collect.Task) {
+	for _, seed := range seeds {
+		if seed.Archiver != nil {
+			if err := seed.Archiver.Close(); err != nil {
+				logger.Error("close archiver failed", zap.String("task", seed.Name), zap.Error(err))
+			}
+		}
+	}
 }
 
 func RunGRPCServer(logger *zap.Logger, cfg ServerConfig) {
@@ -212,8 +251,179 @@ type ServerConfig struct {
 	Name             string
 }
 
-func ParseTaskConfig(logger *zap.Logger, f collect.Fetcher, s storage.Storage, cfgs []collect.TaskConfig) []*collect.Task {
+// StorageConfig 数据存储的配置
+type StorageConfig struct {
+	Name    string // 存储名，任务通过 Storages 选择，[storage] 中的存储名为 default
+	Type    string // 存储类型：sql(默认)、jsonl、csv、parquet 或 nats
+	SqlUrl  string // 数据库的连接地址
+	Dir     string // 文件的输出目录，{task} 替换为任务名
+	MaxSize int    // 单个文件的最大大小，MB
+	Rotate  int    // 单个文件的最长写入时间，秒
+	Gzip    bool   // 压缩文件
+	Nats    NatsConfig
+	Spool   string          // 写入失败的数据保存的目录，只在 [storage] 中生效
+	Retry   int             // 重新写入失败数据的间隔，秒
+	Sinks   []StorageConfig // 其他的存储
+}
+
+// NatsConfig 发布到 NATS JetStream 的配置
+type NatsConfig struct {
+	Url        string
+	Subject    string // 主题前缀，数据发布到 {Subject}.{task}
+	Stream     string // 不为空时创建保存数据的流
+	Encoding   string // json(默认) 或 protobuf
+	Partitions int    // 按网址哈希分区的数量
+}
+
+// NewStorage 根据配置创建存储，没有配置存储时返回 nil
+func NewStorage(logger *zap.Logger, cfg StorageConfig) (storage.Storage, error) {
+	switch cfg.Type {
+	case "", "sql":
+		if cfg.SqlUrl == "" {
+			return nil, nil
+		}
+		return sqlstorage.New(
+			sqlstorage.WithSqlUrl(cfg.SqlUrl),
+			sqlstorage.WithLogger(logger.Named("sqlDB")),
+			sqlstorage.WithBatchCount(2),
+		)
+	case "nats":
+		opts := []natsstorage.Option{
+			natsstorage.WithLogger(logger.Named("nats")),
+			natsstorage.WithStream(cfg.Nats.Stream),
+			natsstorage.WithPartitions(cfg.Nats.Partitions),
+		}
+		if cfg.Nats.Url != "" {
+			opts = append(opts, natsstorage.WithUrl(cfg.Nats.Url))
+		}
+		if cfg.Nats.Subject != "" {
+			opts = append(opts, natsstorage.WithSubject(cfg.Nats.Subject))
+		}
+		if cfg.Nats.Encoding != "" {
+			opts = append(opts, natsstorage.WithEncoding(cfg.Nats.Encoding))
+		}
+		return natsstorage.New(opts...)
+	default:
+		opts := []filestorage.Option{
+			filestorage.WithFormat(cfg.Type),
+			filestorage.WithLogger(logger.Named("file")),
+			filestorage.WithGzip(cfg.Gzip),
+			filestorage.WithMaxAge(time.Duration(cfg.Rotate) * time.Second),
+		}
+		if cfg.Dir != "" {
+			opts = append(opts, filestorage.WithDir(cfg.Dir))
+		}
+		if cfg.MaxSize > 0 {
+			opts = append(opts, filestorage.WithMaxSize(int64(cfg.MaxSize)<<20))
+		}
+		return filestorage.New(opts...)
+	}
+}
+
+// NewRouter 根据配置创建所有的存储，没有配置存储时返回 nil
+func NewRouter(logger *zap.Logger, cfg StorageConfig) (*router.Router, error) {
+	opts := []router.Option{
+		router.WithLogger(logger.Named("router")),
+		router.WithSpoolDir(cfg.Spool),
+	}
+	if cfg.Retry > 0 {
+		opts = append(opts, router.WithRetryInterval(time.Duration(cfg.Retry)*time.Second))
+	}
+	r := router.New(opts...)
+	cfg.Name = router.DefaultSink
+	for _, sc := range append([]StorageConfig{cfg}, cfg.Sinks...) {
+		s, err := NewStorage(logger, sc)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("create storage %s failed:%w", sc.Name, err)
+		}
+		if s == nil {
+			continue
+		}
+		if err := r.AddSink(sc.Name, s); err != nil {
+			s.Close()
+			r.Close()
+			return nil, err
+		}
+	}
+	if len(r.Sinks()) == 0 {
+		return nil, nil
+	}
+	return r, nil
+}
+
+// SinkNames 返回配置中会创建的存储名，[storage] 中的存储名为 default
+func (cfg StorageConfig) SinkNames() []string {
+	var names []string
+	cfg.Name = router.DefaultSink
+	for _, sc := range append([]StorageConfig{cfg}, cfg.Sinks...) {
+		// 与 NewStorage 相同，没有配置 SqlUrl 的数据库不会创建
+		if (sc.Type == "" || sc.Type == "sql") && sc.SqlUrl == "" {
+			continue
+		}
+		names = append(names, sc.Name)
+	}
+	return names
+}
+
+// CheckTaskStorages 不创建存储，检查任务选择的存储是否都已配置
+func CheckTaskStorages(cfg StorageConfig, tasks []collect.TaskConfig) []engine.Problem {
+	configured := make(map[string]bool)
+	for _, name := range cfg.SinkNames() {
+		configured[name] = true
+	}
+	var problems []engine.Problem
+	for _, t := range tasks {
+		for _, name := range t.Storages {
+			if !configured[name] {
+				problems = append(problems, engine.Problem{Task: t.Name, Msg: fmt.Sprintf("storage %q is not configured", name)})
+			}
+		}
+	}
+	return problems
+}
+
+// LoadConfig 加载 TOML 格式的配置文件
+func LoadConfig(path string) (config.Config, error) {
+	enc := toml.NewEncoder()
+	cfg, err := config.NewConfig(config.WithReader(json.NewReader(reader.WithEncoder(enc))))
+	if err != nil {
+		return nil, err
+	}
+	err = cfg.Load(file.NewSource(
+		file.WithPath(path),
+		source.WithEncoder(enc),
+	))
+	return cfg, err
+}
+
+// LoadTaskModules 监听任务模块目录与 etcd，将任务模块注册到全局的爬虫种类实例中
+func LoadTaskModules(logger *zap.Logger, dir string, interval time.Duration, registryAddress string) error {
+	opts := []taskmodule.Option{
+		taskmodule.WithLogger(logger.Named("taskmodule")),
+		taskmodule.WithInterval(interval),
+	}
+	if dir != "" {
+		go taskmodule.NewDirWatcher(dir, engine.Store.AddJsTask, opts...).Run(context.Background())
+	}
+
+	cli, err := clientv3.New(clientv3.Config{
+		Endpoints:   []string{registryAddress},
+		DialTimeout: 5 * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+	return taskmodule.WatchEtcd(context.Background(), cli, engine.Store.AddJsTask, opts...)
+}
+
+// ParseTaskConfig 根据配置生成任务，任务引用了未注册的 Fetcher 或存储时返回错误
+func ParseTaskConfig(logger *zap.Logger, fConfigs map[string]collect.FetcherConfig, s storage.Storage, cfgs []collect.TaskConfig) ([]*collect.Task, error) {
+	if err := engine.LoadBuiltinTasks(); err != nil {
+		return nil, err
+	}
 	tasks := make([]*collect.Task, 0, 1000)
+	fetchers := make(map[string]collect.Fetcher) // 同名的 Fetcher 在任务之间共享
 	for _, cfg := range cfgs {
 		t := collect.NewTask(
 			collect.WithName(cfg.Name),
@@ -223,6 +433,19 @@ func ParseTaskConfig(logger *zap.Logger, f collect.Fetcher, s storage.Storage, c
 			collect.WithStorage(s),
 		)
 
+		// 没有创建存储时（例如 master、validate 与 parse）不解析任务的存储
+		if len(cfg.Storages) > 0 && s != nil {
+			r, ok := s.(*router.Router)
+			if !ok {
+				return nil, fmt.Errorf("task %s: storages %v are not configured", cfg.Name, cfg.Storages)
+			}
+			route, err := r.Route(cfg.Storages...)
+			if err != nil {
+				return nil, fmt.Errorf("task %s: %w", cfg.Name, err)
+			}
+			t.Storage = route
+		}
+
 		if cfg.WaitTime > 0 {
 			t.WaitTime = cfg.WaitTime
 		}
@@ -231,6 +454,26 @@ func ParseTaskConfig(logger *zap.Logger, f collect.Fetcher, s storage.Storage, c
 			t.MaxDepth = cfg.MaxDepth
 		}
 
+		if cfg.Schedule != "" {
+			if _, err := collect.ParseSchedule(cfg.Schedule); err != nil {
+				return nil, fmt.Errorf("task %s: %w", cfg.Name, err)
+			}
+			t.Schedule = cfg.Schedule
+		}
+
+		if cfg.Incremental {
+			t.Incremental = true
+			t.Recrawl = time.Duration(cfg.Recrawl) * time.Second
+		}
+
+		if len(cfg.Pipeline) > 0 {
+			p, err := collect.NewPipeline(cfg.Pipeline)
+			if err != nil {
+				return nil, fmt.Errorf("task %s: %w", cfg.Name, err)
+			}
+			t.Pipeline = p
+		}
+
 		var limits []limiter.RateLimiter
 		if len(cfg.Limits) > 0 {
 			for _, lcfg := range cfg.Limits {
@@ -242,11 +485,55 @@ func ParseTaskConfig(logger *zap.Logger, f collect.Fetcher, s storage.Storage, c
 			t.Limit = multiLimiter
 		}
 
-		switch cfg.Fetcher {
-		case "browser":
-			t.Fetcher = f
+		// 声明式规则，编译后注册到全局的爬虫种类实例中
+		if len(cfg.Rules) > 0 {
+			tree, err := collect.CompileRuleTree(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("task %s: %w", cfg.Name, err)
+			}
+			tree.AddFields(t.Pipeline.Fields()...)
+			t.Rule = tree
+			engine.Store.Add(t)
+		} else if fields := t.Pipeline.Fields(); len(fields) > 0 {
+			// 处理器添加的字段加入已注册任务的规则，存储据此创建列
+			if registered, ok := engine.Store.Get(cfg.Name); ok {
+				registered.Rule.AddFields(fields...)
+			}
+		}
+
+		if cfg.Archive.Dir != "" {
+			opts := []archive.Option{
+				archive.WithDir(cfg.Archive.Dir),
+				archive.WithPrefix(cfg.Name),
+				archive.WithLogger(logger.Named("archive")),
+			}
+			if cfg.Archive.MaxSize > 0 {
+				opts = append(opts, archive.WithMaxSize(int64(cfg.Archive.MaxSize)<<20))
+			}
+			a, err := archive.NewWarcWriter(opts...)
+			if err != nil {
+				return nil, fmt.Errorf("task %s: create archiver failed:%w", cfg.Name, err)
+			}
+			t.Archiver = a
+		}
+
+		name := cfg.Fetcher
+		if name == "" {
+			name = collect.DefaultFetcher
+		}
+		f, ok := fetchers[name]
+		if !ok {
+			fCfg := fConfigs[name]
+			fCfg.Logger = logger
+			var err error
+			f, err = collect.NewFetcher(name, fCfg)
+			if err != nil {
+				return nil, fmt.Errorf("task %s: %w", cfg.Name, err)
+			}
+			fetchers[name] = f
 		}
+		t.Fetcher = f
 		tasks = append(tasks, t)
 	}
-	return tasks
+	return tasks, nil
 }
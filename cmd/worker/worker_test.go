@@ -0,0 +1,63 @@
+package worker_test
+
+import (
+	"github.com/Nrich-sunny/crawler/cmd/worker"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/Nrich-sunny/crawler/storage/router"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+)
+
+type nopStorage struct{}
+
+func (nopStorage) Save(datas ...*storage.DataCell) error { return nil }
+func (nopStorage) Flush() error                          { return nil }
+func (nopStorage) Close() error                          { return nil }
+
+func taskConfig(storages ...string) collect.TaskConfig {
+	return collect.TaskConfig{
+		Name:     "worker_storages",
+		Seeds:    []string{"https://book.douban.com/subject/1/"},
+		RootRule: "书籍简介",
+		Storages: storages,
+		Rules: []collect.RuleConfig{{
+			Name:   "书籍简介",
+			Fields: []collect.FieldConfig{{Name: "书名", Selector: collect.Selector{CSS: "h1"}}},
+		}},
+	}
+}
+
+func TestParseTaskConfigStorages(t *testing.T) {
+	// 没有创建存储时（master、validate 与 parse）不解析任务的存储
+	seeds, err := worker.ParseTaskConfig(zap.NewNop(), nil, nil, []collect.TaskConfig{taskConfig("default", "files")})
+	require.NoError(t, err)
+	require.Len(t, seeds, 1)
+	assert.Nil(t, seeds[0].Storage)
+
+	r := router.New(router.WithSpoolDir(t.TempDir()))
+	defer r.Close()
+	require.NoError(t, r.AddSink(router.DefaultSink, nopStorage{}))
+	seeds, err = worker.ParseTaskConfig(zap.NewNop(), nil, r, []collect.TaskConfig{taskConfig("default")})
+	require.NoError(t, err)
+	assert.IsType(t, &router.Route{}, seeds[0].Storage)
+	_, err = worker.ParseTaskConfig(zap.NewNop(), nil, r, []collect.TaskConfig{taskConfig("files")})
+	assert.Error(t, err)
+}
+
+func TestCheckTaskStorages(t *testing.T) {
+	cfg := worker.StorageConfig{
+		Type:  "jsonl",
+		Sinks: []worker.StorageConfig{{Name: "files", Type: "csv"}, {Name: "mysql"}},
+	}
+	assert.Equal(t, []string{"default", "files"}, cfg.SinkNames())
+	assert.Empty(t, worker.CheckTaskStorages(cfg, []collect.TaskConfig{taskConfig("default", "files")}))
+
+	// 没有配置 SqlUrl 的数据库不会创建
+	problems := worker.CheckTaskStorages(cfg, []collect.TaskConfig{taskConfig("files", "mysql")})
+	require.Len(t, problems, 1)
+	assert.Equal(t, "worker_storages", problems[0].Task)
+	assert.Contains(t, problems[0].Msg, "mysql")
+}
@@ -0,0 +1,102 @@
+package collect
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize CacheFetch 默认最多缓存的响应数
+const DefaultCacheSize = 1000
+
+// CacheFetch 对 Fetcher 的结果进行内存缓存，相同的请求在有效期内不会重复访问网站。
+// 最多缓存 Size 个响应，超出时淘汰最久没有使用的响应
+type CacheFetch struct {
+	Fetcher Fetcher
+	TTL     time.Duration // 缓存有效期，为 0 时永不过期
+	Size    int           // 最多缓存的响应数
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // 请求唯一标识 -> 缓存内容
+	recent  *list.List               // 缓存内容，最近使用的在前
+}
+
+type cacheEntry struct {
+	key      string
+	resp     *Response
+	expireAt time.Time
+}
+
+// NewCacheFetch size 为 0 时使用 DefaultCacheSize
+func NewCacheFetch(f Fetcher, ttl time.Duration, size int) *CacheFetch {
+	if size <= 0 {
+		size = DefaultCacheSize
+	}
+	return &CacheFetch{
+		Fetcher: f,
+		TTL:     ttl,
+		Size:    size,
+		entries: make(map[string]*list.Element),
+		recent:  list.New(),
+	}
+}
+
+func (c *CacheFetch) Get(req *Request) ([]byte, error) {
+	resp, err := c.Fetch(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Fetch 缓存完整的响应，被缓存的 Fetcher 不能返回原始响应时只有 Body
+func (c *CacheFetch) Fetch(req *Request) (*Response, error) {
+	key := req.Unique()
+	if resp, ok := c.get(key); ok {
+		return resp, nil
+	}
+
+	resp, err := Fetch(c.Fetcher, req)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &cacheEntry{key: key, resp: resp}
+	if c.TTL > 0 {
+		e.expireAt = time.Now().Add(c.TTL)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = e
+		c.recent.MoveToFront(elem)
+		return resp, nil
+	}
+	c.entries[key] = c.recent.PushFront(e)
+	for c.recent.Len() > c.Size {
+		c.remove(c.recent.Back())
+	}
+	return resp, nil
+}
+
+// get 返回未过期的缓存，过期的缓存被删除
+func (c *CacheFetch) get(key string) (*Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*cacheEntry)
+	if !e.expireAt.IsZero() && !time.Now().Before(e.expireAt) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.recent.MoveToFront(elem)
+	return e.resp, true
+}
+
+func (c *CacheFetch) remove(elem *list.Element) {
+	c.recent.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
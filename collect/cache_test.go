@@ -0,0 +1,42 @@
+package collect_test
+
+import (
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// countFetch 记录每个网址被抓取的次数
+type countFetch map[string]int
+
+func (f countFetch) Get(req *collect.Request) ([]byte, error) {
+	f[req.Url]++
+	return []byte(req.Url), nil
+}
+
+func TestCacheFetch(t *testing.T) {
+	f := countFetch{}
+	c := collect.NewCacheFetch(f, 0, 2)
+	get := func(url string) {
+		body, err := c.Get(&collect.Request{Url: url, Method: "GET"})
+		require.NoError(t, err)
+		assert.Equal(t, url, string(body))
+	}
+	// 超出数量时淘汰最久没有使用的响应
+	for _, url := range []string{"a", "b", "a", "c", "a", "b"} {
+		get(url)
+	}
+	assert.Equal(t, countFetch{"a": 1, "b": 2, "c": 1}, f)
+
+	// 过期的响应重新抓取
+	f = countFetch{}
+	c = collect.NewCacheFetch(f, 20*time.Millisecond, 0)
+	assert.Equal(t, collect.DefaultCacheSize, c.Size)
+	get("a")
+	get("a")
+	time.Sleep(30 * time.Millisecond)
+	get("a")
+	assert.Equal(t, 2, f["a"])
+}
@@ -2,6 +2,7 @@ package collect
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
 	"github.com/Nrich-sunny/crawler/extensions"
 	"github.com/Nrich-sunny/crawler/proxy"
@@ -19,10 +20,45 @@ type Fetcher interface {
 	Get(req *Request) ([]byte, error)
 }
 
+// Response 一次 HTTP 抓取的原始响应，用于归档
+type Response struct {
+	Proto  string // 协议版本，例如 HTTP/1.1
+	Status string // 状态，例如 200 OK
+	Header http.Header
+	Raw    []byte // 服务器返回的原始正文(未转码)
+	Body   []byte // 转为 UTF-8 后的正文
+}
+
+// ResponseFetcher 能够返回原始响应的 Fetcher，任务需要归档时使用 Fetch 代替 Get
+type ResponseFetcher interface {
+	Fetcher
+	Fetch(req *Request) (*Response, error)
+}
+
+// Fetch 使用 f 抓取请求，f 不能返回原始响应时，返回的 Response 只有 Body
+func Fetch(f Fetcher, req *Request) (*Response, error) {
+	if rf, ok := f.(ResponseFetcher); ok {
+		return rf.Fetch(req)
+	}
+	body, err := f.Get(req)
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Body: body}, nil
+}
+
 type BaseFetch struct {
 }
 
-func (BaseFetch) Get(req *Request) ([]byte, error) {
+func (b BaseFetch) Get(req *Request) ([]byte, error) {
+	resp, err := b.Fetch(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (BaseFetch) Fetch(req *Request) (*Response, error) {
 
 	resp, err := http.Get(req.Url)
 
@@ -36,20 +72,46 @@ func (BaseFetch) Get(req *Request) ([]byte, error) {
 		return nil, fmt.Errorf("error status code: %d", resp.StatusCode)
 	}
 
-	bodyReader := bufio.NewReader(resp.Body)
-	e := DetermineEncoding(bodyReader)
-	utf8Reader := transform.NewReader(bodyReader, e.NewDecoder())
-	return io.ReadAll(utf8Reader)
+	return readResponse(resp)
+}
+
+// readResponse 读取原始正文，并按检测到的编码转为 UTF-8
+func readResponse(resp *http.Response) (*Response, error) {
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	e := DetermineEncoding(bufio.NewReader(bytes.NewReader(raw)))
+	body, err := io.ReadAll(transform.NewReader(bytes.NewReader(raw), e.NewDecoder()))
+	if err != nil {
+		return nil, err
+	}
+	return &Response{
+		Proto:  resp.Proto,
+		Status: resp.Status,
+		Header: resp.Header,
+		Raw:    raw,
+		Body:   body,
+	}, nil
 }
 
 // 模拟浏览器访问
 type BrowserFetch struct {
 	Timeout time.Duration
-	Proxy   proxy.ProxyFunc // 是 Transport 结构体中的函数
+	Proxy   proxy.ProxyFunc   // 是 Transport 结构体中的函数
+	Headers map[string]string // 附加的请求头
 	Logger  *zap.Logger
 }
 
 func (b BrowserFetch) Get(request *Request) ([]byte, error) {
+	resp, err := b.Fetch(request)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (b BrowserFetch) Fetch(request *Request) (*Response, error) {
 	client := &http.Client{
 		Timeout: b.Timeout,
 	}
@@ -70,16 +132,17 @@ func (b BrowserFetch) Get(request *Request) ([]byte, error) {
 	}
 	//req.Header.Set("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/105.0.0.0 Safari/537.36")
 	req.Header.Set("User-Agent", extensions.GenerateRandomUA())
+	for k, v := range b.Headers {
+		req.Header.Set(k, v)
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	bodyReader := bufio.NewReader(resp.Body)
-	e := DetermineEncoding(bodyReader)
-	utf8Reader := transform.NewReader(bodyReader, e.NewDecoder())
-	return io.ReadAll(utf8Reader)
+	return readResponse(resp)
 }
 
 func DetermineEncoding(r *bufio.Reader) encoding.Encoding {
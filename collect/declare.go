@@ -0,0 +1,364 @@
+package collect
+
+/** 声明式的采集规则：在 TaskConfig 中通过 CSS 选择器、XPath 表达式或 JSON 路径描述
+**	数据字段与需要继续跟进的链接，加载配置时编译为 Rule.ParseFunc
+ */
+
+import (
+	"errors"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"github.com/antchfx/htmlquery"
+	"github.com/antchfx/xpath"
+	"github.com/tidwall/gjson"
+	"golang.org/x/net/html"
+	"strings"
+)
+
+// 规则的类型
+const (
+	RuleTypeHTML = "html" // 解析 HTML 页面，使用 CSS 选择器或 XPath
+	RuleTypeJSON = "json" // 解析 JSON 接口，使用 gjson 路径
+)
+
+// Selector CSS 选择器、XPath 表达式或 JSON 路径，只能选其一
+type Selector struct {
+	CSS   string
+	XPath string
+	JSON  string // gjson 路径，例如 data.items、data.items.#.url
+	Attr  string // 取节点的属性值，为空时取节点的文本
+}
+
+// FieldConfig 数据字段
+type FieldConfig struct {
+	Name     string    // 字段名
+	Type     FieldType // 字段类型，为空时不检查。取到的值先转换为该类型，例如 "45.00元" 转换为 45
+	Required bool      // 必须有值
+	Selector
+}
+
+// LinkConfig 需要继续跟进的链接
+type LinkConfig struct {
+	Selector        // Attr 为空时取 href 属性
+	Rule     string // 链接对应的规则名
+	Priority int
+	Param    string // 不为空时，取到的值作为当前网址的查询参数 Param 生成新的请求，用于游标翻页
+}
+
+// RuleConfig 声明式的采集规则
+type RuleConfig struct {
+	Name    string        // 规则名
+	Type    string        // 规则类型，html(默认) 或 json
+	Item    Selector      // 每个数据项所在的节点(JSON 中为数组)，为空时整个页面为一个数据项
+	Fields  []FieldConfig // 数据字段
+	Links   []LinkConfig  // 需要继续跟进的链接
+	Key     []string      // 数据的自然键，参见 Rule.Key
+	History bool          // 保留数据的旧版本
+}
+
+// compiledSelector 编译后的选择器
+type compiledSelector struct {
+	css   cascadia.Sel
+	xpath *xpath.Expr
+	json  string
+	attr  string
+}
+
+func (s Selector) empty() bool {
+	return s.CSS == "" && s.XPath == "" && s.JSON == ""
+}
+
+func compileSelector(s Selector, ruleType string) (*compiledSelector, error) {
+	set := 0
+	for _, v := range []string{s.CSS, s.XPath, s.JSON} {
+		if v != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, errors.New("only one of css, xpath and json can be set")
+	}
+	if ruleType == RuleTypeJSON && s.JSON == "" {
+		return nil, errors.New("json path is required for json rule")
+	}
+	if ruleType != RuleTypeJSON && s.JSON != "" {
+		return nil, errors.New("json path is only allowed in json rule")
+	}
+	c := &compiledSelector{attr: s.Attr}
+	var err error
+	switch {
+	case s.JSON != "":
+		c.json = s.JSON
+	case s.CSS != "":
+		if c.css, err = cascadia.Parse(s.CSS); err != nil {
+			return nil, fmt.Errorf("compile css %q failed:%w", s.CSS, err)
+		}
+	case s.XPath != "":
+		if c.xpath, err = xpath.Compile(s.XPath); err != nil {
+			return nil, fmt.Errorf("compile xpath %q failed:%w", s.XPath, err)
+		}
+	default:
+		return nil, errors.New("css, xpath or json is required")
+	}
+	return c, nil
+}
+
+// nodes 返回 root 下所有匹配的节点
+func (c *compiledSelector) nodes(root *html.Node) []*html.Node {
+	if c.css != nil {
+		return cascadia.QueryAll(root, c.css)
+	}
+	return htmlquery.QuerySelectorAll(root, c.xpath)
+}
+
+// value 返回第一个匹配节点的属性值或文本
+func (c *compiledSelector) value(root *html.Node) string {
+	nodes := c.nodes(root)
+	if len(nodes) == 0 {
+		return ""
+	}
+	return nodeValue(nodes[0], c.attr)
+}
+
+func nodeValue(n *html.Node, attr string) string {
+	if attr != "" {
+		for _, a := range n.Attr {
+			if a.Key == attr {
+				return strings.TrimSpace(a.Val)
+			}
+		}
+		return ""
+	}
+	// XPath 可能直接选中文本或属性节点
+	if n.Type == html.TextNode {
+		return strings.TrimSpace(n.Data)
+	}
+	return strings.TrimSpace(goquery.NewDocumentFromNode(n).Text())
+}
+
+type compiledField struct {
+	name string
+	typ  FieldType
+	sel  *compiledSelector
+}
+
+// typed 将取到的值转换为字段声明的 int、float 或 bool 类型。
+// HTML 中取到的值都是字符串，JSON 中的数字都是 float64。无法转换时保留原值，由 Schema 的校验报告
+func (f compiledField) typed(v interface{}) interface{} {
+	switch f.typ {
+	case FieldInt, FieldFloat, FieldBool:
+	default:
+		return v
+	}
+	if v == nil {
+		return nil
+	}
+	c, err := coerce(v, f.typ)
+	if err != nil {
+		return v
+	}
+	return c
+}
+
+type compiledLink struct {
+	sel      *compiledSelector
+	rule     string
+	priority int
+	param    string
+}
+
+// newRequest 根据链接取到的值生成请求
+func (l compiledLink) newRequest(ctx *Context, v string) *Request {
+	var u string
+	if l.param != "" {
+		u = ctx.QueryURL(l.param, v)
+	} else {
+		u = ctx.AbsURL(v)
+	}
+	if u == "" {
+		return nil
+	}
+	req := ctx.NewRequest(u, l.rule)
+	req.Priority = l.priority
+	return req
+}
+
+type compiledRule struct {
+	json   bool
+	item   *compiledSelector
+	fields []compiledField
+	links  []compiledLink
+}
+
+// parse 即编译生成的 Rule.ParseFunc
+func (r *compiledRule) parse(ctx *Context) (ParseResult, error) {
+	if r.json {
+		return r.parseJSON(ctx)
+	}
+	doc, err := ctx.Doc()
+	if err != nil {
+		return ParseResult{}, err
+	}
+	root := doc.Nodes[0]
+	result := ParseResult{}
+
+	if len(r.fields) > 0 {
+		scopes := []*html.Node{root}
+		if r.item != nil {
+			scopes = r.item.nodes(root)
+		}
+		for _, scope := range scopes {
+			item := make(map[string]interface{}, len(r.fields))
+			for _, f := range r.fields {
+				item[f.name] = f.typed(f.sel.value(scope))
+			}
+			result.Items = append(result.Items, ctx.Output(item))
+		}
+	}
+
+	for _, l := range r.links {
+		for _, n := range l.sel.nodes(root) {
+			if req := l.newRequest(ctx, nodeValue(n, l.sel.attr)); req != nil {
+				result.Requests = append(result.Requests, req)
+			}
+		}
+	}
+	return result, nil
+}
+
+// parseJSON 解析 JSON 接口，Item 指向的数组中每个元素输出一个数据项
+func (r *compiledRule) parseJSON(ctx *Context) (ParseResult, error) {
+	if !gjson.ValidBytes(ctx.Body) {
+		return ParseResult{}, errors.New("invalid json")
+	}
+	root := gjson.ParseBytes(ctx.Body)
+	result := ParseResult{}
+
+	if len(r.fields) > 0 {
+		scopes := []gjson.Result{root}
+		if r.item != nil {
+			scopes = jsonValues(root.Get(r.item.json))
+		}
+		for _, scope := range scopes {
+			item := make(map[string]interface{}, len(r.fields))
+			for _, f := range r.fields {
+				item[f.name] = f.typed(scope.Get(f.sel.json).Value())
+			}
+			result.Items = append(result.Items, ctx.Output(item))
+		}
+	}
+
+	for _, l := range r.links {
+		for _, v := range jsonValues(root.Get(l.sel.json)) {
+			if req := l.newRequest(ctx, v.String()); req != nil {
+				result.Requests = append(result.Requests, req)
+			}
+		}
+	}
+	return result, nil
+}
+
+// jsonValues 将数组展开为元素列表，不存在或为 null 时返回空
+func jsonValues(v gjson.Result) []gjson.Result {
+	if !v.Exists() || v.Type == gjson.Null {
+		return nil
+	}
+	if v.IsArray() {
+		return v.Array()
+	}
+	return []gjson.Result{v}
+}
+
+// CompileRuleTree 将 TaskConfig 中声明的规则编译为规则树，
+// Seeds 作为根节点的请求，由 RootRule 规则解析
+func CompileRuleTree(cfg TaskConfig) (RuleTree, error) {
+	tree := RuleTree{Trunk: make(map[string]*Rule, len(cfg.Rules))}
+	for _, rc := range cfg.Rules {
+		if rc.Name == "" {
+			return RuleTree{}, errors.New("rule name can not be empty")
+		}
+		if _, ok := tree.Trunk[rc.Name]; ok {
+			return RuleTree{}, fmt.Errorf("duplicate rule %q", rc.Name)
+		}
+		r, err := compileRule(rc)
+		if err != nil {
+			return RuleTree{}, fmt.Errorf("rule %q: %w", rc.Name, err)
+		}
+		rule := &Rule{ParseFunc: r.parse, Key: rc.Key, History: rc.History}
+		for _, f := range rc.Fields {
+			rule.ItemFields = append(rule.ItemFields, f.Name)
+			rule.Schema = append(rule.Schema, Field{Name: f.Name, Type: f.Type, Required: f.Required})
+		}
+		tree.Trunk[rc.Name] = rule
+	}
+
+	// 检查引用的规则是否存在
+	if _, ok := tree.Trunk[cfg.RootRule]; !ok {
+		return RuleTree{}, fmt.Errorf("root rule %q not found", cfg.RootRule)
+	}
+	for _, rc := range cfg.Rules {
+		for _, l := range rc.Links {
+			if _, ok := tree.Trunk[l.Rule]; !ok {
+				return RuleTree{}, fmt.Errorf("rule %q: link rule %q not found", rc.Name, l.Rule)
+			}
+		}
+	}
+
+	seeds := cfg.Seeds
+	rootRule := cfg.RootRule
+	tree.Root = func() ([]*Request, error) {
+		roots := make([]*Request, 0, len(seeds))
+		for _, u := range seeds {
+			roots = append(roots, &Request{
+				Priority: 1,
+				Url:      u,
+				Method:   "GET",
+				RuleName: rootRule,
+			})
+		}
+		return roots, nil
+	}
+	return tree, nil
+}
+
+func compileRule(rc RuleConfig) (*compiledRule, error) {
+	switch rc.Type {
+	case "":
+		rc.Type = RuleTypeHTML
+	case RuleTypeHTML, RuleTypeJSON:
+	default:
+		return nil, fmt.Errorf("unknown rule type %q", rc.Type)
+	}
+	r := &compiledRule{json: rc.Type == RuleTypeJSON}
+	var err error
+	if !rc.Item.empty() {
+		if r.item, err = compileSelector(rc.Item, rc.Type); err != nil {
+			return nil, fmt.Errorf("item: %w", err)
+		}
+	}
+	for _, f := range rc.Fields {
+		if f.Name == "" {
+			return nil, errors.New("field name can not be empty")
+		}
+		if !f.Type.valid() {
+			return nil, fmt.Errorf("field %q: unknown type %q", f.Name, f.Type)
+		}
+		sel, err := compileSelector(f.Selector, rc.Type)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", f.Name, err)
+		}
+		r.fields = append(r.fields, compiledField{name: f.Name, typ: f.Type, sel: sel})
+	}
+	for _, l := range rc.Links {
+		sel, err := compileSelector(l.Selector, rc.Type)
+		if err != nil {
+			return nil, fmt.Errorf("link: %w", err)
+		}
+		if sel.attr == "" && !r.json {
+			sel.attr = "href"
+		}
+		r.links = append(r.links, compiledLink{sel: sel, rule: l.Rule, priority: l.Priority, param: l.Param})
+	}
+	return r, nil
+}
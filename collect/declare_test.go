@@ -0,0 +1,184 @@
+package collect_test
+
+import (
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const bookListPage = `<html><body>
+<ul>
+	<li class="subject-item"><h2><a href="/subject/1/" title="三体">三体</a></h2><span class="pub">刘慈欣 / 重庆出版社</span></li>
+	<li class="subject-item"><h2><a href="https://book.douban.com/subject/2/" title="活着">活着</a></h2><span class="pub">余华 / 作家出版社</span></li>
+</ul>
+<span class="next"><a href="?start=20">后页</a></span>
+</body></html>`
+
+func TestCompileRuleTree(t *testing.T) {
+	cfg := collect.TaskConfig{
+		Name:     "douban_book_css",
+		Seeds:    []string{"https://book.douban.com/tag/小说"},
+		RootRule: "书籍列表",
+		Rules: []collect.RuleConfig{
+			{
+				Name: "书籍列表",
+				Item: collect.Selector{CSS: "li.subject-item"},
+				Fields: []collect.FieldConfig{
+					{Name: "书名", Selector: collect.Selector{CSS: "h2 a", Attr: "title"}},
+					{Name: "出版信息", Selector: collect.Selector{XPath: ".//span[@class='pub']"}},
+				},
+				Links: []collect.LinkConfig{
+					{Selector: collect.Selector{CSS: "h2 a"}, Rule: "书籍简介", Priority: 100},
+					{Selector: collect.Selector{XPath: "//span[@class='next']/a"}, Rule: "书籍列表"},
+				},
+			},
+			{
+				Name:   "书籍简介",
+				Fields: []collect.FieldConfig{{Name: "书名", Selector: collect.Selector{CSS: "h1 span"}}},
+			},
+		},
+	}
+	tree, err := collect.CompileRuleTree(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"书名", "出版信息"}, tree.Trunk["书籍列表"].ItemFields)
+
+	roots, err := tree.Root()
+	require.NoError(t, err)
+	require.Len(t, roots, 1)
+	assert.Equal(t, "书籍列表", roots[0].RuleName)
+
+	task := collect.NewTask(collect.WithName(cfg.Name))
+	task.Rule = tree
+	req := &collect.Request{Task: task, Url: "https://book.douban.com/tag/小说", RuleName: "书籍列表"}
+	result, err := tree.Trunk["书籍列表"].ParseFunc(&collect.Context{Body: []byte(bookListPage), Req: req})
+	require.NoError(t, err)
+
+	require.Len(t, result.Items, 2)
+	data := result.Items[0].(*storage.DataCell).Data["Data"].(map[string]interface{})
+	assert.Equal(t, "三体", data["书名"])
+	assert.Equal(t, "刘慈欣 / 重庆出版社", data["出版信息"])
+
+	require.Len(t, result.Requests, 3)
+	assert.Equal(t, "https://book.douban.com/subject/1/", result.Requests[0].Url)
+	assert.Equal(t, 100, result.Requests[0].Priority)
+	assert.Equal(t, "https://book.douban.com/subject/2/", result.Requests[1].Url)
+	assert.Equal(t, "https://book.douban.com/tag/%E5%B0%8F%E8%AF%B4?start=20", result.Requests[2].Url)
+	assert.Equal(t, 1, result.Requests[2].Depth)
+
+	cfg.Rules[0].Links[0].Rule = "书籍详情"
+	_, err = collect.CompileRuleTree(cfg)
+	assert.Error(t, err)
+}
+
+const bookAPIResponse = `{
+	"count": 2,
+	"next_cursor": "abc123",
+	"books": [
+		{"title": "三体", "rating": {"value": 8.9}, "tags": ["科幻", "小说"], "url": "/subject/1/"},
+		{"title": "活着", "rating": {"value": 9.4}, "tags": ["小说"], "url": "/subject/2/"}
+	]
+}`
+
+func TestCompileJSONRule(t *testing.T) {
+	cfg := collect.TaskConfig{
+		Name:     "douban_book_api",
+		Seeds:    []string{"https://api.example.com/books?tag=小说"},
+		RootRule: "书籍接口",
+		Rules: []collect.RuleConfig{
+			{
+				Name: "书籍接口",
+				Type: collect.RuleTypeJSON,
+				Item: collect.Selector{JSON: "books"},
+				Fields: []collect.FieldConfig{
+					{Name: "书名", Selector: collect.Selector{JSON: "title"}},
+					{Name: "得分", Selector: collect.Selector{JSON: "rating.value"}},
+					{Name: "标签", Selector: collect.Selector{JSON: "tags"}},
+				},
+				Links: []collect.LinkConfig{
+					{Selector: collect.Selector{JSON: "books.#.url"}, Rule: "书籍简介"},
+					{Selector: collect.Selector{JSON: "next_cursor"}, Rule: "书籍接口", Param: "cursor"},
+				},
+			},
+			{
+				Name:   "书籍简介",
+				Fields: []collect.FieldConfig{{Name: "书名", Selector: collect.Selector{CSS: "h1 span"}}},
+			},
+		},
+	}
+	tree, err := collect.CompileRuleTree(cfg)
+	require.NoError(t, err)
+
+	task := collect.NewTask(collect.WithName(cfg.Name))
+	req := &collect.Request{Task: task, Url: cfg.Seeds[0], RuleName: "书籍接口"}
+	ctx := &collect.Context{Body: []byte(bookAPIResponse), Req: req}
+	assert.Equal(t, int64(2), ctx.JSON("count").Int())
+
+	result, err := tree.Trunk["书籍接口"].ParseFunc(ctx)
+	require.NoError(t, err)
+
+	require.Len(t, result.Items, 2)
+	data := result.Items[1].(*storage.DataCell).Data["Data"].(map[string]interface{})
+	assert.Equal(t, "活着", data["书名"])
+	assert.Equal(t, 9.4, data["得分"])
+	assert.Equal(t, []interface{}{"小说"}, data["标签"])
+
+	require.Len(t, result.Requests, 3)
+	assert.Equal(t, "https://api.example.com/subject/1/", result.Requests[0].Url)
+	assert.Equal(t, "书籍简介", result.Requests[0].RuleName)
+	assert.Equal(t, "https://api.example.com/books?cursor=abc123&tag=%E5%B0%8F%E8%AF%B4", result.Requests[2].Url)
+	assert.Equal(t, "书籍接口", result.Requests[2].RuleName)
+
+	// JSON 规则中不能使用 CSS 选择器
+	cfg.Rules[0].Fields[0].Selector = collect.Selector{CSS: "h1"}
+	_, err = collect.CompileRuleTree(cfg)
+	assert.Error(t, err)
+}
+
+func TestCompileTypedFields(t *testing.T) {
+	cfg := collect.TaskConfig{
+		Name:     "douban_book_typed",
+		Seeds:    []string{"https://book.douban.com/subject/1/"},
+		RootRule: "书籍简介",
+		Rules: []collect.RuleConfig{{
+			Name: "书籍简介",
+			Item: collect.Selector{CSS: "div.book"},
+			Fields: []collect.FieldConfig{
+				{Name: "书名", Type: collect.FieldString, Required: true, Selector: collect.Selector{CSS: "h1"}},
+				{Name: "评分", Type: collect.FieldFloat, Selector: collect.Selector{CSS: ".rating"}},
+				{Name: "页数", Type: collect.FieldInt, Selector: collect.Selector{CSS: ".pages"}},
+				{Name: "电子书", Type: collect.FieldBool, Selector: collect.Selector{CSS: ".ebook"}},
+			},
+		}},
+	}
+	tree, err := collect.CompileRuleTree(cfg)
+	require.NoError(t, err)
+	rule := tree.Trunk["书籍简介"]
+
+	page := `<html><body>
+<div class="book"><h1>三体</h1><span class="rating">8.9</span><span class="pages">302页</span><span class="ebook">true</span></div>
+<div class="book"><h1>活着</h1><span class="rating">暂无评分</span><span class="pages"></span></div>
+</body></html>`
+	task := collect.NewTask(collect.WithName(cfg.Name))
+	req := &collect.Request{Task: task, Url: cfg.Seeds[0], RuleName: "书籍简介"}
+	result, err := rule.ParseFunc(&collect.Context{Body: []byte(page), Req: req})
+	require.NoError(t, err)
+	require.Len(t, result.Items, 2)
+
+	// HTML 中取到的字符串转换为声明的类型
+	data := result.Items[0].(*storage.DataCell).GetData()
+	assert.Equal(t, map[string]interface{}{"书名": "三体", "评分": 8.9, "页数": int64(302), "电子书": true}, data)
+	assert.NoError(t, rule.Schema.Validate("书籍简介", data))
+	// 无法转换的值保留原值，由校验报告
+	data = result.Items[1].(*storage.DataCell).GetData()
+	assert.Nil(t, data["页数"])
+	var schemaErr *collect.SchemaError
+	require.ErrorAs(t, rule.Schema.Validate("书籍简介", data), &schemaErr)
+	require.Len(t, schemaErr.Errors, 1)
+	assert.Equal(t, "评分", schemaErr.Errors[0].Field)
+
+	cfg.Rules[0].Fields[1].Type = "number"
+	_, err = collect.CompileRuleTree(cfg)
+	assert.Error(t, err)
+}
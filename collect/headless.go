@@ -0,0 +1,147 @@
+package collect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/extensions"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// HeadlessFetch 通过 DevTools 协议驱动无头 Chromium 访问网站，
+// 能够获取经过 JavaScript 渲染之后的页面内容
+type HeadlessFetch struct {
+	Timeout       time.Duration     // 单个页面的超时时间
+	ExecPath      string            // Chromium 可执行文件路径，为空时自动查找
+	Proxy         string            // 代理地址
+	Headers       map[string]string // 附加的请求头
+	WaitVisible   string            // 等待该 CSS 选择器对应的元素可见后再获取页面
+	WaitTime      time.Duration     // 页面加载完成后额外等待的时间
+	ScreenshotDir string            // 截图的保存目录，为空时不截图
+	PoolSize      int               // 浏览器标签页池的大小
+	Logger        *zap.Logger
+
+	once          sync.Once
+	initErr       error
+	tabs          chan context.Context // 空闲的标签页
+	allocCancel   context.CancelFunc
+	browserCancel context.CancelFunc
+}
+
+// init 启动浏览器，并创建 PoolSize 个标签页放入池中
+func (h *HeadlessFetch) init() {
+	size := h.PoolSize
+	if size <= 0 {
+		size = 1
+	}
+	opts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.UserAgent(extensions.GenerateRandomUA()),
+	)
+	if h.ExecPath != "" {
+		opts = append(opts, chromedp.ExecPath(h.ExecPath))
+	}
+	if h.Proxy != "" {
+		opts = append(opts, chromedp.ProxyServer(h.Proxy))
+	}
+
+	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), opts...)
+	browserCtx, browserCancel := chromedp.NewContext(allocCtx)
+	// 首次 Run 时才会真正启动浏览器
+	if err := chromedp.Run(browserCtx); err != nil {
+		browserCancel()
+		allocCancel()
+		h.initErr = fmt.Errorf("start browser failed:%w", err)
+		return
+	}
+	h.allocCancel = allocCancel
+	h.browserCancel = browserCancel
+
+	h.tabs = make(chan context.Context, size)
+	for i := 0; i < size; i++ {
+		tabCtx, _ := chromedp.NewContext(browserCtx)
+		h.tabs <- tabCtx
+	}
+}
+
+func (h *HeadlessFetch) Get(request *Request) ([]byte, error) {
+	h.once.Do(h.init)
+	if h.initErr != nil {
+		return nil, h.initErr
+	}
+
+	// 从池中取出一个空闲的标签页，用完后归还
+	tab := <-h.tabs
+	defer func() {
+		h.tabs <- tab
+	}()
+
+	ctx := tab
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(tab, h.Timeout)
+		defer cancel()
+	}
+
+	headers := network.Headers{}
+	for k, v := range h.Headers {
+		headers[k] = v
+	}
+	if request.Task != nil && len(request.Task.Cookie) > 0 {
+		headers["Cookie"] = request.Task.Cookie
+	}
+
+	var body string
+	var screenshot []byte
+	actions := []chromedp.Action{
+		network.Enable(),
+		network.SetExtraHTTPHeaders(headers),
+		chromedp.Navigate(request.Url),
+	}
+	if h.WaitVisible != "" {
+		actions = append(actions, chromedp.WaitVisible(h.WaitVisible, chromedp.ByQuery))
+	}
+	if h.WaitTime > 0 {
+		actions = append(actions, chromedp.Sleep(h.WaitTime))
+	}
+	actions = append(actions, chromedp.OuterHTML("html", &body, chromedp.ByQuery))
+	if h.ScreenshotDir != "" {
+		actions = append(actions, chromedp.FullScreenshot(&screenshot, 100))
+	}
+
+	if err := chromedp.Run(ctx, actions...); err != nil {
+		return nil, fmt.Errorf("headless fetch %s failed:%w", request.Url, err)
+	}
+
+	if len(screenshot) > 0 {
+		if err := h.saveScreenshot(request, screenshot); err != nil && h.Logger != nil {
+			h.Logger.Error("save screenshot failed", zap.Error(err), zap.String("url", request.Url))
+		}
+	}
+
+	return []byte(body), nil
+}
+
+// saveScreenshot 将截图保存为 {ScreenshotDir}/{请求唯一标识}.png
+func (h *HeadlessFetch) saveScreenshot(request *Request, data []byte) error {
+	if err := os.MkdirAll(h.ScreenshotDir, 0755); err != nil {
+		return err
+	}
+	name := filepath.Join(h.ScreenshotDir, request.Unique()+".png")
+	return os.WriteFile(name, data, 0644)
+}
+
+// Close 关闭浏览器及所有标签页
+func (h *HeadlessFetch) Close() error {
+	if h.browserCancel == nil {
+		return errors.New("browser not started")
+	}
+	h.browserCancel()
+	h.allocCancel()
+	return nil
+}
@@ -0,0 +1,68 @@
+package collect_test
+
+import (
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const renderedPage = `<html><body><div id="app"></div>
+<script>
+setTimeout(function () {
+	var p = document.createElement("p");
+	p.id = "content";
+	p.textContent = "rendered by js";
+	document.getElementById("app").appendChild(p);
+}, 100);
+</script>
+</body></html>`
+
+// lookupChromium 查找本机的 Chromium，找不到时跳过测试
+func lookupChromium(t *testing.T) string {
+	for _, name := range []string{"chromium", "chromium-browser", "google-chrome", "google-chrome-stable", "headless-shell"} {
+		if p, err := exec.LookPath(name); err == nil {
+			return p
+		}
+	}
+	t.Skip("no chromium binary found")
+	return ""
+}
+
+func TestHeadlessFetch(t *testing.T) {
+	execPath := lookupChromium(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(renderedPage))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	f := &collect.HeadlessFetch{
+		Timeout:       10 * time.Second,
+		ExecPath:      execPath,
+		WaitVisible:   "#content",
+		ScreenshotDir: dir,
+		PoolSize:      2,
+	}
+	defer f.Close()
+
+	req := &collect.Request{
+		Url:    srv.URL,
+		Method: "GET",
+		Task:   collect.NewTask(),
+	}
+	body, err := f.Get(req)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "rendered by js")
+
+	_, err = os.Stat(filepath.Join(dir, req.Unique()+".png"))
+	assert.NoError(t, err)
+}
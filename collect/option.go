@@ -1,9 +1,11 @@
 package collect
 
 import (
+	"github.com/Nrich-sunny/crawler/archive"
 	"github.com/Nrich-sunny/crawler/limiter"
 	"github.com/Nrich-sunny/crawler/storage"
 	"go.uber.org/zap"
+	"time"
 )
 
 type Options struct {
@@ -16,7 +18,13 @@ type Options struct {
 	Fetcher  Fetcher
 	Storage  storage.Storage
 	Limit    limiter.RateLimiter
-	logger   *zap.Logger
+	Archiver archive.Archiver // 原始网页的归档，为空时不归档
+	Schedule string           // 定时重新爬取的 cron 表达式，为空时只爬取一次
+	// Incremental 增量爬取，种子请求之外的页面在 Recrawl 间隔内不再抓取
+	Incremental bool
+	Recrawl     time.Duration
+	Pipeline    Pipeline // 数据写入存储之前依次经过的处理器
+	logger      *zap.Logger
 }
 
 var defaultOptions = Options{
@@ -81,3 +89,28 @@ func WithMaxDepth(maxDepth int) Option {
 		opts.MaxDepth = maxDepth
 	}
 }
+
+func WithArchiver(a archive.Archiver) Option {
+	return func(opts *Options) {
+		opts.Archiver = a
+	}
+}
+
+func WithSchedule(schedule string) Option {
+	return func(opts *Options) {
+		opts.Schedule = schedule
+	}
+}
+
+func WithIncremental(recrawl time.Duration) Option {
+	return func(opts *Options) {
+		opts.Incremental = true
+		opts.Recrawl = recrawl
+	}
+}
+
+func WithPipeline(processors ...Processor) Option {
+	return func(opts *Options) {
+		opts.Pipeline = append(opts.Pipeline, processors...)
+	}
+}
@@ -1,5 +1,7 @@
 package collect
 
+import "sort"
+
 // RuleTree 采集规则树
 type RuleTree struct {
 	Root  func() ([]*Request, error) // 根节点(执行入口)，用于生成爬虫的种子网站
@@ -8,6 +10,27 @@ type RuleTree struct {
 
 // Rule 采集规则节点
 type Rule struct {
-	ItemFields []string                            // 当前输出数据的字段名
+	ItemFields []string                            // 当前输出数据的字段名，为空时由 Schema 生成
 	ParseFunc  func(*Context) (ParseResult, error) // 内容解析函数
+	Schema     Schema                              // 输出数据的结构，为空时不检查数据
+	// Key 数据的自然键，由 ItemFields 中的字段或 Url 组成。
+	// 不为空时存储中键相同的数据只保留一条，重复抓取时更新
+	Key     []string
+	History bool // 更新的数据内容变化时保留旧的版本，需要设置 Key
+}
+
+// Key 任务的自然键。任务中所有规则的数据写入同一张表，共用一个自然键，
+// 为按规则名排序后第一个声明了 Key 的规则的 Key
+func (tree RuleTree) Key() []string {
+	names := make([]string, 0, len(tree.Trunk))
+	for name, r := range tree.Trunk {
+		if r != nil && len(r.Key) > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return tree.Trunk[names[0]].Key
 }
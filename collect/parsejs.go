@@ -1,14 +1,25 @@
 package collect
 
+// 动态规则的脚本语言
+const (
+	LanguageJS  = "js"  // otto，默认
+	LanguageLua = "lua" // gopher-lua，受限的沙箱
+)
+
 type (
 	TaskModule struct {
 		Property
-		Root  string       `json:"root_script"`
-		Rules []RuleModule `json:"rule"`
+		Language string       `json:"language"` // 脚本语言，js(默认) 或 lua
+		Timeout  int64        `json:"timeout"`  // 脚本单次执行的超时时间，毫秒
+		Root     string       `json:"root_script"`
+		Rules    []RuleModule `json:"rule"`
 	}
 
 	RuleModule struct {
-		Name      string `json:"name"`
-		ParseFunc string `json:"parse_script"`
+		Name       string   `json:"name"`
+		ItemFields []string `json:"item_fields"` // 当前输出数据的字段名
+		ParseFunc  string   `json:"parse_script"`
+		Key        []string `json:"key"`     // 数据的自然键，参见 Rule.Key
+		History    bool     `json:"history"` // 保留数据的旧版本
 	}
 )
@@ -0,0 +1,521 @@
+package collect
+
+/** 数据处理流水线：ParseFunc 输出的数据在写入存储之前，按顺序经过任务配置的处理器，之后按规则的 Schema 校验，
+**	处理器可以修改数据，也可以丢弃数据。内置的处理器有 trim、coerce、drop、enrich 与 dedupe，
+**	第三方代码可以通过 RegisterProcessor 注册新的处理器
+ */
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/storage"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Processor 数据处理器，返回 false 时丢弃数据，返回错误时数据同样被丢弃
+type Processor interface {
+	Process(ctx *Context, cell *storage.DataCell) (bool, error)
+}
+
+// ProcessorFunc 将函数转换为 Processor
+type ProcessorFunc func(ctx *Context, cell *storage.DataCell) (bool, error)
+
+func (f ProcessorFunc) Process(ctx *Context, cell *storage.DataCell) (bool, error) {
+	return f(ctx, cell)
+}
+
+// Committer 数据通过整个流水线后才记录状态的处理器，例如 dedupe 只记录最终保留的数据。
+// Pipeline 调用 Check 代替 Process，数据通过所有处理器之后依次调用返回的 Commit
+type Committer interface {
+	Check(ctx *Context, cell *storage.DataCell) (keep bool, commit Commit, err error)
+}
+
+// Commit 处理器对一条数据的记录。Record 返回 false 时数据被丢弃，
+// 之前的处理器已经完成的记录通过 Undo 撤销，流水线的记录要么全部保留，要么全部撤销
+type Commit interface {
+	Record() bool
+	Undo()
+}
+
+// FieldAdder 向数据中添加新字段的处理器，添加的字段会加入规则的 ItemFields，存储据此创建列
+type FieldAdder interface {
+	AddedFields() []string
+}
+
+// FieldConverter 转换字段类型的处理器，数据按转换后的类型校验
+type FieldConverter interface {
+	ConvertedFields() map[string]FieldType
+}
+
+// Pipeline 按顺序执行的处理器
+type Pipeline []Processor
+
+// Process 依次执行处理器，任意处理器丢弃数据或返回错误时停止
+func (p Pipeline) Process(ctx *Context, cell *storage.DataCell) (bool, error) {
+	var commits []Commit
+	for _, proc := range p {
+		var keep bool
+		var err error
+		if c, ok := proc.(Committer); ok {
+			var commit Commit
+			keep, commit, err = c.Check(ctx, cell)
+			if commit != nil {
+				commits = append(commits, commit)
+			}
+		} else {
+			keep, err = proc.Process(ctx, cell)
+		}
+		if err != nil || !keep {
+			return false, err
+		}
+	}
+	// 并发处理的相同数据只保留先记录的一条
+	for i, commit := range commits {
+		if !commit.Record() {
+			for _, done := range commits[:i] {
+				done.Undo()
+			}
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Fields 返回流水线中的处理器添加的字段
+func (p Pipeline) Fields() []string {
+	var fields []string
+	for _, proc := range p {
+		if a, ok := proc.(FieldAdder); ok {
+			fields = append(fields, a.AddedFields()...)
+		}
+	}
+	return fields
+}
+
+// Schema 返回数据经过流水线之后的 Schema，被转换的字段使用转换后的类型
+func (p Pipeline) Schema(s Schema) Schema {
+	var converted Schema
+	for _, proc := range p {
+		c, ok := proc.(FieldConverter)
+		if !ok {
+			continue
+		}
+		if converted == nil {
+			converted = append(Schema(nil), s...)
+		}
+		types := c.ConvertedFields()
+		for i, f := range converted {
+			if t, ok := types[f.Name]; ok {
+				converted[i].Type = t
+			}
+		}
+	}
+	if converted == nil {
+		return s
+	}
+	return converted
+}
+
+// ProcessorConfig 处理器的配置，对应配置文件中的 [[Tasks.Pipeline]]
+type ProcessorConfig struct {
+	Name   string            // 处理器名
+	Fields []string          // 处理的字段，为空时处理所有字段
+	Type   FieldType         // coerce 转换的目标类型
+	Match  string            // drop 丢弃字段值匹配该正则的数据
+	Size   int               // dedupe 最多记录的数据条数，为 0 时使用 DefaultDedupeSize
+	Params map[string]string // 自定义处理器的参数
+}
+
+// ProcessorFactory 根据配置创建处理器
+type ProcessorFactory func(cfg ProcessorConfig) (Processor, error)
+
+var (
+	processorsMu sync.RWMutex
+	processors   = make(map[string]ProcessorFactory)
+)
+
+func init() {
+	RegisterProcessor("trim", newTrim)
+	RegisterProcessor("coerce", newCoerce)
+	RegisterProcessor("drop", newDrop)
+	RegisterProcessor("enrich", newEnrich)
+	RegisterProcessor("dedupe", newDedupe)
+}
+
+// RegisterProcessor 以 name 注册一种处理器，之后便能在 TaskConfig.Pipeline 中通过名称引用。
+// 重复注册同一名称会 panic
+func RegisterProcessor(name string, factory ProcessorFactory) {
+	processorsMu.Lock()
+	defer processorsMu.Unlock()
+	if factory == nil {
+		panic("collect: register processor factory is nil")
+	}
+	if _, dup := processors[name]; dup {
+		panic("collect: register processor twice for " + name)
+	}
+	processors[name] = factory
+}
+
+// ProcessorNames 返回所有已注册的处理器名称
+func ProcessorNames() []string {
+	processorsMu.RLock()
+	defer processorsMu.RUnlock()
+	names := make([]string, 0, len(processors))
+	for name := range processors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewProcessor 根据配置创建处理器，名称未注册时返回错误
+func NewProcessor(cfg ProcessorConfig) (Processor, error) {
+	processorsMu.RLock()
+	factory, ok := processors[cfg.Name]
+	processorsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown processor %q, registered: %v", cfg.Name, ProcessorNames())
+	}
+	p, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create processor %q failed:%w", cfg.Name, err)
+	}
+	return p, nil
+}
+
+// NewPipeline 按配置的顺序创建处理器
+func NewPipeline(cfgs []ProcessorConfig) (Pipeline, error) {
+	p := make(Pipeline, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		proc, err := NewProcessor(cfg)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, proc)
+	}
+	return p, nil
+}
+
+// fieldSet 处理器处理的字段，为空时处理所有字段
+type fieldSet []string
+
+func (s fieldSet) names(data map[string]interface{}) []string {
+	if len(s) > 0 {
+		return s
+	}
+	names := make([]string, 0, len(data))
+	for k := range data {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Trim 去除字符串字段的首尾空白，并将连续的空白合并为一个空格
+func Trim(fields ...string) Processor {
+	set := fieldSet(fields)
+	return ProcessorFunc(func(ctx *Context, cell *storage.DataCell) (bool, error) {
+		data := cell.GetData()
+		for _, name := range set.names(data) {
+			if s, ok := data[name].(string); ok {
+				data[name] = strings.Join(strings.Fields(s), " ")
+			}
+		}
+		return true, nil
+	})
+}
+
+func newTrim(cfg ProcessorConfig) (Processor, error) {
+	return Trim(cfg.Fields...), nil
+}
+
+// numberRe 字符串中的第一个数字，允许千分位，例如 "45.00元"、"1,024 页"
+var numberRe = regexp.MustCompile(`[-+]?(\d{1,3}(,\d{3})+|\d+)(\.\d+)?`)
+
+// Coerce 将字段转换为 int、float 或 bool。字符串中的数字前后可以有其他字符，
+// 例如 "45.00元" 转换为 45；空字符串转换为 nil；无法转换时返回错误
+func Coerce(t FieldType, fields ...string) (Processor, error) {
+	switch t {
+	case FieldInt, FieldFloat, FieldBool:
+	default:
+		return nil, fmt.Errorf("coerce: unsupported type %q", t)
+	}
+	if len(fields) == 0 {
+		return nil, errors.New("coerce: fields is required")
+	}
+	return coercer{t: t, fields: fields}, nil
+}
+
+type coercer struct {
+	t      FieldType
+	fields []string
+}
+
+func (c coercer) Process(ctx *Context, cell *storage.DataCell) (bool, error) {
+	data := cell.GetData()
+	for _, name := range c.fields {
+		v, ok := data[name]
+		if !ok || v == nil {
+			continue
+		}
+		converted, err := coerce(v, c.t)
+		if err != nil {
+			return false, fmt.Errorf("coerce %s: %w", name, err)
+		}
+		data[name] = converted
+	}
+	return true, nil
+}
+
+func (c coercer) ConvertedFields() map[string]FieldType {
+	types := make(map[string]FieldType, len(c.fields))
+	for _, name := range c.fields {
+		types[name] = c.t
+	}
+	return types
+}
+
+func newCoerce(cfg ProcessorConfig) (Processor, error) {
+	return Coerce(cfg.Type, cfg.Fields...)
+}
+
+func coerce(v interface{}, t FieldType) (interface{}, error) {
+	if t.accept(v) {
+		if t == FieldFloat {
+			return toFloat(v), nil
+		}
+		return v, nil
+	}
+	s := strings.TrimSpace(fmt.Sprint(v))
+	if s == "" {
+		return nil, nil
+	}
+	if t == FieldBool {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a bool", s)
+		}
+		return b, nil
+	}
+	num := strings.ReplaceAll(numberRe.FindString(s), ",", "")
+	if num == "" {
+		return nil, fmt.Errorf("%q is not a number", s)
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a number", s)
+	}
+	if t == FieldFloat {
+		return f, nil
+	}
+	if !strings.Contains(num, ".") {
+		return strconv.ParseInt(num, 10, 64)
+	}
+	if f != math.Trunc(f) {
+		return nil, fmt.Errorf("%q is not an int", s)
+	}
+	return int64(f), nil
+}
+
+// toFloat 将 FieldFloat 接受的数字统一转换为 float64
+func toFloat(v interface{}) float64 {
+	f, _ := strconv.ParseFloat(fmt.Sprint(v), 64)
+	return f
+}
+
+// Drop 丢弃 pred 返回 true 的数据
+func Drop(pred func(cell *storage.DataCell) bool) Processor {
+	return ProcessorFunc(func(ctx *Context, cell *storage.DataCell) (bool, error) {
+		return !pred(cell), nil
+	})
+}
+
+// DropMatch 丢弃任意字段的值匹配正则 expr 的数据，不存在的字段作为空字符串匹配，
+// 例如 "^$" 丢弃字段为空的数据
+func DropMatch(expr string, fields ...string) (Processor, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("drop: compile %q failed:%w", expr, err)
+	}
+	set := fieldSet(fields)
+	return Drop(func(cell *storage.DataCell) bool {
+		data := cell.GetData()
+		for _, name := range set.names(data) {
+			s := ""
+			if v := data[name]; v != nil {
+				s = fmt.Sprint(v)
+			}
+			if re.MatchString(s) {
+				return true
+			}
+		}
+		return false
+	}), nil
+}
+
+func newDrop(cfg ProcessorConfig) (Processor, error) {
+	if cfg.Match == "" {
+		return nil, errors.New("drop: match is required")
+	}
+	return DropMatch(cfg.Match, cfg.Fields...)
+}
+
+// enrich 可以添加的抓取信息
+var enrichers = map[string]func(ctx *Context) interface{}{
+	"Depth":    func(ctx *Context) interface{} { return ctx.Req.Depth },
+	"Method":   func(ctx *Context) interface{} { return ctx.Req.Method },
+	"Priority": func(ctx *Context) interface{} { return ctx.Req.Priority },
+	"BodySize": func(ctx *Context) interface{} { return len(ctx.Body) },
+	"BodyHash": func(ctx *Context) interface{} { return hashBytes(ctx.Body) },
+}
+
+type enrich []string
+
+// Enrich 向数据中添加抓取信息，可选 Depth、Method、Priority、BodySize(页面字节数) 与 BodyHash(页面内容的 sha1)
+func Enrich(fields ...string) (Processor, error) {
+	if len(fields) == 0 {
+		return nil, errors.New("enrich: fields is required")
+	}
+	for _, name := range fields {
+		if _, ok := enrichers[name]; !ok {
+			return nil, fmt.Errorf("enrich: unknown field %q", name)
+		}
+	}
+	return enrich(fields), nil
+}
+
+func newEnrich(cfg ProcessorConfig) (Processor, error) {
+	return Enrich(cfg.Fields...)
+}
+
+func (e enrich) Process(ctx *Context, cell *storage.DataCell) (bool, error) {
+	data := cell.GetData()
+	if data == nil {
+		return true, nil
+	}
+	for _, name := range e {
+		data[name] = enrichers[name](ctx)
+	}
+	return true, nil
+}
+
+func (e enrich) AddedFields() []string {
+	return e
+}
+
+// DefaultDedupeSize dedupe 默认最多记录的数据条数
+const DefaultDedupeSize = 100000
+
+// dedupe 按内容哈希去重，记录保存在内存中，进程重启后清空。
+// 最多记录 size 条，超出时淘汰最久没有出现的记录
+type dedupe struct {
+	fields fieldSet
+	size   int
+	mu     sync.Mutex
+	seen   map[string]*list.Element
+	recent *list.List // 记录的键，最近出现的在前
+}
+
+// Dedupe 丢弃与之前的数据内容相同的数据，fields 不为空时只比较这些字段。
+// 不同规则输出的数据分别去重，最多记录 DefaultDedupeSize 条
+func Dedupe(fields ...string) Processor {
+	return DedupeSize(DefaultDedupeSize, fields...)
+}
+
+// DedupeSize 与 Dedupe 相同，最多记录 size 条数据
+func DedupeSize(size int, fields ...string) Processor {
+	if size <= 0 {
+		size = DefaultDedupeSize
+	}
+	return &dedupe{fields: fields, size: size, seen: make(map[string]*list.Element), recent: list.New()}
+}
+
+func newDedupe(cfg ProcessorConfig) (Processor, error) {
+	if cfg.Size < 0 {
+		return nil, fmt.Errorf("dedupe: invalid size %d", cfg.Size)
+	}
+	return DedupeSize(cfg.Size, cfg.Fields...), nil
+}
+
+// Process 单独使用时立即记录数据
+func (d *dedupe) Process(ctx *Context, cell *storage.DataCell) (bool, error) {
+	keep, commit, err := d.Check(ctx, cell)
+	if err != nil || !keep {
+		return false, err
+	}
+	return commit.Record(), nil
+}
+
+// Check 在流水线中使用时，数据被之后的处理器丢弃不会被记录
+func (d *dedupe) Check(ctx *Context, cell *storage.DataCell) (bool, Commit, error) {
+	data := cell.GetData()
+	values := make(map[string]interface{}, len(data))
+	for _, name := range d.fields.names(data) {
+		values[name] = data[name]
+	}
+	// json 按键排序编码 map，相同的内容得到相同的哈希
+	b, err := json.Marshal(values)
+	if err != nil {
+		return false, nil, fmt.Errorf("dedupe: %w", err)
+	}
+	key := cell.GetRuleName() + " " + hashBytes(b)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.seen[key]; ok {
+		d.recent.MoveToFront(e)
+		return false, nil, nil
+	}
+	return true, &dedupeCommit{d: d, key: key}, nil
+}
+
+// dedupeCommit 记录一条数据的键
+type dedupeCommit struct {
+	d    *dedupe
+	key  string
+	elem *list.Element // 记录成功时为记录的位置
+}
+
+// Record 已经记录过时返回 false
+func (c *dedupeCommit) Record() bool {
+	d := c.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.seen[c.key]; ok {
+		d.recent.MoveToFront(e)
+		return false
+	}
+	c.elem = d.recent.PushFront(c.key)
+	d.seen[c.key] = c.elem
+	if d.recent.Len() > d.size {
+		oldest := d.recent.Back()
+		d.recent.Remove(oldest)
+		delete(d.seen, oldest.Value.(string))
+	}
+	return true
+}
+
+// Undo 删除 Record 添加的记录，记录已被淘汰时什么也不做
+func (c *dedupeCommit) Undo() {
+	d := c.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if c.elem != nil && d.seen[c.key] == c.elem {
+		d.recent.Remove(c.elem)
+		delete(d.seen, c.key)
+	}
+	c.elem = nil
+}
+
+func hashBytes(b []byte) string {
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,128 @@
+package collect_test
+
+import (
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func newCell(data map[string]interface{}) *storage.DataCell {
+	return storage.NewDataCell(storage.Item{Task: "book", Rule: "书籍简介", Url: "https://book.douban.com/subject/1/", Data: data})
+}
+
+func TestPipeline(t *testing.T) {
+	p, err := collect.NewPipeline([]collect.ProcessorConfig{
+		{Name: "trim"},
+		{Name: "coerce", Type: collect.FieldFloat, Fields: []string{"价格"}},
+		{Name: "coerce", Type: collect.FieldInt, Fields: []string{"页数"}},
+		{Name: "drop", Match: "^$", Fields: []string{"书名"}},
+		{Name: "enrich", Fields: []string{"Depth", "BodySize"}},
+		{Name: "dedupe", Fields: []string{"书名"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Depth", "BodySize"}, p.Fields())
+
+	ctx := &collect.Context{Body: []byte("<h1>三体</h1>"), Req: &collect.Request{Depth: 2, Method: "GET"}}
+	cell := newCell(map[string]interface{}{"书名": "  三体 \n 全集 ", "价格": "45.00元", "页数": "1,024 页"})
+	keep, err := p.Process(ctx, cell)
+	require.NoError(t, err)
+	assert.True(t, keep)
+	assert.Equal(t, map[string]interface{}{
+		"书名": "三体 全集", "价格": 45.0, "页数": int64(1024), "Depth": 2, "BodySize": 15,
+	}, cell.GetData())
+
+	// 书名相同的数据被去重
+	keep, err = p.Process(ctx, newCell(map[string]interface{}{"书名": "三体  全集", "价格": "50元"}))
+	require.NoError(t, err)
+	assert.False(t, keep)
+
+	// 书名为空的数据被丢弃
+	keep, err = p.Process(ctx, newCell(map[string]interface{}{"价格": "45"}))
+	require.NoError(t, err)
+	assert.False(t, keep)
+
+	// 无法转换的数据返回错误
+	keep, err = p.Process(ctx, newCell(map[string]interface{}{"书名": "活着", "价格": "免费"}))
+	assert.Error(t, err)
+	assert.False(t, keep)
+
+	_, err = collect.NewPipeline([]collect.ProcessorConfig{{Name: "unknown"}})
+	assert.Error(t, err)
+	_, err = collect.NewPipeline([]collect.ProcessorConfig{{Name: "coerce", Type: collect.FieldString, Fields: []string{"价格"}}})
+	assert.Error(t, err)
+}
+
+func TestRegisterProcessor(t *testing.T) {
+	collect.RegisterProcessor("source_test", func(cfg collect.ProcessorConfig) (collect.Processor, error) {
+		return collect.ProcessorFunc(func(ctx *collect.Context, cell *storage.DataCell) (bool, error) {
+			cell.GetData()["来源"] = cfg.Params["source"]
+			return true, nil
+		}), nil
+	})
+	assert.Contains(t, collect.ProcessorNames(), "source_test")
+	assert.Panics(t, func() { collect.RegisterProcessor("source_test", nil) })
+
+	p, err := collect.NewPipeline([]collect.ProcessorConfig{{Name: "source_test", Params: map[string]string{"source": "douban"}}})
+	require.NoError(t, err)
+	cell := newCell(map[string]interface{}{"书名": "三体"})
+	_, err = p.Process(&collect.Context{}, cell)
+	require.NoError(t, err)
+	assert.Equal(t, "douban", cell.GetData()["来源"])
+
+	tree := collect.RuleTree{Trunk: map[string]*collect.Rule{
+		"书籍列表": {},
+		"书籍简介": {Schema: collect.Schema{{Name: "书名", Type: collect.FieldString}}},
+	}}
+	tree.AddFields("来源")
+	assert.Empty(t, tree.Trunk["书籍列表"].ItemFields)
+	assert.Equal(t, []string{"书名", "来源"}, tree.Trunk["书籍简介"].ItemFields)
+	assert.Equal(t, []string{"书名", "来源"}, tree.Trunk["书籍简介"].Schema.Names())
+}
+
+func TestDedupe(t *testing.T) {
+	// 被之后的处理器丢弃的数据不会被记录
+	free, err := collect.DropMatch("^免费$", "价格")
+	require.NoError(t, err)
+	p := collect.Pipeline{collect.Dedupe("书名"), free}
+	ctx := &collect.Context{}
+	keep, err := p.Process(ctx, newCell(map[string]interface{}{"书名": "三体", "价格": "免费"}))
+	require.NoError(t, err)
+	assert.False(t, keep)
+	keep, err = p.Process(ctx, newCell(map[string]interface{}{"书名": "三体", "价格": "45"}))
+	require.NoError(t, err)
+	assert.True(t, keep)
+	keep, err = p.Process(ctx, newCell(map[string]interface{}{"书名": "三体", "价格": "50"}))
+	require.NoError(t, err)
+	assert.False(t, keep)
+
+	// 超出记录条数时淘汰最久没有出现的记录
+	d := collect.DedupeSize(2, "书名")
+	for _, title := range []string{"三体", "活着", "三体", "围城"} {
+		_, err := d.Process(ctx, newCell(map[string]interface{}{"书名": title}))
+		require.NoError(t, err)
+	}
+	keep, err = d.Process(ctx, newCell(map[string]interface{}{"书名": "三体"}))
+	require.NoError(t, err)
+	assert.False(t, keep)
+	keep, err = d.Process(ctx, newCell(map[string]interface{}{"书名": "活着"}))
+	require.NoError(t, err)
+	assert.True(t, keep)
+
+	// 之后的处理器记录失败时，撤销之前的处理器已经完成的记录
+	titles, authors := collect.Dedupe("书名"), collect.Dedupe("作者")
+	// 模拟并发处理的另一条相同作者的数据在检查之后、记录之前被记录
+	race := collect.ProcessorFunc(func(ctx *collect.Context, cell *storage.DataCell) (bool, error) {
+		return authors.Process(ctx, newCell(map[string]interface{}{"书名": "球状闪电", "作者": "刘慈欣"}))
+	})
+	keep, err = collect.Pipeline{titles, authors, race}.Process(ctx, newCell(map[string]interface{}{"书名": "三体", "作者": "刘慈欣"}))
+	require.NoError(t, err)
+	assert.False(t, keep)
+	keep, err = titles.Process(ctx, newCell(map[string]interface{}{"书名": "三体"}))
+	require.NoError(t, err)
+	assert.True(t, keep)
+
+	_, err = collect.NewPipeline([]collect.ProcessorConfig{{Name: "dedupe", Size: -1}})
+	assert.Error(t, err)
+}
@@ -0,0 +1,197 @@
+package collect
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/proxy"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultFetcher 任务未指定 Fetcher 时使用的 Fetcher 名称
+const DefaultFetcher = "browser"
+
+const defaultFetchTimeout = 5000
+
+// FetcherConfig Fetcher 的配置块，对应配置文件中的 [fetchers.{name}]
+type FetcherConfig struct {
+	Timeout int               // 超时时间，毫秒
+	Proxy   []string          // 代理地址列表，轮询使用
+	Headers map[string]string // 附加的请求头
+	Cache   CacheConfig
+	Logger  *zap.Logger `json:"-"`
+
+	raw json.RawMessage // 完整的配置块，供 Fetcher 解析自定义字段
+}
+
+// CacheConfig 请求结果的缓存配置
+type CacheConfig struct {
+	Enable bool
+	TTL    int // 缓存有效期，秒；为 0 时永不过期
+	Size   int // 最多缓存的响应数，超出时淘汰最久没有使用的响应；为 0 时使用 DefaultCacheSize
+}
+
+// Scan 将完整的配置块解析到 v 中，用于读取 Fetcher 自定义的配置项
+func (c FetcherConfig) Scan(v interface{}) error {
+	if len(c.raw) == 0 {
+		return nil
+	}
+	return json.Unmarshal(c.raw, v)
+}
+
+func (c FetcherConfig) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultFetchTimeout * time.Millisecond
+	}
+	return time.Duration(c.Timeout) * time.Millisecond
+}
+
+func (c FetcherConfig) logger() *zap.Logger {
+	if c.Logger == nil {
+		return zap.NewNop()
+	}
+	return c.Logger
+}
+
+// FetcherFactory 根据配置块创建 Fetcher
+type FetcherFactory func(cfg FetcherConfig) (Fetcher, error)
+
+var (
+	fetchersMu sync.RWMutex
+	fetchers   = make(map[string]FetcherFactory)
+)
+
+func init() {
+	RegisterFetcher("base", newBaseFetch)
+	RegisterFetcher("browser", newBrowserFetch)
+	RegisterFetcher("headless", newHeadlessFetch)
+}
+
+// RegisterFetcher 以 name 注册一种 Fetcher，第三方代码可以在 init 中调用，
+// 之后便能在 TaskConfig.Fetcher 中通过名称引用。重复注册同一名称会 panic
+func RegisterFetcher(name string, factory FetcherFactory) {
+	fetchersMu.Lock()
+	defer fetchersMu.Unlock()
+	if factory == nil {
+		panic("collect: register fetcher factory is nil")
+	}
+	if _, dup := fetchers[name]; dup {
+		panic("collect: register fetcher twice for " + name)
+	}
+	fetchers[name] = factory
+}
+
+// FetcherNames 返回所有已注册的 Fetcher 名称
+func FetcherNames() []string {
+	fetchersMu.RLock()
+	defer fetchersMu.RUnlock()
+	names := make([]string, 0, len(fetchers))
+	for name := range fetchers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewFetcher 根据名称和配置块创建 Fetcher，名称未注册时返回错误
+func NewFetcher(name string, cfg FetcherConfig) (Fetcher, error) {
+	fetchersMu.RLock()
+	factory, ok := fetchers[name]
+	fetchersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown fetcher %q, registered: %v", name, FetcherNames())
+	}
+
+	f, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("create fetcher %q failed:%w", name, err)
+	}
+	if cfg.Cache.Enable {
+		f = NewCacheFetch(f, time.Duration(cfg.Cache.TTL)*time.Second, cfg.Cache.Size)
+	}
+	return f, nil
+}
+
+// ParseFetcherConfigs 解析配置文件中的 [fetchers] 部分，
+// data 为 JSON 格式，键为 Fetcher 名称，值为对应的配置块
+func ParseFetcherConfigs(data []byte) (map[string]FetcherConfig, error) {
+	cfgs := make(map[string]FetcherConfig)
+	if len(data) == 0 || string(data) == "null" {
+		return cfgs, nil
+	}
+
+	var raws map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return nil, fmt.Errorf("parse fetchers config failed:%w", err)
+	}
+
+	registered := make(map[string]bool)
+	for _, name := range FetcherNames() {
+		registered[name] = true
+	}
+	for name, raw := range raws {
+		if !registered[name] {
+			return nil, fmt.Errorf("unknown fetcher %q in config, registered: %v", name, FetcherNames())
+		}
+		var cfg FetcherConfig
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("parse fetcher %q config failed:%w", name, err)
+		}
+		cfg.raw = raw
+		cfgs[name] = cfg
+	}
+	return cfgs, nil
+}
+
+func newBaseFetch(cfg FetcherConfig) (Fetcher, error) {
+	return BaseFetch{}, nil
+}
+
+func newBrowserFetch(cfg FetcherConfig) (Fetcher, error) {
+	f := &BrowserFetch{
+		Timeout: cfg.timeout(),
+		Headers: cfg.Headers,
+		Logger:  cfg.logger(),
+	}
+	if len(cfg.Proxy) > 0 {
+		p, err := proxy.RoundRobinProxySwitcher(cfg.Proxy...)
+		if err != nil {
+			return nil, err
+		}
+		f.Proxy = p
+	}
+	return f, nil
+}
+
+// headlessConfig 无头浏览器特有的配置项
+type headlessConfig struct {
+	ExecPath      string
+	WaitVisible   string
+	WaitTime      int // 毫秒
+	ScreenshotDir string
+	PoolSize      int
+}
+
+func newHeadlessFetch(cfg FetcherConfig) (Fetcher, error) {
+	var hc headlessConfig
+	if err := cfg.Scan(&hc); err != nil {
+		return nil, err
+	}
+	f := &HeadlessFetch{
+		Timeout:       cfg.timeout(),
+		ExecPath:      hc.ExecPath,
+		Headers:       cfg.Headers,
+		WaitVisible:   hc.WaitVisible,
+		WaitTime:      time.Duration(hc.WaitTime) * time.Millisecond,
+		ScreenshotDir: hc.ScreenshotDir,
+		PoolSize:      hc.PoolSize,
+		Logger:        cfg.logger(),
+	}
+	if len(cfg.Proxy) > 0 {
+		// Chromium 只支持单个代理地址
+		f.Proxy = cfg.Proxy[0]
+	}
+	return f, nil
+}
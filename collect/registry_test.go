@@ -0,0 +1,51 @@
+package collect_test
+
+import (
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type staticFetch struct {
+	Body string
+}
+
+func (s *staticFetch) Get(req *collect.Request) ([]byte, error) {
+	return []byte(s.Body), nil
+}
+
+func init() {
+	collect.RegisterFetcher("static", func(cfg collect.FetcherConfig) (collect.Fetcher, error) {
+		f := &staticFetch{}
+		if err := cfg.Scan(f); err != nil {
+			return nil, err
+		}
+		return f, nil
+	})
+}
+
+func TestNewFetcher(t *testing.T) {
+	cfgs, err := collect.ParseFetcherConfigs([]byte(`{"static":{"timeout":100,"body":"hello","cache":{"enable":true}}}`))
+	require.NoError(t, err)
+	require.Equal(t, 100, cfgs["static"].Timeout)
+
+	f, err := collect.NewFetcher("static", cfgs["static"])
+	require.NoError(t, err)
+	body, err := f.Get(&collect.Request{Url: "http://example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.IsType(t, &collect.CacheFetch{}, f)
+
+	_, err = collect.NewFetcher("unknown", collect.FetcherConfig{})
+	assert.Error(t, err)
+
+	_, err = collect.ParseFetcherConfigs([]byte(`{"unknown":{}}`))
+	assert.Error(t, err)
+
+	assert.Panics(t, func() {
+		collect.RegisterFetcher("browser", func(cfg collect.FetcherConfig) (collect.Fetcher, error) {
+			return nil, nil
+		})
+	})
+}
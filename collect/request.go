@@ -1,25 +1,126 @@
 package collect
 
 import (
+	"bytes"
 	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/tidwall/gjson"
 	"math/rand"
+	"net/url"
 	"regexp"
+	"strings"
 	"time"
 )
 
 type Context struct {
 	Body []byte
 	Req  *Request
+	doc  *goquery.Document // 解析后的 DOM，首次使用时才解析
 }
 
 func (c *Context) GetRule(ruleName string) *Rule {
 	return c.Req.Task.Rule.Trunk[ruleName]
 }
 
+// Doc 返回解析后的 DOM，同一个 Context 只解析一次
+func (c *Context) Doc() (*goquery.Document, error) {
+	if c.doc != nil {
+		return c.doc, nil
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(c.Body))
+	if err != nil {
+		return nil, fmt.Errorf("parse html failed:%w", err)
+	}
+	c.doc = doc
+	return doc, nil
+}
+
+// Find 返回页面中所有匹配 CSS 选择器的节点，页面解析失败时返回空集合
+func (c *Context) Find(selector string) *goquery.Selection {
+	doc, err := c.Doc()
+	if err != nil {
+		return &goquery.Selection{}
+	}
+	return doc.Find(selector)
+}
+
+// Text 返回第一个匹配节点去除首尾空白后的文本
+func (c *Context) Text(selector string) string {
+	return strings.TrimSpace(c.Find(selector).First().Text())
+}
+
+// Attr 返回第一个匹配节点的属性值
+func (c *Context) Attr(selector string, attr string) string {
+	v, _ := c.Find(selector).First().Attr(attr)
+	return strings.TrimSpace(v)
+}
+
+// AbsURL 将页面中的链接转换为基于当前请求网址的绝对网址，无法解析时返回空字符串
+func (c *Context) AbsURL(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return ""
+	}
+	base, err := url.Parse(c.Req.Url)
+	if err != nil {
+		return ""
+	}
+	u, err := base.Parse(href)
+	if err != nil {
+		return ""
+	}
+	return u.String()
+}
+
+// JSON 按 gjson 路径读取响应中的值，例如 data.items.#.title
+func (c *Context) JSON(path string) gjson.Result {
+	return gjson.GetBytes(c.Body, path)
+}
+
+// QueryURL 返回将当前请求网址的查询参数 key 设置为 value 后的网址，用于游标翻页
+func (c *Context) QueryURL(key string, value string) string {
+	u, err := url.Parse(c.Req.Url)
+	if err != nil || value == "" {
+		return ""
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// FollowLinks 为所有匹配 CSS 选择器节点的 href 生成下一层的请求，
+// 请求属于当前任务，深度加一，由 ruleName 对应的规则解析
+func (c *Context) FollowLinks(selector string, ruleName string) []*Request {
+	var reqs []*Request
+	c.Find(selector).Each(func(_ int, s *goquery.Selection) {
+		href, ok := s.Attr("href")
+		if !ok {
+			return
+		}
+		if u := c.AbsURL(href); u != "" {
+			reqs = append(reqs, c.NewRequest(u, ruleName))
+		}
+	})
+	return reqs
+}
+
+// NewRequest 生成当前请求的下一层请求
+func (c *Context) NewRequest(u string, ruleName string) *Request {
+	return &Request{
+		Method:   "GET",
+		Task:     c.Req.Task,
+		Url:      u,
+		Depth:    c.Req.Depth + 1,
+		RuleName: ruleName,
+	}
+}
+
 // ParseJsReq 动态解析JS中的正则表达式
 func (c *Context) ParseJsReq(name string, reg string) ParseResult {
 	re := regexp.MustCompile(reg)
@@ -40,17 +141,41 @@ func (c *Context) ParseJsReq(name string, reg string) ParseResult {
 	return result
 }
 
+// Output 输出一条数据，data 可以是 map 或带有 item 标签的结构体。
+// 不检查规则的 Schema，不符合 Schema 的数据由引擎丢弃
 func (c *Context) Output(data interface{}) *storage.DataCell {
-	res := &storage.DataCell{}
-	res.Data = make(map[string]interface{})
-	res.Data["Task"] = c.Req.Task.Name                          // 当前的任务名
-	res.Data["Rule"] = c.Req.RuleName                           // 当前的规则名
-	res.Data["Data"] = data                                     // 当前书籍的详细信息
-	res.Data["Url"] = c.Req.Url                                 // 当前的网址
-	res.Data["Time"] = time.Now().Format("2024-01-01 15:39:00") // 当前的时间
+	m, err := ItemData(data)
+	res := storage.NewDataCell(c.item(m))
+	if err != nil {
+		res.Data[storage.KeyData] = data
+	}
 	return res
 }
 
+// OutputItem 输出一条数据，规则声明了 Schema 时检查数据，不符合时返回 *SchemaError
+func (c *Context) OutputItem(data interface{}) (*storage.DataCell, error) {
+	m, err := ItemData(data)
+	if err != nil {
+		return nil, err
+	}
+	if rule := c.GetRule(c.Req.RuleName); rule != nil && len(rule.Schema) > 0 {
+		if err := rule.Schema.Validate(c.Req.RuleName, m); err != nil {
+			return nil, err
+		}
+	}
+	return storage.NewDataCell(c.item(m)), nil
+}
+
+func (c *Context) item(data map[string]interface{}) storage.Item {
+	return storage.Item{
+		Task: c.Req.Task.Name,
+		Rule: c.Req.RuleName,
+		Url:  c.Req.Url,
+		Time: time.Now(),
+		Data: data,
+	}
+}
+
 // OutputJs 解析内容并输出结果
 func (c *Context) OutputJs(reg string) ParseResult {
 	re := regexp.MustCompile(reg)
@@ -0,0 +1,38 @@
+package collect_test
+
+import (
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+const tagPage = `<html><body>
+<h1> 豆瓣读书标签 </h1>
+<a href="/tag/小说" class="tag">小说</a>
+<a href="https://book.douban.com/tag/历史" class="tag">历史</a>
+<a class="tag">无链接</a>
+<img src="//img.douban.com/logo.png">
+</body></html>`
+
+func TestContextDOM(t *testing.T) {
+	task := collect.NewTask(collect.WithName("douban_book_list"))
+	ctx := &collect.Context{
+		Body: []byte(tagPage),
+		Req:  &collect.Request{Task: task, Url: "https://book.douban.com/", Depth: 1},
+	}
+
+	assert.Equal(t, "豆瓣读书标签", ctx.Text("h1"))
+	assert.Equal(t, 3, ctx.Find("a.tag").Length())
+	assert.Equal(t, "https://img.douban.com/logo.png", ctx.AbsURL(ctx.Attr("img", "src")))
+
+	reqs := ctx.FollowLinks("a.tag", "书籍列表")
+	require.Len(t, reqs, 2)
+	assert.Equal(t, "https://book.douban.com/tag/%E5%B0%8F%E8%AF%B4", reqs[0].Url)
+	assert.Equal(t, "https://book.douban.com/tag/%E5%8E%86%E5%8F%B2", reqs[1].Url)
+	for _, r := range reqs {
+		assert.Equal(t, 2, r.Depth)
+		assert.Equal(t, "书籍列表", r.RuleName)
+		assert.Same(t, task, r.Task)
+	}
+}
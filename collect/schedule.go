@@ -0,0 +1,15 @@
+package collect
+
+import (
+	"fmt"
+	"github.com/robfig/cron/v3"
+)
+
+// ParseSchedule 解析任务的定时表达式，支持标准的 5 段 cron 表达式与 @every、@daily 等描述符
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	s, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+	}
+	return s, nil
+}
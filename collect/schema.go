@@ -0,0 +1,258 @@
+package collect
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// FieldType 数据字段的类型
+type FieldType string
+
+const (
+	FieldAny    FieldType = ""       // 不检查类型
+	FieldString FieldType = "string" // 字符串
+	FieldInt    FieldType = "int"    // 整数
+	FieldFloat  FieldType = "float"  // 数字，整数也是合法的值
+	FieldBool   FieldType = "bool"
+)
+
+// Field 数据字段的声明
+type Field struct {
+	Name     string    `json:"name"`
+	Type     FieldType `json:"type"`
+	Required bool      `json:"required"` // 必须有值，空字符串视为没有值
+}
+
+// Schema 规则输出的数据的结构
+type Schema []Field
+
+// Names 字段名，作为规则的 ItemFields
+func (s Schema) Names() []string {
+	names := make([]string, 0, len(s))
+	for _, f := range s {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+// FieldError 单个字段的错误
+type FieldError struct {
+	Field string
+	Msg   string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Msg
+}
+
+// SchemaError 数据不符合规则的 Schema，包含每个字段的错误
+type SchemaError struct {
+	Rule   string
+	Errors []FieldError
+}
+
+func (e *SchemaError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		msgs = append(msgs, fe.Error())
+	}
+	return fmt.Sprintf("rule %s: invalid item: %s", e.Rule, strings.Join(msgs, "; "))
+}
+
+// Validate 检查数据的字段是否都已声明、类型是否正确、必须的字段是否有值，
+// 不符合时返回 *SchemaError
+func (s Schema) Validate(rule string, data map[string]interface{}) error {
+	var errs []FieldError
+	declared := make(map[string]bool, len(s))
+	for _, f := range s {
+		declared[f.Name] = true
+		v, ok := data[f.Name]
+		if !ok || v == nil || v == "" {
+			if f.Required {
+				errs = append(errs, FieldError{Field: f.Name, Msg: "required"})
+			}
+			continue
+		}
+		if !f.Type.accept(v) {
+			errs = append(errs, FieldError{Field: f.Name, Msg: fmt.Sprintf("want %s, got %T", f.Type, v)})
+		}
+	}
+	var undeclared []string
+	for k := range data {
+		if !declared[k] {
+			undeclared = append(undeclared, k)
+		}
+	}
+	// map 的遍历顺序不固定，按字段名排序后输出
+	sort.Strings(undeclared)
+	for _, k := range undeclared {
+		errs = append(errs, FieldError{Field: k, Msg: "not declared"})
+	}
+	if len(errs) > 0 {
+		return &SchemaError{Rule: rule, Errors: errs}
+	}
+	return nil
+}
+
+// valid 是否为已知的字段类型
+func (t FieldType) valid() bool {
+	switch t {
+	case FieldAny, FieldString, FieldInt, FieldFloat, FieldBool:
+		return true
+	}
+	return false
+}
+
+func (t FieldType) accept(v interface{}) bool {
+	kind := reflect.TypeOf(v).Kind()
+	switch t {
+	case FieldString:
+		return kind == reflect.String
+	case FieldInt:
+		return isInt(kind)
+	case FieldFloat:
+		return isInt(kind) || kind == reflect.Float32 || kind == reflect.Float64
+	case FieldBool:
+		return kind == reflect.Bool
+	}
+	return true
+}
+
+func isInt(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Uint64
+}
+
+// InitFields 声明了 Schema 而没有声明 ItemFields 的规则，由 Schema 生成 ItemFields
+func (tree RuleTree) InitFields() {
+	for _, r := range tree.Trunk {
+		if r != nil && len(r.ItemFields) == 0 && len(r.Schema) > 0 {
+			r.ItemFields = r.Schema.Names()
+		}
+	}
+}
+
+// AddFields 向所有输出数据的规则中添加字段，已声明的字段不重复添加。
+// 声明了 Schema 的规则同时在 Schema 中添加不检查类型的字段
+func (tree RuleTree) AddFields(names ...string) {
+	tree.InitFields()
+	for _, r := range tree.Trunk {
+		if r == nil || len(r.ItemFields) == 0 {
+			continue
+		}
+		for _, name := range names {
+			if !contains(r.ItemFields, name) {
+				r.ItemFields = append(r.ItemFields, name)
+			}
+			if len(r.Schema) > 0 && !contains(r.Schema.Names(), name) {
+				r.Schema = append(r.Schema, Field{Name: name})
+			}
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 结构体字段的标签，例如 `item:"书名,required"`，`item:"-"` 表示忽略该字段
+const itemTag = "item"
+
+// SchemaOf 由结构体的 item 标签生成 Schema，没有标签的导出字段使用字段名
+func SchemaOf(v interface{}) (Schema, error) {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("schema of %T: not a struct", v)
+	}
+	var s Schema
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name, required, ok := parseTag(sf)
+		if !ok {
+			continue
+		}
+		s = append(s, Field{Name: name, Type: typeOf(sf.Type.Kind()), Required: required})
+	}
+	return s, nil
+}
+
+// MustSchemaOf 与 SchemaOf 相同，出错时 panic，用于声明规则
+func MustSchemaOf(v interface{}) Schema {
+	s, err := SchemaOf(v)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func parseTag(sf reflect.StructField) (name string, required bool, ok bool) {
+	if sf.PkgPath != "" {
+		return "", false, false
+	}
+	tag := sf.Tag.Get(itemTag)
+	if tag == "-" {
+		return "", false, false
+	}
+	parts := strings.Split(tag, ",")
+	name = parts[0]
+	if name == "" {
+		name = sf.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "required" {
+			required = true
+		}
+	}
+	return name, required, true
+}
+
+func typeOf(kind reflect.Kind) FieldType {
+	switch {
+	case kind == reflect.String:
+		return FieldString
+	case isInt(kind):
+		return FieldInt
+	case kind == reflect.Float32 || kind == reflect.Float64:
+		return FieldFloat
+	case kind == reflect.Bool:
+		return FieldBool
+	}
+	return FieldAny
+}
+
+// ItemData 将数据转换为 map，data 可以是 map[string]interface{} 或带有 item 标签的结构体
+func ItemData(data interface{}) (map[string]interface{}, error) {
+	if m, ok := data.(map[string]interface{}); ok {
+		return m, nil
+	}
+	v := reflect.ValueOf(data)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, errors.New("item is nil")
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("item must be a map or struct, got %T", data)
+	}
+	t := v.Type()
+	m := make(map[string]interface{}, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, ok := parseTag(t.Field(i))
+		if !ok {
+			continue
+		}
+		m[name] = v.Field(i).Interface()
+	}
+	return m, nil
+}
@@ -0,0 +1,71 @@
+package collect_test
+
+import (
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+type book struct {
+	Name   string  `item:"书名,required"`
+	Pages  int     `item:"页数"`
+	Score  float64 `item:"评分"`
+	Author string
+	cover  string
+	Cache  string `item:"-"`
+}
+
+func TestSchemaOf(t *testing.T) {
+	s, err := collect.SchemaOf(&book{})
+	require.NoError(t, err)
+	assert.Equal(t, collect.Schema{
+		{Name: "书名", Type: collect.FieldString, Required: true},
+		{Name: "页数", Type: collect.FieldInt},
+		{Name: "评分", Type: collect.FieldFloat},
+		{Name: "Author", Type: collect.FieldString},
+	}, s)
+	_, err = collect.SchemaOf(map[string]interface{}{})
+	assert.Error(t, err)
+
+	// 只声明 Schema 的规则由 Schema 生成 ItemFields
+	tree := collect.RuleTree{Trunk: map[string]*collect.Rule{"书籍简介": {Schema: s}}}
+	tree.InitFields()
+	assert.Equal(t, []string{"书名", "页数", "评分", "Author"}, tree.Trunk["书籍简介"].ItemFields)
+}
+
+func TestOutputItem(t *testing.T) {
+	task := &collect.Task{Rule: collect.RuleTree{Trunk: map[string]*collect.Rule{
+		"书籍简介": {Schema: collect.MustSchemaOf(book{})},
+	}}}
+	task.Name = "book"
+	ctx := &collect.Context{Req: &collect.Request{Task: task, RuleName: "书籍简介", Url: "https://book.douban.com/subject/1/"}}
+
+	cell, err := ctx.OutputItem(book{Name: "三体", Pages: 302, Score: 9.3, cover: "x"})
+	require.NoError(t, err)
+	item := cell.Item()
+	assert.Equal(t, "book", item.Task)
+	assert.Equal(t, "书籍简介", item.Rule)
+	assert.Equal(t, "https://book.douban.com/subject/1/", item.Url)
+	assert.False(t, item.Time.IsZero())
+	assert.Equal(t, map[string]interface{}{"书名": "三体", "页数": 302, "评分": 9.3, "Author": ""}, item.Data)
+
+	// 整数是合法的数字
+	_, err = ctx.OutputItem(map[string]interface{}{"书名": "活着", "评分": 9})
+	assert.NoError(t, err)
+
+	_, err = ctx.OutputItem(map[string]interface{}{"页数": "302页", "出版社": "作家出版社"})
+	var se *collect.SchemaError
+	require.ErrorAs(t, err, &se)
+	assert.Equal(t, []collect.FieldError{
+		{Field: "书名", Msg: "required"},
+		{Field: "页数", Msg: "want int, got string"},
+		{Field: "出版社", Msg: "not declared"},
+	}, se.Errors)
+
+	// 缺少键或类型不符时不会 panic
+	var empty storage.DataCell
+	assert.Equal(t, "", empty.GetTableName())
+	assert.Equal(t, storage.Item{}, empty.Item())
+}
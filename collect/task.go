@@ -1,9 +1,5 @@
 package collect
 
-import (
-	"github.com/Nrich-sunny/crawler/storage"
-)
-
 type Property struct {
 	Name     string `json:"name"` // 任务名称，应保证唯一性
 	Url      string `json:"url"`
@@ -14,9 +10,7 @@ type Property struct {
 
 // Task 整个任务实例，所有请求共享的参数
 type Task struct {
-	Fetcher Fetcher
-	Storage storage.Storage
-	Rule    RuleTree // 任务中的规则
+	Rule RuleTree // 任务中的规则
 	Options
 }
 
@@ -28,6 +22,16 @@ type TaskConfig struct {
 	MaxDepth int
 	Fetcher  string
 	Limits   []LimitConfig
+	Archive  ArchiveConfig
+	Seeds    []string     // 声明式规则的种子网址
+	RootRule string       // 种子网址对应的规则名
+	Rules    []RuleConfig // 声明式规则，不为空时不再使用 engine.Store 中的规则
+	// Schedule 定时重新爬取，cron 表达式（例如 "0 3 * * *"）或间隔（例如 "@every 6h"），为空时只在启动时爬取一次
+	Schedule    string
+	Incremental bool              // 增量爬取：在 Recrawl 间隔内抓取过的页面不再抓取，内容未变化的页面不再输出数据
+	Recrawl     int               // 增量爬取时页面重新抓取的最短间隔，秒
+	Storages    []string          // 数据写入的存储名，为空时写入默认的存储
+	Pipeline    []ProcessorConfig // 数据写入存储之前依次经过的处理器
 }
 
 type LimitConfig struct {
@@ -36,6 +40,12 @@ type LimitConfig struct {
 	Bucket     int // 桶大小
 }
 
+// ArchiveConfig 原始网页的 WARC 归档配置，Dir 为空时不归档
+type ArchiveConfig struct {
+	Dir     string // WARC 文件的存放目录
+	MaxSize int    // 单个 WARC 文件的最大大小，MB
+}
+
 func NewTask(opts ...Option) *Task {
 	options := defaultOptions
 	for _, opt := range opts {
@@ -1,5 +1,7 @@
 package collect
 
+import "encoding/json"
+
 type Temp struct {
 	data map[string]interface{}
 }
@@ -16,3 +18,8 @@ func (t *Temp) Set(key string, value interface{}) error {
 	t.data[key] = value
 	return nil
 }
+
+// MarshalJSON 输出所有缓存数据，便于调试
+func (t *Temp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.data)
+}
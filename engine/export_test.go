@@ -0,0 +1,16 @@
+package engine
+
+// NewJSVM 创建 JS 虚拟机，用于比较虚拟机池的效果
+func NewJSVM() error {
+	_, err := newJSVM()
+	return err
+}
+
+// ResetJSVM 返回恢复虚拟机全局变量的函数，即虚拟机放回池中的开销
+func ResetJSVM() (func() error, error) {
+	vm, err := newJSVM()
+	if err != nil {
+		return nil, err
+	}
+	return vm.reset, nil
+}
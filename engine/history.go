@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/Nrich-sunny/crawler/collect"
+	"os"
+	"sync"
+	"time"
+)
+
+// PageRecord 页面最近一次抓取的记录，用于增量爬取
+type PageRecord struct {
+	Hash      string    `json:"hash"` // 页面内容的 sha256
+	FetchTime time.Time `json:"fetch_time"`
+}
+
+// History 记录任务抓取过的页面
+type History interface {
+	Get(task string, url string) (PageRecord, bool)
+	Put(task string, url string, r PageRecord)
+}
+
+// MemHistory 保存在内存中的抓取记录，Path 不为空时 Flush 写入文件
+type MemHistory struct {
+	mu      sync.Mutex
+	path    string
+	records map[string]PageRecord // 任务名 + 网址 -> 抓取记录
+}
+
+func NewMemHistory() *MemHistory {
+	return &MemHistory{records: make(map[string]PageRecord)}
+}
+
+// LoadHistory 从文件中加载抓取记录，文件不存在时返回空的记录，Flush 时写回该文件
+func LoadHistory(path string) (*MemHistory, error) {
+	h := NewMemHistory()
+	h.path = path
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &h.records); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func historyKey(task string, url string) string {
+	return task + " " + url
+}
+
+func (h *MemHistory) Get(task string, url string) (PageRecord, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	r, ok := h.records[historyKey(task, url)]
+	return r, ok
+}
+
+func (h *MemHistory) Put(task string, url string, r PageRecord) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records[historyKey(task, url)] = r
+}
+
+// Flush 将抓取记录写入文件
+func (h *MemHistory) Flush() error {
+	if h.path == "" {
+		return nil
+	}
+	h.mu.Lock()
+	b, err := json.Marshal(h.records)
+	h.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+func contentHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// recent 增量爬取时，种子请求之外的页面在重新抓取间隔内抓取过则返回 true
+func (crawler *Crawler) recent(r *collect.Request) bool {
+	if !r.Task.Incremental || r.Depth == 0 || crawler.History == nil {
+		return false
+	}
+	rec, ok := crawler.History.Get(r.Task.Name, r.Url)
+	return ok && time.Since(rec.FetchTime) < r.Task.Recrawl
+}
+
+// record 记录页面的抓取，返回页面内容与上次抓取时是否相同
+func (crawler *Crawler) record(r *collect.Request, body []byte) bool {
+	if !r.Task.Incremental || crawler.History == nil {
+		return false
+	}
+	hash := contentHash(body)
+	rec, ok := crawler.History.Get(r.Task.Name, r.Url)
+	crawler.History.Put(r.Task.Name, r.Url, PageRecord{Hash: hash, FetchTime: time.Now()})
+	return ok && rec.Hash == hash
+}
@@ -0,0 +1,66 @@
+package engine_test
+
+import (
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/engine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIncrementalRecrawl(t *testing.T) {
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				return []*collect.Request{{Url: "https://book.douban.com", Method: "GET", RuleName: "数据tag"}}, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				"数据tag": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{Requests: ctx.FollowLinks("a.tag", "书籍列表")}, nil
+				}},
+				"书籍列表": {ItemFields: []string{"书名"}, ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{Items: []interface{}{ctx.Output(map[string]interface{}{"书名": ctx.Text("h2")})}}, nil
+				}},
+			},
+		},
+		Options: collect.Options{Name: "incremental"},
+	})
+	pages := pageFetcher{
+		"https://book.douban.com":       `<a class="tag" href="/tag/1">小说</a><a class="tag" href="/tag/2">历史</a>`,
+		"https://book.douban.com/tag/1": `<h2>三体</h2>`,
+		"https://book.douban.com/tag/2": `<h2>万历十五年</h2>`,
+	}
+	path := filepath.Join(t.TempDir(), "history.json")
+	run := func(recrawl time.Duration) engine.Stats {
+		h, err := engine.LoadHistory(path)
+		require.NoError(t, err)
+		seed := collect.NewTask(collect.WithName("incremental"), collect.WithStorage(&memStorage{}),
+			collect.WithFetcher(pages), collect.WithIncremental(recrawl))
+		crawler := engine.NewEngine(
+			engine.WithWorkCount(2),
+			engine.WithSeeds([]*collect.Task{seed}),
+			engine.WithScheduler(engine.NewSchedule()),
+			engine.WithHistory(h),
+		)
+		crawler.Run()
+		return crawler.Stats()
+	}
+
+	stats := run(time.Hour)
+	assert.Equal(t, int64(3), stats.Fetched)
+	assert.Equal(t, int64(2), stats.Items)
+
+	// 间隔内只重新抓取种子页面
+	stats = run(time.Hour)
+	assert.Equal(t, int64(1), stats.Fetched)
+	assert.Equal(t, int64(2), stats.Skipped)
+	assert.Equal(t, int64(0), stats.Items)
+
+	// 间隔已过，重新抓取所有页面，只有内容变化的页面输出数据
+	pages["https://book.douban.com/tag/2"] = `<h2>万历十五年（增订本）</h2>`
+	stats = run(0)
+	assert.Equal(t, int64(3), stats.Fetched)
+	assert.Equal(t, int64(1), stats.Items)
+}
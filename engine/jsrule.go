@@ -0,0 +1,313 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/robertkrimen/otto"
+	"sync"
+	"time"
+)
+
+// DefaultJSTimeout JS 脚本单次执行的默认超时时间
+const DefaultJSTimeout = 5 * time.Second
+
+var errJSTimeout = errors.New("js script execution timeout")
+
+// AddJsReqs 用于动态规则添加请求。
+func AddJsReqs(jreqs []map[string]interface{}) []*collect.Request {
+	reqs := make([]*collect.Request, 0)
+
+	for _, jreq := range jreqs {
+		req := jsRequest(jreq)
+		if req == nil {
+			return nil
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs
+}
+
+// AddJsReq 用于动态规则添加请求。
+func AddJsReq(jreq map[string]interface{}) []*collect.Request {
+	req := jsRequest(jreq)
+	if req == nil {
+		return nil
+	}
+	return []*collect.Request{req}
+}
+
+// jsRequest 将 JS 对象转换为请求，缺少 Url 时返回 nil
+func jsRequest(jreq map[string]interface{}) *collect.Request {
+	u, ok := jreq["Url"].(string)
+	if !ok {
+		return nil
+	}
+	req := &collect.Request{Url: u}
+	req.RuleName, _ = jreq["RuleName"].(string)
+	req.Method, _ = jreq["Method"].(string)
+	if req.Method == "" {
+		req.Method = "GET"
+	}
+	// JS 中的数字导出后为 float64
+	req.Priority = jsInt(jreq["Priority"])
+	req.Depth = jsInt(jreq["Depth"])
+	return req
+}
+
+func jsInt(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
+
+// jsContext 暴露给 JS 规则的 ctx 对象，方法名即 JS 中可调用的 API
+type jsContext struct {
+	Url      string
+	Depth    int
+	RuleName string
+	Body     string
+
+	ctx *collect.Context
+}
+
+func newJSContext(ctx *collect.Context) *jsContext {
+	return &jsContext{
+		Url:      ctx.Req.Url,
+		Depth:    ctx.Req.Depth,
+		RuleName: ctx.Req.RuleName,
+		Body:     string(ctx.Body),
+		ctx:      ctx,
+	}
+}
+
+// ParseJSReg 用正则表达式的第一个分组提取链接，生成 ruleName 规则的请求
+func (j *jsContext) ParseJSReg(ruleName string, reg string) collect.ParseResult {
+	return j.ctx.ParseJsReq(ruleName, reg)
+}
+
+// OutputJS 页面匹配正则表达式时输出当前网址
+func (j *jsContext) OutputJS(reg string) collect.ParseResult {
+	return j.ctx.OutputJs(reg)
+}
+
+// Output 输出一条数据
+func (j *jsContext) Output(data map[string]interface{}) collect.ParseResult {
+	return collect.ParseResult{
+		Items: []interface{}{j.ctx.Output(data)},
+	}
+}
+
+// FollowLinks 为匹配 CSS 选择器的链接生成 ruleName 规则的请求
+func (j *jsContext) FollowLinks(selector string, ruleName string) collect.ParseResult {
+	return collect.ParseResult{
+		Requests: j.ctx.FollowLinks(selector, ruleName),
+	}
+}
+
+// Text 返回第一个匹配 CSS 选择器节点的文本
+func (j *jsContext) Text(selector string) string {
+	return j.ctx.Text(selector)
+}
+
+// Attr 返回第一个匹配 CSS 选择器节点的属性值
+func (j *jsContext) Attr(selector string, attr string) string {
+	return j.ctx.Attr(selector, attr)
+}
+
+// jsRuntime 执行 JS 规则的运行时，复用 otto 虚拟机并限制单次执行的时间。
+// 虚拟机的内置对象被冻结，放回池中前恢复初始的全局变量并清除脚本设置的全局变量，
+// 因此脚本设置的全局变量或修改的内置对象不会影响之后的执行
+type jsRuntime struct {
+	timeout time.Duration
+	pool    sync.Pool
+}
+
+// jsVM 池中的虚拟机及其初始的全局变量
+type jsVM struct {
+	*otto.Otto
+	globals map[string]otto.Value
+}
+
+var (
+	// freezeBuiltins 冻结全局对象上的内置对象与它们的原型
+	freezeBuiltins = mustCompileJS("freeze", `(function(global) {
+		var names = Object.getOwnPropertyNames(global);
+		for (var i = 0; i < names.length; i++) {
+			var o = global[names[i]];
+			if (o && (typeof o === "object" || typeof o === "function")) {
+				Object.freeze(o);
+				if (o.prototype) { Object.freeze(o.prototype); }
+			}
+		}
+	})(this)`)
+	// globalNames 全局对象上的属性名
+	globalNames = mustCompileJS("globals", `Object.getOwnPropertyNames(this)`)
+)
+
+func mustCompileJS(name string, src string) *otto.Script {
+	script, err := otto.New().Compile(name, src)
+	if err != nil {
+		panic(err)
+	}
+	return script
+}
+
+func newJSRuntime(timeout time.Duration) *jsRuntime {
+	if timeout <= 0 {
+		timeout = DefaultJSTimeout
+	}
+	rt := &jsRuntime{timeout: timeout}
+	rt.pool.New = func() interface{} {
+		vm, err := newJSVM()
+		if err != nil {
+			panic(err)
+		}
+		return vm
+	}
+	return rt
+}
+
+func newJSVM() (*jsVM, error) {
+	vm := &jsVM{Otto: otto.New(), globals: make(map[string]otto.Value)}
+	if err := vm.Set("AddJsReq", AddJsReqs); err != nil {
+		return nil, err
+	}
+	if _, err := vm.Run(freezeBuiltins); err != nil {
+		return nil, err
+	}
+	names, err := vm.globalNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		v, err := vm.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		vm.globals[name] = v
+	}
+	vm.Interrupt = make(chan func(), 1)
+	return vm, nil
+}
+
+func (vm *jsVM) globalNames() ([]string, error) {
+	v, err := vm.Run(globalNames)
+	if err != nil {
+		return nil, err
+	}
+	e, err := v.Export()
+	if err != nil {
+		return nil, err
+	}
+	names, _ := e.([]string)
+	return names, nil
+}
+
+// reset 恢复初始的全局变量，脚本设置的全局变量改为 undefined
+func (vm *jsVM) reset() error {
+	names, err := vm.globalNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		v, ok := vm.globals[name]
+		if !ok {
+			v = otto.UndefinedValue()
+		}
+		if err := vm.Set(name, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compile 预编译脚本，编译后的脚本可以在任意虚拟机上执行
+func (rt *jsRuntime) compile(name string, src string) (*otto.Script, error) {
+	return otto.New().Compile(name, src)
+}
+
+// run 从池中取出一个虚拟机执行脚本，超时后中断执行。
+// 被中断或 panic 的虚拟机状态不确定，不再放回池中
+func (rt *jsRuntime) run(script *otto.Script, globals map[string]interface{}) (result interface{}, err error) {
+	vm := rt.pool.Get().(*jsVM)
+
+	timer := time.AfterFunc(rt.timeout, func() {
+		vm.Interrupt <- func() {
+			panic(errJSTimeout)
+		}
+	})
+	defer func() {
+		interrupted := !timer.Stop()
+		if e := recover(); e != nil {
+			if e == errJSTimeout {
+				err = errJSTimeout
+				return
+			}
+			err = fmt.Errorf("js script panic: %v", e)
+			return
+		}
+		if !interrupted && vm.reset() == nil {
+			rt.pool.Put(vm)
+		}
+	}()
+
+	for k, v := range globals {
+		if err := vm.Set(k, v); err != nil {
+			return nil, err
+		}
+	}
+	v, err := vm.Run(script)
+	if err != nil {
+		return nil, err
+	}
+	return v.Export()
+}
+
+// compileRoot 编译生成种子请求的脚本，脚本需以 AddJsReq(...) 结尾
+func (rt *jsRuntime) compileRoot(name string, src string) (func() ([]*collect.Request, error), error) {
+	script, err := rt.compile(name, src)
+	if err != nil {
+		return nil, err
+	}
+	return func() ([]*collect.Request, error) {
+		e, err := rt.run(script, nil)
+		if err != nil {
+			return nil, err
+		}
+		reqs, ok := e.([]*collect.Request)
+		if !ok {
+			return nil, fmt.Errorf("root script %s must return AddJsReq(...), got %T", name, e)
+		}
+		return reqs, nil
+	}, nil
+}
+
+// compileRule 编译解析规则的脚本，脚本的最后一个表达式为解析结果
+func (rt *jsRuntime) compileRule(name string, src string) (func(*collect.Context) (collect.ParseResult, error), error) {
+	script, err := rt.compile(name, src)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx *collect.Context) (collect.ParseResult, error) {
+		e, err := rt.run(script, map[string]interface{}{
+			"ctx": newJSContext(ctx),
+		})
+		if err != nil {
+			return collect.ParseResult{}, err
+		}
+		switch res := e.(type) {
+		case nil:
+			return collect.ParseResult{}, nil
+		case collect.ParseResult:
+			return res, nil
+		}
+		return collect.ParseResult{}, fmt.Errorf("rule %s must return a parse result, got %T", name, e)
+	}, nil
+}
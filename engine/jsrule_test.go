@@ -0,0 +1,126 @@
+package engine_test
+
+import (
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/engine"
+	"github.com/Nrich-sunny/crawler/parse/doubangroup"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+func newStore() *engine.CrawlerStore {
+	return &engine.CrawlerStore{Hash: map[string]*collect.Task{}}
+}
+
+func TestAddJsTask(t *testing.T) {
+	store := newStore()
+	require.NoError(t, store.AddJsTask(doubangroup.DoubangroupJSTask))
+
+	task, ok := store.Hash["js_find_douban_sun_room"]
+	require.True(t, ok)
+	assert.Equal(t, int64(2), task.WaitTime)
+
+	roots, err := task.Rule.Root()
+	require.NoError(t, err)
+	require.Len(t, roots, 1)
+	assert.Equal(t, "https://www.douban.com/group/szsh/discussion?start=25", roots[0].Url)
+	assert.Equal(t, 1, roots[0].Priority)
+	assert.Equal(t, "解析网站URL", roots[0].RuleName)
+
+	// 列表页解析出帖子链接
+	body, err := os.ReadFile("testdata/douban_group.html")
+	require.NoError(t, err)
+	roots[0].Task = task
+	result, err := task.Rule.Trunk["解析网站URL"].ParseFunc(&collect.Context{Body: body, Req: roots[0]})
+	require.NoError(t, err)
+	require.Len(t, result.Requests, 2)
+	assert.Equal(t, "https://www.douban.com/group/topic/285010123/", result.Requests[0].Url)
+	assert.Equal(t, "解析阳台房", result.Requests[0].RuleName)
+	assert.Equal(t, 1, result.Requests[0].Depth)
+
+	// 帖子中包含阳台时输出帖子网址，多次执行结果相同
+	body, err = os.ReadFile("testdata/douban_topic.html")
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		topic := result.Requests[0]
+		res, err := task.Rule.Trunk["解析阳台房"].ParseFunc(&collect.Context{Body: body, Req: topic})
+		require.NoError(t, err)
+		assert.Equal(t, []interface{}{topic.Url}, res.Items)
+	}
+
+	res, err := task.Rule.Trunk["解析阳台房"].ParseFunc(&collect.Context{Body: []byte("<html></html>"), Req: result.Requests[1]})
+	require.NoError(t, err)
+	assert.Empty(t, res.Items)
+}
+
+func TestJsTaskTimeout(t *testing.T) {
+	store := newStore()
+	err := store.AddJsTask(&collect.TaskModule{
+		Property: collect.Property{Name: "js_timeout"},
+		Timeout:  100,
+		Root:     `AddJsReq([{Url: "https://example.com", RuleName: "loop"}]);`,
+		Rules: []collect.RuleModule{
+			{Name: "loop", ParseFunc: `while (true) {}`},
+			{Name: "output", ParseFunc: `ctx.Output({"标题": ctx.Text("h1")});`, ItemFields: []string{"标题"}},
+		},
+	})
+	require.NoError(t, err)
+
+	task := store.Hash["js_timeout"]
+	req := &collect.Request{Task: task, Url: "https://example.com"}
+	_, err = task.Rule.Trunk["loop"].ParseFunc(&collect.Context{Body: []byte(""), Req: req})
+	assert.Error(t, err)
+
+	res, err := task.Rule.Trunk["output"].ParseFunc(&collect.Context{Body: []byte("<h1>标题</h1>"), Req: req})
+	require.NoError(t, err)
+	require.Len(t, res.Items, 1)
+
+	err = store.AddJsTask(&collect.TaskModule{
+		Property: collect.Property{Name: "js_syntax_error"},
+		Root:     `AddJsReq([{Url: "https://example.com"}`,
+	})
+	assert.Error(t, err)
+}
+
+func TestJsTaskIsolation(t *testing.T) {
+	store := newStore()
+	err := store.AddJsTask(&collect.TaskModule{
+		Property: collect.Property{Name: "js_isolation"},
+		Root:     `AddJsReq([{Url: "https://example.com", RuleName: "count"}]);`,
+		Rules: []collect.RuleModule{{
+			Name:       "count",
+			ItemFields: []string{"次数", "篡改", "覆盖"},
+			ParseFunc: `
+				if (typeof count === "undefined") { var count = 0; }
+				count++;
+				var tampered = typeof String.prototype.leak !== "undefined";
+				String.prototype.leak = true;
+				var overridden = parseInt("1") !== 1;
+				parseInt = function() { return 42; };
+				ctx.Output({"次数": count, "篡改": tampered, "覆盖": overridden});`,
+		}},
+	})
+	require.NoError(t, err)
+
+	task := store.Hash["js_isolation"]
+	req := &collect.Request{Task: task, Url: "https://example.com", RuleName: "count"}
+	// 上一次执行设置的全局变量与修改的内置对象不会影响下一次执行
+	for i := 0; i < 3; i++ {
+		res, err := task.Rule.Trunk["count"].ParseFunc(&collect.Context{Req: req})
+		require.NoError(t, err)
+		require.Len(t, res.Items, 1)
+		data := res.Items[0].(*storage.DataCell).GetData()
+		assert.EqualValues(t, 1, data["次数"])
+		assert.Equal(t, false, data["篡改"])
+		assert.Equal(t, false, data["覆盖"])
+	}
+}
+
+func TestLoadBuiltinTasks(t *testing.T) {
+	require.NoError(t, engine.LoadBuiltinTasks())
+	_, ok := engine.Store.Get("js_find_douban_sun_room")
+	assert.True(t, ok)
+}
@@ -0,0 +1,372 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/Nrich-sunny/crawler/collect"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// TaskState 任务的运行状态
+type TaskState string
+
+const (
+	TaskPending   TaskState = "pending"   // 等待调度
+	TaskRunning   TaskState = "running"   // 正在爬取
+	TaskPaused    TaskState = "paused"    // 暂停，已取出的请求被搁置，恢复后重新调度
+	TaskCompleted TaskState = "completed" // 所有请求处理完毕
+	TaskFailed    TaskState = "failed"    // 种子请求生成失败，或所有请求都抓取失败
+	TaskCancelled TaskState = "cancelled" // 被取消，等待中的请求被丢弃
+)
+
+// Finished 是否为最终状态
+func (s TaskState) Finished() bool {
+	return s == TaskCompleted || s == TaskFailed || s == TaskCancelled
+}
+
+// TaskStatus 单个任务的运行状态与请求计数
+type TaskStatus struct {
+	Name      string
+	State     TaskState
+	Queued    int64 // 在调度器中等待的请求数
+	InFlight  int64 // 正在处理的请求数
+	Completed int64 // 处理完毕的请求数
+	Fetched   int64 // 抓取成功的页面数
+	Failed    int64 // 抓取失败的次数
+	Items     int64 // 输出的数据条数
+	Err       string
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// TaskListener 任务状态变化时调用，任务结束时 State 为 completed、failed 或 cancelled。
+// 在单独的协程中按状态变化的顺序调用，不持有任务的锁
+type TaskListener func(status TaskStatus)
+
+// notifier 按顺序将任务状态发送给 TaskListener，setState 持有任务的锁时只加入队列，不会被监听者阻塞
+type notifier struct {
+	mu       sync.Mutex
+	queue    []TaskStatus
+	signal   chan struct{}
+	pending  sync.WaitGroup // 尚未发送的状态数
+	listener TaskListener
+}
+
+func newNotifier(listener TaskListener) *notifier {
+	n := &notifier{signal: make(chan struct{}, 1), listener: listener}
+	go n.run()
+	return n
+}
+
+func (n *notifier) notify(status TaskStatus) {
+	n.pending.Add(1)
+	n.mu.Lock()
+	n.queue = append(n.queue, status)
+	n.mu.Unlock()
+	select {
+	case n.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (n *notifier) run() {
+	for range n.signal {
+		n.mu.Lock()
+		queue := n.queue
+		n.queue = nil
+		n.mu.Unlock()
+		for _, status := range queue {
+			n.listener(status)
+			n.pending.Done()
+		}
+	}
+}
+
+// wait 等待已加入队列的状态发送完毕
+func (n *notifier) wait() {
+	n.pending.Wait()
+}
+
+// taskTracker 记录一个任务的状态
+type taskTracker struct {
+	mu     sync.Mutex
+	status TaskStatus
+	parked []*collect.Request // 暂停期间取出的请求
+}
+
+// tracker 返回任务的状态记录，不存在时创建
+func (crawler *Crawler) tracker(name string) *taskTracker {
+	crawler.tasksLock.Lock()
+	defer crawler.tasksLock.Unlock()
+	t, ok := crawler.tasks[name]
+	if !ok {
+		t = &taskTracker{status: TaskStatus{Name: name, State: TaskPending}}
+		crawler.tasks[name] = t
+	}
+	return t
+}
+
+// TaskStatus 返回任务当前的状态
+func (crawler *Crawler) TaskStatus(name string) (TaskStatus, bool) {
+	crawler.tasksLock.Lock()
+	t, ok := crawler.tasks[name]
+	crawler.tasksLock.Unlock()
+	if !ok {
+		return TaskStatus{}, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.status, true
+}
+
+// setState 修改任务的状态，并将状态加入通知监听者的队列，调用时需持有 t.mu
+func (crawler *Crawler) setState(t *taskTracker, state TaskState, err error) {
+	if t.status.State == state {
+		return
+	}
+	now := time.Now()
+	t.status.State = state
+	switch {
+	case state == TaskRunning && t.status.StartTime.IsZero():
+		t.status.StartTime = now
+	case state.Finished():
+		t.status.EndTime = now
+		if err != nil {
+			t.status.Err = err.Error()
+		}
+	}
+	crawler.Logger.Info("task state changed", zap.String("task", t.status.Name), zap.String("state", string(state)))
+	if crawler.notifier != nil {
+		crawler.notifier.notify(t.status)
+	}
+}
+
+// start 种子请求生成后，任务开始运行。任务已被取消或已经开始运行时返回 false
+func (crawler *Crawler) start(task *collect.Task, roots int) bool {
+	t := crawler.tracker(task.Name)
+	t.mu.Lock()
+	if t.status.State != TaskPending {
+		t.mu.Unlock()
+		return false
+	}
+	crawler.setState(t, TaskRunning, nil)
+	t.mu.Unlock()
+	if roots == 0 {
+		crawler.complete(task, t)
+	}
+	return true
+}
+
+// fail 任务无法运行
+func (crawler *Crawler) fail(task *collect.Task, err error) {
+	t := crawler.tracker(task.Name)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	crawler.setState(t, TaskFailed, err)
+}
+
+// complete 任务的请求全部处理完毕，写入缓存的数据后结束任务，调用时不能持有 t.mu
+func (crawler *Crawler) complete(task *collect.Task, t *taskTracker) {
+	crawler.flushTask(task)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// 写入期间任务可能被暂停、取消或者已经结束
+	if t.status.State != TaskRunning || !t.idle() {
+		return
+	}
+	if t.status.Fetched == 0 && t.status.Failed > 0 {
+		crawler.setState(t, TaskFailed, fmt.Errorf("all %d requests failed", t.status.Failed))
+		return
+	}
+	crawler.setState(t, TaskCompleted, nil)
+}
+
+// flushTask 写入任务存储中缓存的数据。存储的 Flush 可能阻塞较长时间，调用时不能持有 t.mu，
+// 以免阻塞任务的暂停、取消和状态查询
+func (crawler *Crawler) flushTask(task *collect.Task) {
+	if task.Storage == nil {
+		return
+	}
+	if err := task.Storage.Flush(); err != nil {
+		crawler.Logger.Error("flush storage failed", zap.Error(err), zap.String("task", task.Name))
+	}
+}
+
+// idle 任务没有等待和正在处理的请求，调用时需持有 t.mu
+func (t *taskTracker) idle() bool {
+	return t.status.Queued == 0 && t.status.InFlight == 0
+}
+
+// queue 请求加入调度器，返回可以加入的请求，已取消任务的请求不再加入
+func (crawler *Crawler) queue(reqs []*collect.Request) []*collect.Request {
+	queued := reqs[:0:0]
+	for _, r := range reqs {
+		t := crawler.tracker(r.Task.Name)
+		t.mu.Lock()
+		if t.status.State != TaskCancelled {
+			t.status.Queued++
+			queued = append(queued, r)
+		}
+		t.mu.Unlock()
+	}
+	return queued
+}
+
+// park 任务暂停时搁置取出的请求，任务取消时丢弃请求，返回请求是否不再处理
+func (crawler *Crawler) park(r *collect.Request) bool {
+	t := crawler.tracker(r.Task.Name)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch t.status.State {
+	case TaskPaused:
+		t.parked = append(t.parked, r)
+		return true
+	case TaskCancelled:
+		t.status.Queued--
+		crawler.release(1)
+		return true
+	}
+	t.status.Queued--
+	t.status.InFlight++
+	return false
+}
+
+// requestDone 请求处理完毕，任务没有等待和正在处理的请求时结束；
+// 已取消的任务写入最后一批请求输出的数据
+func (crawler *Crawler) requestDone(r *collect.Request, fetched bool, failed bool, items int) {
+	t := crawler.tracker(r.Task.Name)
+	t.mu.Lock()
+	t.status.InFlight--
+	t.status.Completed++
+	if fetched {
+		t.status.Fetched++
+	}
+	if failed {
+		t.status.Failed++
+	}
+	t.status.Items += int64(items)
+	idle, state := t.idle(), t.status.State
+	t.mu.Unlock()
+	switch {
+	case idle && state == TaskRunning:
+		crawler.complete(r.Task, t)
+	case idle && state == TaskCancelled:
+		crawler.flushTask(r.Task)
+	}
+}
+
+// Pause 暂停任务，正在处理的请求会继续完成
+func (crawler *Crawler) Pause(name string) error {
+	t, err := crawler.runningTracker(name)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.status.State != TaskRunning {
+		return fmt.Errorf("task %s is %s, can not pause", name, t.status.State)
+	}
+	crawler.setState(t, TaskPaused, nil)
+	crawler.Scheduler.Hold(name)
+	return nil
+}
+
+// Resume 恢复暂停的任务，重新调度暂停期间搁置的请求
+func (crawler *Crawler) Resume(name string) error {
+	t, err := crawler.runningTracker(name)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	if t.status.State != TaskPaused {
+		t.mu.Unlock()
+		return fmt.Errorf("task %s is %s, can not resume", name, t.status.State)
+	}
+	crawler.setState(t, TaskRunning, nil)
+	crawler.Scheduler.Release(name)
+	if len(t.parked) > 0 {
+		// 搁置的请求仍计入等待的请求数，直接放回调度器
+		go crawler.Scheduler.Push(t.parked...)
+		t.parked = nil
+	}
+	idle := t.idle()
+	t.mu.Unlock()
+	if idle {
+		crawler.complete(crawler.seed(name), t)
+	}
+	return nil
+}
+
+// Cancel 取消任务，丢弃等待中的请求，正在处理的请求会继续完成，但不再加入新的请求。
+// 写入已经缓存的数据，正在处理的请求输出的数据在它们完成后写入
+func (crawler *Crawler) Cancel(name string) error {
+	t, err := crawler.runningTracker(name)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	if t.status.State.Finished() {
+		t.mu.Unlock()
+		return fmt.Errorf("task %s is %s, can not cancel", name, t.status.State)
+	}
+	started := t.status.State != TaskPending
+	crawler.setState(t, TaskCancelled, nil)
+	if !started {
+		t.mu.Unlock()
+		return nil
+	}
+	dropped := len(crawler.Scheduler.Drop(name)) + len(t.parked)
+	t.parked = nil
+	t.status.Queued -= int64(dropped)
+	crawler.release(dropped)
+	t.mu.Unlock()
+	crawler.Logger.Info("task cancelled", zap.String("task", name), zap.Int("dropped", dropped))
+	crawler.flushTask(crawler.seed(name))
+	return nil
+}
+
+// Start 重新运行已结束的任务，清除任务访问过的请求后重新生成种子请求，
+// 任务不在配置中时使用 Store 中的同名任务。爬虫需要常驻运行
+func (crawler *Crawler) Start(name string) error {
+	t := crawler.tracker(name)
+	t.mu.Lock()
+	if t.status.State != TaskPending && !t.status.State.Finished() {
+		t.mu.Unlock()
+		return fmt.Errorf("task %s is %s, can not start", name, t.status.State)
+	}
+	if t.status.Queued > 0 || t.status.InFlight > 0 {
+		t.mu.Unlock()
+		return fmt.Errorf("task %s still has %d requests, can not start", name, t.status.Queued+t.status.InFlight)
+	}
+	t.status = TaskStatus{Name: name, State: TaskPending}
+	t.mu.Unlock()
+
+	crawler.clearVisited(name)
+	crawler.Logger.Info("start task", zap.String("task", name))
+	crawler.runTask(crawler.seed(name))
+	return nil
+}
+
+func (crawler *Crawler) runningTracker(name string) (*taskTracker, error) {
+	crawler.tasksLock.Lock()
+	defer crawler.tasksLock.Unlock()
+	t, ok := crawler.tasks[name]
+	if !ok {
+		return nil, fmt.Errorf("task %s not found", name)
+	}
+	return t, nil
+}
+
+// seed 按名称查找配置中的任务，不在配置中时使用 Store 中的任务
+func (crawler *Crawler) seed(name string) *collect.Task {
+	for _, s := range crawler.Seeds {
+		if s.Name == name {
+			return s
+		}
+	}
+	if t, ok := Store.Get(name); ok {
+		return t
+	}
+	return &collect.Task{Options: collect.Options{Name: name}}
+}
@@ -0,0 +1,405 @@
+package engine
+
+/** Lua 规则的内存限制：gopher-lua 没有分配器钩子，无法直接统计单个 LState 分配的内存。
+**	执行期间每隔 luaCheckSteps 条指令，或宿主函数生成的字符串累计超过 luaCheckBytes 时，
+**	遍历脚本可以访问到的数据估算 LState 占用的内存，超过 luaMaxMemory 时中止脚本。
+**	两次检查之间，单条指令生成的字符串受 luaMaxStringSize 的限制：
+**	字符串拼接 .. 在编译时改写为宿主函数调用，string 库中可能生成长字符串的函数和 table.concat 在调用前检查结果的长度
+ */
+
+import (
+	"context"
+	"fmt"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/ast"
+	"reflect"
+	"strings"
+	"unsafe"
+)
+
+const (
+	luaMaxMemory  = 64 << 20 // 单次执行中脚本可以访问到的数据的上限
+	luaCheckSteps = 1 << 16  // 两次检查之间最少执行的指令数
+	luaCheckBytes = 4 << 20  // 宿主函数累计生成的字符串超过该值时立即检查
+	// luaConcatName 改写后的字符串拼接函数在执行环境中的名称，不是合法的标识符，脚本无法直接访问
+	luaConcatName = "\x00concat"
+)
+
+var errLuaMemoryExceeded = fmt.Errorf("lua script uses more than %d bytes of memory", luaMaxMemory)
+
+var luaClosed = func() chan struct{} {
+	c := make(chan struct{})
+	close(c)
+	return c
+}()
+
+// luaBudget 单次执行的上下文，包装超时的 context。
+// gopher-lua 在执行每条指令之前调用 Done，借此统计指令数并定期检查内存
+type luaBudget struct {
+	context.Context
+	L        *lua.LState
+	env      *lua.LTable
+	steps    int // 距离下次检查剩余的指令数
+	grown    int // 上次检查之后宿主函数生成的字符串字节数
+	exceeded bool
+}
+
+func newLuaBudget(ctx context.Context, L *lua.LState, env *lua.LTable) *luaBudget {
+	return &luaBudget{Context: ctx, L: L, env: env, steps: luaCheckSteps}
+}
+
+func (b *luaBudget) Done() <-chan struct{} {
+	if b.steps--; b.steps <= 0 {
+		b.check()
+	}
+	if b.exceeded {
+		return luaClosed
+	}
+	return b.Context.Done()
+}
+
+func (b *luaBudget) Err() error {
+	if b.exceeded {
+		return errLuaMemoryExceeded
+	}
+	return b.Context.Err()
+}
+
+// check 估算内存，数据越多两次检查的间隔越长，遍历的开销与执行的指令数成比例
+func (b *luaBudget) check() {
+	size, nodes := b.measure()
+	b.exceeded = size > luaMaxMemory
+	b.grown = 0
+	b.steps = luaCheckSteps
+	if nodes*16 > b.steps {
+		b.steps = nodes * 16
+	}
+}
+
+// grow 记录宿主函数生成的字符串
+func (b *luaBudget) grow(n int) {
+	if b.grown += n; b.grown > luaCheckBytes {
+		b.check()
+	}
+	if b.exceeded {
+		b.L.RaiseError(errLuaMemoryExceeded.Error())
+	}
+}
+
+// measure 遍历执行环境、全局表、注册表、字符串元表，以及调用栈上各层的函数、局部变量和临时值
+func (b *luaBudget) measure() (size int, nodes int) {
+	L := b.L
+	m := &luaSizer{seen: make(map[interface{}]bool)}
+	m.push(b.env, L.G.Global, L.G.Registry, L.GetMetatable(lua.LString("")))
+	// 尾调用会让 GetStack 重复返回最外层，因此最多遍历调用栈的深度
+	for level := 0; level <= luaCallStackSize; level++ {
+		dbg, ok := L.GetStack(level)
+		if !ok {
+			break
+		}
+		if fn, err := L.GetInfo("f", dbg, lua.LNil); err == nil {
+			m.push(fn)
+		}
+		for n := 1; ; n++ {
+			name, v := L.GetLocal(dbg, n)
+			if name == "" {
+				break
+			}
+			m.push(v)
+		}
+	}
+	m.walk(luaMaxMemory)
+	return m.size, m.nodes
+}
+
+// luaSizer 估算表、字符串和闭包占用的内存，同一个表、闭包和较长的字符串只统计一次
+type luaSizer struct {
+	seen  map[interface{}]bool
+	stack []lua.LValue
+	size  int
+	nodes int
+}
+
+func (m *luaSizer) push(values ...lua.LValue) {
+	for _, v := range values {
+		switch v := v.(type) {
+		case lua.LString:
+			m.addString(v)
+		case *lua.LTable, *lua.LFunction:
+			m.stack = append(m.stack, v)
+		}
+	}
+}
+
+func (m *luaSizer) addString(s lua.LString) {
+	if len(s) >= 64 {
+		data := (*reflect.StringHeader)(unsafe.Pointer(&s)).Data
+		if m.seen[data] {
+			return
+		}
+		m.seen[data] = true
+	}
+	m.size += len(s) + 16
+}
+
+// walk 遍历可以访问到的数据，超过 limit 后停止
+func (m *luaSizer) walk(limit int) {
+	for len(m.stack) > 0 && m.size <= limit {
+		v := m.stack[len(m.stack)-1]
+		m.stack = m.stack[:len(m.stack)-1]
+		if m.seen[v] {
+			continue
+		}
+		m.seen[v] = true
+		m.nodes++
+		switch v := v.(type) {
+		case *lua.LTable:
+			m.size += 64
+			v.ForEach(func(k, val lua.LValue) {
+				m.size += 32
+				m.push(k, val)
+			})
+			m.push(v.Metatable)
+		case *lua.LFunction:
+			m.size += 64
+			if v.Env != nil {
+				m.push(v.Env)
+			}
+			for _, uv := range v.Upvalues {
+				m.push(uv.Value())
+			}
+		}
+	}
+}
+
+// luaGrow 宿主函数生成字符串之后调用，在执行期间之外什么也不做
+func luaGrow(L *lua.LState, n int) {
+	if b, ok := L.Context().(*luaBudget); ok {
+		b.grow(n)
+	}
+}
+
+func luaCheckSize(L *lua.LState, name string, n int) {
+	if n > luaMaxStringSize {
+		L.RaiseError("%s result exceeds %d bytes", name, luaMaxStringSize)
+	}
+}
+
+// luaCounted 包装生成字符串的库函数：调用前用 check 检查结果的长度，调用后记录生成的字符串
+func luaCounted(fn lua.LGFunction, check lua.LGFunction) lua.LGFunction {
+	return func(L *lua.LState) int {
+		if check != nil {
+			check(L)
+		}
+		n := fn(L)
+		size := 0
+		for i := L.GetTop() - n + 1; i <= L.GetTop(); i++ {
+			if s, ok := L.Get(i).(lua.LString); ok {
+				size += len(s)
+			}
+		}
+		luaGrow(L, size)
+		return n
+	}
+}
+
+// luaStringChecks 结果可能远长于参数的 string 库函数
+var luaStringChecks = map[string]lua.LGFunction{
+	"format": luaCheckFormat,
+	"gsub":   luaCheckGsub,
+}
+
+// luaCheckFormat 格式字符串中的宽度和精度也会生成字符
+func luaCheckFormat(L *lua.LState) int {
+	format := L.CheckString(1)
+	n := len(format)
+	for i := 2; i <= L.GetTop(); i++ {
+		// %q 转义之后最多是原来的两倍
+		n += 2 * len(L.Get(i).String())
+	}
+	for i := 0; i < len(format); i++ {
+		if format[i] < '0' || format[i] > '9' {
+			continue
+		}
+		width := 0
+		for ; i < len(format) && format[i] >= '0' && format[i] <= '9'; i++ {
+			if width = width*10 + int(format[i]-'0'); width > luaMaxStringSize {
+				break
+			}
+		}
+		n += width
+	}
+	luaCheckSize(L, "string.format", n)
+	return 0
+}
+
+// luaCheckGsub 替换字符串时按每个位置都匹配估算结果的长度；
+// 替换函数和表包装为累计结果长度的函数
+func luaCheckGsub(L *lua.LState) int {
+	s := L.CheckString(1)
+	matches := L.OptInt(4, -1)
+	if matches < 0 || matches > len(s)+1 {
+		matches = len(s) + 1
+	}
+	switch repl := L.Get(3).(type) {
+	case lua.LString:
+		refs := strings.Count(string(repl), "%")
+		luaCheckSize(L, "string.gsub", len(s)*(1+refs)+matches*len(repl))
+	case *lua.LFunction, *lua.LTable:
+		total := len(s)
+		L.Replace(3, L.NewFunction(func(L *lua.LState) int {
+			var v lua.LValue
+			if f, ok := repl.(*lua.LFunction); ok {
+				L.CallByParam(lua.P{Fn: f, NRet: 1, Protect: false}, luaArgs(L)...)
+				v = L.Get(-1)
+				L.Pop(1)
+			} else {
+				v = L.GetTable(repl, L.Get(1))
+			}
+			total += len(lua.LVAsString(v))
+			luaCheckSize(L, "string.gsub", total)
+			L.Push(v)
+			return 1
+		}))
+	}
+	return 0
+}
+
+// luaCheckTableConcat table.concat(t, sep, i, j)
+func luaCheckTableConcat(L *lua.LState) int {
+	t := L.CheckTable(1)
+	sep := L.OptString(2, "")
+	i := L.OptInt(3, 1)
+	j := L.OptInt(4, t.Len())
+	n := 0
+	for k := i; k <= j; k++ {
+		n += len(lua.LVAsString(t.RawGetInt(k))) + len(sep)
+		luaCheckSize(L, "table.concat", n)
+	}
+	return 0
+}
+
+func luaArgs(L *lua.LState) []lua.LValue {
+	args := make([]lua.LValue, 0, L.GetTop())
+	for i := 1; i <= L.GetTop(); i++ {
+		args = append(args, L.Get(i))
+	}
+	return args
+}
+
+// luaConcat 改写后的 a .. b，字符串和数字直接拼接，其余的值使用 __concat 元方法
+func luaConcat(L *lua.LState) int {
+	a, b := L.Get(1), L.Get(2)
+	if luaConcatable(a) && luaConcatable(b) {
+		as, bs := lua.LVAsString(a), lua.LVAsString(b)
+		luaCheckSize(L, "string concatenation", len(as)+len(bs))
+		L.Push(lua.LString(as + bs))
+		luaGrow(L, len(as)+len(bs))
+		return 1
+	}
+	mm := L.GetMetaField(a, "__concat")
+	if mm == lua.LNil {
+		mm = L.GetMetaField(b, "__concat")
+	}
+	if mm == lua.LNil {
+		L.RaiseError("cannot perform concat operation between %s and %s", a.Type(), b.Type())
+	}
+	L.CallByParam(lua.P{Fn: mm, NRet: 1, Protect: false}, a, b)
+	return 1
+}
+
+func luaConcatable(v lua.LValue) bool {
+	t := v.Type()
+	return t == lua.LTString || t == lua.LTNumber
+}
+
+// rewriteConcat 把语法树中的 a .. b 改写为 luaConcatName(a, b)
+func rewriteConcat(stmts []ast.Stmt) {
+	for _, stmt := range stmts {
+		switch s := stmt.(type) {
+		case *ast.AssignStmt:
+			rewriteExprs(s.Lhs)
+			rewriteExprs(s.Rhs)
+		case *ast.LocalAssignStmt:
+			rewriteExprs(s.Exprs)
+		case *ast.FuncCallStmt:
+			s.Expr = rewriteExpr(s.Expr)
+		case *ast.DoBlockStmt:
+			rewriteConcat(s.Stmts)
+		case *ast.WhileStmt:
+			s.Condition = rewriteExpr(s.Condition)
+			rewriteConcat(s.Stmts)
+		case *ast.RepeatStmt:
+			s.Condition = rewriteExpr(s.Condition)
+			rewriteConcat(s.Stmts)
+		case *ast.IfStmt:
+			s.Condition = rewriteExpr(s.Condition)
+			rewriteConcat(s.Then)
+			rewriteConcat(s.Else)
+		case *ast.NumberForStmt:
+			s.Init = rewriteExpr(s.Init)
+			s.Limit = rewriteExpr(s.Limit)
+			s.Step = rewriteExpr(s.Step)
+			rewriteConcat(s.Stmts)
+		case *ast.GenericForStmt:
+			rewriteExprs(s.Exprs)
+			rewriteConcat(s.Stmts)
+		case *ast.FuncDefStmt:
+			rewriteConcat(s.Func.Stmts)
+		case *ast.ReturnStmt:
+			rewriteExprs(s.Exprs)
+		}
+	}
+}
+
+func rewriteExprs(exprs []ast.Expr) {
+	for i, e := range exprs {
+		exprs[i] = rewriteExpr(e)
+	}
+}
+
+func rewriteExpr(expr ast.Expr) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.StringConcatOpExpr:
+		fn := &ast.IdentExpr{Value: luaConcatName}
+		fn.SetLine(e.Line())
+		call := &ast.FuncCallExpr{
+			Func:      fn,
+			Args:      []ast.Expr{rewriteExpr(e.Lhs), rewriteExpr(e.Rhs)},
+			AdjustRet: true,
+		}
+		call.SetLine(e.Line())
+		call.SetLastLine(e.LastLine())
+		return call
+	case *ast.AttrGetExpr:
+		e.Object = rewriteExpr(e.Object)
+		e.Key = rewriteExpr(e.Key)
+	case *ast.TableExpr:
+		for _, f := range e.Fields {
+			f.Key = rewriteExpr(f.Key)
+			f.Value = rewriteExpr(f.Value)
+		}
+	case *ast.FuncCallExpr:
+		e.Func = rewriteExpr(e.Func)
+		e.Receiver = rewriteExpr(e.Receiver)
+		rewriteExprs(e.Args)
+	case *ast.LogicalOpExpr:
+		e.Lhs = rewriteExpr(e.Lhs)
+		e.Rhs = rewriteExpr(e.Rhs)
+	case *ast.RelationalOpExpr:
+		e.Lhs = rewriteExpr(e.Lhs)
+		e.Rhs = rewriteExpr(e.Rhs)
+	case *ast.ArithmeticOpExpr:
+		e.Lhs = rewriteExpr(e.Lhs)
+		e.Rhs = rewriteExpr(e.Rhs)
+	case *ast.UnaryMinusOpExpr:
+		e.Expr = rewriteExpr(e.Expr)
+	case *ast.UnaryNotOpExpr:
+		e.Expr = rewriteExpr(e.Expr)
+	case *ast.UnaryLenOpExpr:
+		e.Expr = rewriteExpr(e.Expr)
+	case *ast.FunctionExpr:
+		rewriteConcat(e.Stmts)
+	}
+	return expr
+}
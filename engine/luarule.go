@@ -0,0 +1,421 @@
+package engine
+
+/** Lua 动态规则：基于 gopher-lua 的沙箱运行时，作为 otto 的替代。
+**	脚本只能使用 base、table、string、math 库以及 ctx 暴露的接口，
+**	单次执行受超时时间、内存、调用栈深度、寄存器栈大小和输出数量的限制，内存的限制见 luamem.go
+ */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/collect"
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	luaCallStackSize   = 120       // 最大调用深度
+	luaRegistrySize    = 1024      // 初始寄存器栈大小
+	luaRegistryMaxSize = 64 * 1024 // 寄存器栈的上限
+	luaMaxStringSize   = 16 << 20  // 单次拼接或库函数调用生成字符串的上限
+	luaMaxResults      = 10000     // 单次执行最多输出的请求与数据条数
+	luaMaxValueDepth   = 16        // 输出数据时表的最大嵌套层数
+)
+
+var errLuaTooManyResults = errors.New("lua script outputs too many results")
+
+// 沙箱中移除的 base 库函数：加载外部代码或修改全局环境
+var luaUnsafeGlobals = []string{
+	"dofile", "loadfile", "load", "loadstring", "require", "module",
+	"getfenv", "setfenv", "collectgarbage", "newproxy",
+}
+
+// luaRuntime 执行 Lua 规则的运行时，复用 LState 并限制单次执行的资源
+type luaRuntime struct {
+	timeout time.Duration
+	pool    sync.Pool
+}
+
+func newLuaRuntime(timeout time.Duration) *luaRuntime {
+	if timeout <= 0 {
+		timeout = DefaultJSTimeout
+	}
+	rt := &luaRuntime{timeout: timeout}
+	rt.pool.New = func() interface{} {
+		return newLuaState()
+	}
+	return rt
+}
+
+// luaState 池中的 LState，以及全局表、库表和字符串元表的初始内容，
+// 放回池中之前恢复，脚本对它们的修改不会影响之后的执行
+type luaState struct {
+	L         *lua.LState
+	concat    *lua.LFunction // 改写后的字符串拼接
+	snapshots []luaSnapshot
+}
+
+type luaSnapshot struct {
+	table  *lua.LTable
+	fields map[lua.LValue]lua.LValue
+}
+
+func snapshot(t *lua.LTable) luaSnapshot {
+	fields := make(map[lua.LValue]lua.LValue)
+	t.ForEach(func(k lua.LValue, v lua.LValue) {
+		fields[k] = v
+	})
+	return luaSnapshot{table: t, fields: fields}
+}
+
+// restore 删除新增的字段，恢复被修改的字段，并去掉设置的元表
+func (s luaSnapshot) restore(L *lua.LState) {
+	var added []lua.LValue
+	s.table.ForEach(func(k lua.LValue, v lua.LValue) {
+		if _, ok := s.fields[k]; !ok {
+			added = append(added, k)
+		}
+	})
+	for _, k := range added {
+		s.table.RawSet(k, lua.LNil)
+	}
+	for k, v := range s.fields {
+		s.table.RawSet(k, v)
+	}
+	s.table.Metatable = lua.LNil
+}
+
+func newLuaState() *luaState {
+	L := lua.NewState(lua.Options{
+		CallStackSize:   luaCallStackSize,
+		RegistrySize:    luaRegistrySize,
+		RegistryMaxSize: luaRegistryMaxSize,
+		SkipOpenLibs:    true,
+	})
+	libs := []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+	}
+	for _, lib := range libs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for _, name := range luaUnsafeGlobals {
+		L.SetGlobal(name, lua.LNil)
+	}
+	// 生成字符串的库函数记录生成的字节数，并限制单次调用结果的长度
+	if str, ok := L.GetGlobal(lua.StringLibName).(*lua.LTable); ok {
+		str.RawSetString("rep", L.NewFunction(luaStringRep))
+		fns := make(map[string]lua.LGFunction)
+		str.ForEach(func(k, v lua.LValue) {
+			if f, ok := v.(*lua.LFunction); ok && f.IsG {
+				fns[lua.LVAsString(k)] = f.GFunction
+			}
+		})
+		for name, fn := range fns {
+			str.RawSetString(name, L.NewFunction(luaCounted(fn, luaStringChecks[name])))
+		}
+	}
+	if tab, ok := L.GetGlobal(lua.TabLibName).(*lua.LTable); ok {
+		if f, ok := tab.RawGetString("concat").(*lua.LFunction); ok && f.IsG {
+			tab.RawSetString("concat", L.NewFunction(luaCounted(f.GFunction, luaCheckTableConcat)))
+		}
+	}
+
+	s := &luaState{L: L, concat: L.NewFunction(luaConcat)}
+	s.snapshots = append(s.snapshots, snapshot(L.G.Global))
+	for _, lib := range libs[1:] {
+		if t, ok := L.GetGlobal(lib.name).(*lua.LTable); ok {
+			s.snapshots = append(s.snapshots, snapshot(t))
+		}
+	}
+	if mt, ok := L.GetMetatable(lua.LString("")).(*lua.LTable); ok {
+		s.snapshots = append(s.snapshots, snapshot(mt))
+	}
+	return s
+}
+
+// reset 恢复全局表、库表和字符串元表
+func (s *luaState) reset() {
+	for _, snap := range s.snapshots {
+		snap.restore(s.L)
+	}
+	s.L.SetTop(0)
+}
+
+// luaStringRep 限制长度的 string.rep
+func luaStringRep(L *lua.LState) int {
+	s := L.CheckString(1)
+	n := L.CheckInt(2)
+	if n <= 0 {
+		L.Push(lua.LString(""))
+		return 1
+	}
+	if len(s) > 0 && n > luaMaxStringSize/len(s) {
+		L.RaiseError("string.rep result exceeds %d bytes", luaMaxStringSize)
+	}
+	L.Push(lua.LString(strings.Repeat(s, n)))
+	return 1
+}
+
+// compile 预编译脚本，编译后的函数原型可以在任意 LState 上执行。
+// 字符串拼接改写为宿主函数调用，以便限制生成字符串的长度
+func (rt *luaRuntime) compile(name string, src string) (*lua.FunctionProto, error) {
+	chunk, err := parse.Parse(strings.NewReader(src), name)
+	if err != nil {
+		return nil, err
+	}
+	rewriteConcat(chunk)
+	return lua.Compile(chunk, name)
+}
+
+// run 从池中取出一个 LState 执行脚本。脚本运行在独立的环境表中，
+// 对全局变量、库表的修改在放回池中之前恢复；执行出错的 LState 不再放回池中
+func (rt *luaRuntime) run(proto *lua.FunctionProto, lc *luaContext) error {
+	s := rt.pool.Get().(*luaState)
+	L := s.L
+
+	env := L.NewTable()
+	meta := L.NewTable()
+	meta.RawSetString("__index", L.Get(lua.GlobalsIndex))
+	L.SetMetatable(env, meta)
+	for k, v := range lc.globals(L) {
+		env.RawSetString(k, v)
+	}
+	env.RawSetString(luaConcatName, s.concat)
+	fn := L.NewFunctionFromProto(proto)
+	fn.Env = env
+
+	timeout, cancel := context.WithTimeout(context.Background(), rt.timeout)
+	defer cancel()
+	ctx := newLuaBudget(timeout, L, env)
+	L.SetContext(ctx)
+	L.Push(fn)
+	err := L.PCall(0, 0, nil)
+	L.RemoveContext()
+	if err != nil {
+		L.Close()
+		if ctx.exceeded {
+			return errLuaMemoryExceeded
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("lua script execution timeout:%w", err)
+		}
+		return err
+	}
+	s.reset()
+	rt.pool.Put(s)
+	return nil
+}
+
+// compileRoot 编译生成种子请求的脚本，脚本通过 AddReq 添加请求
+func (rt *luaRuntime) compileRoot(name string, src string) (func() ([]*collect.Request, error), error) {
+	proto, err := rt.compile(name, src)
+	if err != nil {
+		return nil, err
+	}
+	return func() ([]*collect.Request, error) {
+		lc := &luaContext{}
+		if err := rt.run(proto, lc); err != nil {
+			return nil, err
+		}
+		return lc.result.Requests, nil
+	}, nil
+}
+
+// compileRule 编译解析规则的脚本，脚本通过 ctx 的方法输出请求与数据
+func (rt *luaRuntime) compileRule(name string, src string) (func(*collect.Context) (collect.ParseResult, error), error) {
+	proto, err := rt.compile(name, src)
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx *collect.Context) (collect.ParseResult, error) {
+		lc := &luaContext{ctx: ctx}
+		if err := rt.run(proto, lc); err != nil {
+			return collect.ParseResult{}, err
+		}
+		return lc.result, nil
+	}, nil
+}
+
+// luaContext 暴露给 Lua 规则的宿主接口，与 collect.Context 对应。
+// 方法使用冒号调用，例如 ctx:Text("h1")
+type luaContext struct {
+	ctx    *collect.Context // 根节点脚本中为空
+	result collect.ParseResult
+}
+
+func (lc *luaContext) globals(L *lua.LState) map[string]lua.LValue {
+	g := map[string]lua.LValue{
+		"AddReq": L.NewFunction(lc.addReq),
+	}
+	if lc.ctx == nil {
+		return g
+	}
+	t := L.NewTable()
+	t.RawSetString("Url", lua.LString(lc.ctx.Req.Url))
+	t.RawSetString("Depth", lua.LNumber(lc.ctx.Req.Depth))
+	t.RawSetString("RuleName", lua.LString(lc.ctx.Req.RuleName))
+	t.RawSetString("Body", lua.LString(lc.ctx.Body))
+	L.SetFuncs(t, map[string]lua.LGFunction{
+		"Text":        lc.text,
+		"Attr":        lc.attr,
+		"JSON":        lc.json,
+		"FollowLinks": lc.followLinks,
+		"ParseReg":    lc.parseReg,
+		"OutputReg":   lc.outputReg,
+		"Output":      lc.output,
+	})
+	g["ctx"] = t
+	return g
+}
+
+func (lc *luaContext) addRequests(L *lua.LState, reqs ...*collect.Request) {
+	if len(lc.result.Requests)+len(lc.result.Items)+len(reqs) > luaMaxResults {
+		L.RaiseError(errLuaTooManyResults.Error())
+	}
+	lc.result.Requests = append(lc.result.Requests, reqs...)
+}
+
+func (lc *luaContext) addItems(L *lua.LState, items ...interface{}) {
+	if len(lc.result.Requests)+len(lc.result.Items)+len(items) > luaMaxResults {
+		L.RaiseError(errLuaTooManyResults.Error())
+	}
+	lc.result.Items = append(lc.result.Items, items...)
+}
+
+// addReq AddReq({Url=..., RuleName=..., Priority=...}) 或 AddReq({{...}, {...}})
+func (lc *luaContext) addReq(L *lua.LState) int {
+	t := L.CheckTable(1)
+	reqs := []*lua.LTable{t}
+	if t.RawGetString("Url") == lua.LNil {
+		reqs = reqs[:0]
+		t.ForEach(func(_, v lua.LValue) {
+			if r, ok := v.(*lua.LTable); ok {
+				reqs = append(reqs, r)
+			}
+		})
+	}
+	for _, r := range reqs {
+		u := lua.LVAsString(r.RawGetString("Url"))
+		if u == "" {
+			L.ArgError(1, "Url is required")
+		}
+		ruleName := lua.LVAsString(r.RawGetString("RuleName"))
+		var req *collect.Request
+		if lc.ctx != nil {
+			req = lc.ctx.NewRequest(lc.ctx.AbsURL(u), ruleName)
+		} else {
+			req = &collect.Request{Url: u, RuleName: ruleName}
+		}
+		if method := lua.LVAsString(r.RawGetString("Method")); method != "" {
+			req.Method = method
+		}
+		if req.Method == "" {
+			req.Method = "GET"
+		}
+		req.Priority = int(lua.LVAsNumber(r.RawGetString("Priority")))
+		lc.addRequests(L, req)
+	}
+	return 0
+}
+
+// luaPushString 返回宿主生成的字符串，并记录生成的字节数
+func luaPushString(L *lua.LState, s string) {
+	L.Push(lua.LString(s))
+	luaGrow(L, len(s))
+}
+
+// text ctx:Text(selector) 第一个匹配 CSS 选择器节点的文本
+func (lc *luaContext) text(L *lua.LState) int {
+	luaPushString(L, lc.ctx.Text(L.CheckString(2)))
+	return 1
+}
+
+// attr ctx:Attr(selector, attr) 第一个匹配 CSS 选择器节点的属性值
+func (lc *luaContext) attr(L *lua.LState) int {
+	luaPushString(L, lc.ctx.Attr(L.CheckString(2), L.CheckString(3)))
+	return 1
+}
+
+// json ctx:JSON(path) 按 gjson 路径取值，返回字符串
+func (lc *luaContext) json(L *lua.LState) int {
+	luaPushString(L, lc.ctx.JSON(L.CheckString(2)).String())
+	return 1
+}
+
+// followLinks ctx:FollowLinks(selector, ruleName) 跟进匹配 CSS 选择器的链接，返回链接数
+func (lc *luaContext) followLinks(L *lua.LState) int {
+	reqs := lc.ctx.FollowLinks(L.CheckString(2), L.CheckString(3))
+	lc.addRequests(L, reqs...)
+	L.Push(lua.LNumber(len(reqs)))
+	return 1
+}
+
+// parseReg ctx:ParseReg(ruleName, reg) 用正则表达式的第一个分组提取链接
+func (lc *luaContext) parseReg(L *lua.LState) int {
+	// 正则表达式不合法时 ParseJsReq 会 panic，由 PCall 转换为脚本错误
+	res := lc.ctx.ParseJsReq(L.CheckString(2), L.CheckString(3))
+	lc.addRequests(L, res.Requests...)
+	L.Push(lua.LNumber(len(res.Requests)))
+	return 1
+}
+
+// outputReg ctx:OutputReg(reg) 页面匹配正则表达式时输出当前网址
+func (lc *luaContext) outputReg(L *lua.LState) int {
+	res := lc.ctx.OutputJs(L.CheckString(2))
+	lc.addItems(L, res.Items...)
+	L.Push(lua.LBool(len(res.Items) > 0))
+	return 1
+}
+
+// output ctx:Output(table) 输出一条数据
+func (lc *luaContext) output(L *lua.LState) int {
+	data, ok := luaToGo(L.CheckTable(2), 0).(map[string]interface{})
+	if !ok {
+		L.ArgError(2, "data must be a table with string keys")
+	}
+	lc.addItems(L, lc.ctx.Output(data))
+	return 0
+}
+
+// luaToGo 将 Lua 值转换为 Go 值，连续下标的表转换为切片，其余的表转换为 map
+func luaToGo(v lua.LValue, depth int) interface{} {
+	switch v := v.(type) {
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		return float64(v)
+	case lua.LString:
+		return string(v)
+	case *lua.LTable:
+		if depth >= luaMaxValueDepth {
+			return nil
+		}
+		if n := v.Len(); n > 0 {
+			arr := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				arr = append(arr, luaToGo(v.RawGetInt(i), depth+1))
+			}
+			return arr
+		}
+		m := make(map[string]interface{})
+		v.ForEach(func(k, val lua.LValue) {
+			if ks, ok := k.(lua.LString); ok {
+				m[string(ks)] = luaToGo(val, depth+1)
+			}
+		})
+		return m
+	}
+	return nil
+}
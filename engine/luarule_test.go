@@ -0,0 +1,228 @@
+package engine_test
+
+import (
+	"bytes"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/engine"
+	"github.com/Nrich-sunny/crawler/parse/doubangroup"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+// luaGroupTask 与 doubangroup.DoubangroupJSTask 等价的 Lua 任务模块
+var luaGroupTask = &collect.TaskModule{
+	Property: collect.Property{Name: "lua_find_douban_sun_room", WaitTime: 2, MaxDepth: 5},
+	Language: collect.LanguageLua,
+	Root: `
+		for i = 25, 25, 25 do
+			AddReq{Url = "https://www.douban.com/group/szsh/discussion?start=" .. i, Priority = 1, RuleName = "解析网站URL"}
+		end
+	`,
+	Rules: []collect.RuleModule{
+		{Name: "解析网站URL", ParseFunc: `ctx:ParseReg("解析阳台房", '(https://www.douban.com/group/topic/[0-9a-z]+/)"[^>]*>([^<]+)</a>')`},
+		{Name: "解析阳台房", ParseFunc: `ctx:OutputReg('<div class="topic-content">[\\s\\S]*?阳台[\\s\\S]*?<div')`},
+		{Name: "标题", ParseFunc: `ctx:Output({["标题"] = ctx:Text("h1"), ["标签"] = {"阳台", "出租"}})`, ItemFields: []string{"标题", "标签"}},
+	},
+}
+
+func TestAddLuaTask(t *testing.T) {
+	store := newStore()
+	require.NoError(t, store.AddJsTask(luaGroupTask))
+	task := store.Hash[luaGroupTask.Name]
+
+	roots, err := task.Rule.Root()
+	require.NoError(t, err)
+	require.Len(t, roots, 1)
+	assert.Equal(t, "https://www.douban.com/group/szsh/discussion?start=25", roots[0].Url)
+	assert.Equal(t, 1, roots[0].Priority)
+	assert.Equal(t, "GET", roots[0].Method)
+
+	body, err := os.ReadFile("testdata/douban_group.html")
+	require.NoError(t, err)
+	roots[0].Task = task
+	result, err := task.Rule.Trunk["解析网站URL"].ParseFunc(&collect.Context{Body: body, Req: roots[0]})
+	require.NoError(t, err)
+	require.Len(t, result.Requests, 2)
+	assert.Equal(t, "https://www.douban.com/group/topic/285010123/", result.Requests[0].Url)
+	assert.Equal(t, 1, result.Requests[0].Depth)
+
+	body, err = os.ReadFile("testdata/douban_topic.html")
+	require.NoError(t, err)
+	topic := result.Requests[0]
+	res, err := task.Rule.Trunk["解析阳台房"].ParseFunc(&collect.Context{Body: body, Req: topic})
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{topic.Url}, res.Items)
+
+	res, err = task.Rule.Trunk["标题"].ParseFunc(&collect.Context{Body: []byte("<h1>朝南阳台</h1>"), Req: topic})
+	require.NoError(t, err)
+	require.Len(t, res.Items, 1)
+}
+
+func TestLuaSandbox(t *testing.T) {
+	store := newStore()
+	err := store.AddJsTask(&collect.TaskModule{
+		Property: collect.Property{Name: "lua_sandbox"},
+		Language: collect.LanguageLua,
+		Timeout:  100,
+		Root:     `AddReq{Url = "https://example.com", RuleName = "loop"}`,
+		Rules: []collect.RuleModule{
+			{Name: "loop", ParseFunc: `while true do end`},
+			{Name: "os", ParseFunc: `os.exit(1)`},
+			{Name: "load", ParseFunc: `loadstring("return 1")()`},
+			{Name: "rep", ParseFunc: `local s = string.rep("x", 1e9)`},
+			{Name: "recurse", ParseFunc: `local function f() return 1 + f() end f()`},
+			{Name: "regexp", ParseFunc: `ctx:ParseReg("loop", "(")`},
+			{Name: "global", ParseFunc: `assert(counter == nil) counter = 1`},
+		},
+	})
+	require.NoError(t, err)
+
+	task := store.Hash["lua_sandbox"]
+	req := &collect.Request{Task: task, Url: "https://example.com"}
+	for _, name := range []string{"loop", "os", "load", "rep", "recurse", "regexp"} {
+		_, err = task.Rule.Trunk[name].ParseFunc(&collect.Context{Body: []byte(""), Req: req})
+		assert.Error(t, err, name)
+	}
+	// 每次执行的全局变量互相隔离
+	for i := 0; i < 3; i++ {
+		_, err = task.Rule.Trunk["global"].ParseFunc(&collect.Context{Body: []byte(""), Req: req})
+		assert.NoError(t, err)
+	}
+
+	// 单个 LState 的内存超过上限时在超时之前中止
+	err = store.AddJsTask(&collect.TaskModule{
+		Property: collect.Property{Name: "lua_memory"},
+		Language: collect.LanguageLua,
+		Timeout:  30000,
+		Rules: []collect.RuleModule{
+			{Name: "concat", ParseFunc: `local s = "x" for i = 1, 64 do s = s .. s end`},
+			{Name: "table", ParseFunc: `local t = {} for i = 1, 1e9 do t[i] = "item" .. i end`},
+			{Name: "format", ParseFunc: `local s = "x" for i = 1, 64 do s = string.format("%s%s", s, s) end`},
+			{Name: "gsub", ParseFunc: `local s = string.rep("x", 1e6) s = s:gsub("x", function() return s end)`},
+			{Name: "tconcat", ParseFunc: `local s, t = string.rep("x", 1e6), {} for i = 1, 100 do t[i] = s end s = table.concat(t)`},
+			{Name: "strings", ParseFunc: `local s, t = string.rep("x", 1e6), {} for i = 1, 1e9 do t[i] = s .. i end`},
+			{Name: "upvalue", ParseFunc: `(function() local t = {} return function() for i = 1, 1e9 do t[i] = {i} end end end)()()`},
+			// 只分配不保留的内存不计入上限
+			{Name: "garbage", ParseFunc: `for i = 1, 1e5 do local s = string.rep("x", 1000) .. i end`},
+			{Name: "shared", ParseFunc: `local t = {} for i = 1, 1000 do t[i] = {body = ctx.Body} end`},
+			{Name: "tamper", ParseFunc: `
+				assert(string.upper ~= nil and _G.leak == nil and getmetatable("").__index.upper ~= nil)
+				string.upper = nil
+				_G.leak = 1
+				getmetatable("").__index = {}`},
+		},
+	})
+	require.NoError(t, err)
+	task = store.Hash["lua_memory"]
+	for _, name := range []string{"concat", "table", "format", "gsub", "tconcat", "strings", "upvalue"} {
+		start := time.Now()
+		_, err = task.Rule.Trunk[name].ParseFunc(&collect.Context{Body: []byte(""), Req: req})
+		require.Error(t, err, name)
+		assert.Regexp(t, "bytes of memory|result exceeds", err.Error(), name)
+		assert.Less(t, time.Since(start), 10*time.Second, name)
+	}
+	body := bytes.Repeat([]byte("x"), 1<<20)
+	for _, name := range []string{"garbage", "shared"} {
+		_, err = task.Rule.Trunk[name].ParseFunc(&collect.Context{Body: body, Req: req})
+		assert.NoError(t, err, name)
+	}
+	// 对库表、全局表和字符串元表的修改不会保留到下一次执行
+	for i := 0; i < 3; i++ {
+		_, err = task.Rule.Trunk["tamper"].ParseFunc(&collect.Context{Body: []byte(""), Req: req})
+		assert.NoError(t, err)
+	}
+
+	err = store.AddJsTask(&collect.TaskModule{
+		Property: collect.Property{Name: "lua_syntax_error"},
+		Language: collect.LanguageLua,
+		Root:     `AddReq{Url = "https://example.com"`,
+	})
+	assert.Error(t, err)
+}
+
+// benchmarkRule 使用同一份页面与宿主接口，比较不同脚本运行时执行规则的开销
+func benchmarkRule(b *testing.B, m *collect.TaskModule, rule string, fixture string) {
+	store := newStore()
+	require.NoError(b, store.AddJsTask(m))
+	task := store.Hash[m.Name]
+	body, err := os.ReadFile(fixture)
+	require.NoError(b, err)
+	req := &collect.Request{Task: task, Url: "https://www.douban.com/group/szsh/discussion?start=25"}
+	parse := task.Rule.Trunk[rule].ParseFunc
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parse(&collect.Context{Body: body, Req: req}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkOttoParseReg(b *testing.B) {
+	benchmarkRule(b, doubangroup.DoubangroupJSTask, "解析网站URL", "testdata/douban_group.html")
+}
+
+func BenchmarkLuaParseReg(b *testing.B) {
+	benchmarkRule(b, luaGroupTask, "解析网站URL", "testdata/douban_group.html")
+}
+
+func BenchmarkOttoOutputReg(b *testing.B) {
+	benchmarkRule(b, doubangroup.DoubangroupJSTask, "解析阳台房", "testdata/douban_topic.html")
+}
+
+func BenchmarkLuaOutputReg(b *testing.B) {
+	benchmarkRule(b, luaGroupTask, "解析阳台房", "testdata/douban_topic.html")
+}
+
+// 纯脚本计算，体现解释器本身的差异
+var computeModules = []*collect.TaskModule{
+	{
+		Property: collect.Property{Name: "otto_compute"},
+		Rules: []collect.RuleModule{{Name: "compute", ParseFunc: `
+			var s = 0;
+			for (var i = 0; i < 10000; i++) { s += i % 7; }
+			ctx.Output({"s": s});
+		`}},
+	},
+	{
+		Property: collect.Property{Name: "lua_compute"},
+		Language: collect.LanguageLua,
+		Rules: []collect.RuleModule{{Name: "compute", ParseFunc: `
+			local s = 0
+			for i = 0, 9999 do s = s + i % 7 end
+			ctx:Output({s = s})
+		`}},
+	},
+}
+
+func BenchmarkOttoCompute(b *testing.B) {
+	benchmarkRule(b, computeModules[0], "compute", "testdata/douban_topic.html")
+}
+
+func BenchmarkLuaCompute(b *testing.B) {
+	benchmarkRule(b, computeModules[1], "compute", "testdata/douban_topic.html")
+}
+
+// BenchmarkOttoNewVM 不使用虚拟机池时每次执行需要创建虚拟机
+func BenchmarkOttoNewVM(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if err := engine.NewJSVM(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkOttoResetVM 使用虚拟机池时每次执行后恢复全局变量
+func BenchmarkOttoResetVM(b *testing.B) {
+	reset, err := engine.ResetJSVM()
+	require.NoError(b, err)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := reset(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -13,6 +13,12 @@ type options struct {
 	Logger    *zap.Logger
 	Seeds     []*collect.Task
 	Scheduler Scheduler
+	// TaskListener 任务状态变化时的回调
+	TaskListener TaskListener
+	// History 增量爬取时页面的抓取记录
+	History History
+	// Daemon 所有请求处理完后继续运行，等待通过 Start 重新运行的任务
+	Daemon bool
 }
 
 var defaultOptions = options{
@@ -48,3 +54,21 @@ func WithScheduler(schedule Scheduler) Option {
 		opts.Scheduler = schedule
 	}
 }
+
+func WithTaskListener(listener TaskListener) Option {
+	return func(opts *options) {
+		opts.TaskListener = listener
+	}
+}
+
+func WithHistory(h History) Option {
+	return func(opts *options) {
+		opts.History = h
+	}
+}
+
+func WithDaemon(daemon bool) Option {
+	return func(opts *options) {
+		opts.Daemon = daemon
+	}
+}
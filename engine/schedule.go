@@ -1,14 +1,18 @@
 package engine
 
 import (
+	"errors"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/archive"
 	"github.com/Nrich-sunny/crawler/collect"
 	"github.com/Nrich-sunny/crawler/parse/doubanbook"
 	"github.com/Nrich-sunny/crawler/parse/doubangroup"
 	"github.com/Nrich-sunny/crawler/storage"
-	"github.com/robertkrimen/otto"
 	"go.uber.org/zap"
 	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Store 全局爬虫种类实例
@@ -20,132 +24,144 @@ var Store = &CrawlerStore{
 func init() {
 	Store.Add(doubangroup.DoubangroupTask)
 	Store.Add(doubanbook.DoubanBookTask)
-	//Storage.Add(doubangroup.DoubangroupJSTask)
 }
 
-func GetFields(taskName string, ruleName string) []string {
-	return Store.Hash[taskName].Rule.Trunk[ruleName].ItemFields
-}
+var (
+	builtinOnce sync.Once
+	builtinErr  error
+)
 
-type CrawlerStore struct {
-	list []*collect.Task          // 任务队列
-	Hash map[string]*collect.Task // 任务哈希表， 任务名 -> 任务
+// LoadBuiltinTasks 注册内置的 JS 任务，脚本编译失败时返回错误，多次调用只注册一次
+func LoadBuiltinTasks() error {
+	builtinOnce.Do(func() {
+		if err := Store.AddJsTask(doubangroup.DoubangroupJSTask); err != nil {
+			builtinErr = fmt.Errorf("load builtin task %s failed:%w", doubangroup.DoubangroupJSTask.Name, err)
+		}
+	})
+	return builtinErr
 }
 
-func (c *CrawlerStore) Add(task *collect.Task) {
-	c.Hash[task.Name] = task
-	c.list = append(c.list, task)
+func GetFields(taskName string, ruleName string) []string {
+	task, _ := Store.Get(taskName)
+	return task.Rule.Trunk[ruleName].ItemFields
 }
 
-// AddJsReqs 用于动态规则添加请求。
-func AddJsReqs(jreqs []map[string]interface{}) []*collect.Request {
-	reqs := make([]*collect.Request, 0)
-
-	for _, jreq := range jreqs {
-		req := &collect.Request{}
-		u, ok := jreq["Url"].(string)
-		if !ok {
-			return nil
-		}
-		req.Url = u
-		req.RuleName, _ = jreq["RuleName"].(string)
-		req.Method, _ = jreq["Method"].(string)
-		req.Priority, _ = jreq["Priority"].(int)
-		reqs = append(reqs, req)
+// GetRule 返回任务中的规则，任务或规则不存在时返回 nil
+func GetRule(taskName string, ruleName string) *collect.Rule {
+	task, ok := Store.Get(taskName)
+	if !ok {
+		return nil
 	}
-	return reqs
+	return task.Rule.Trunk[ruleName]
 }
 
-// AddJsReq 用于动态规则添加请求。
-func AddJsReq(jreq map[string]interface{}) []*collect.Request {
-	reqs := make([]*collect.Request, 0)
-	req := &collect.Request{}
-	u, ok := jreq["Url"].(string)
+// GetTableKey 返回任务的数据表的自然键，任务不存在或没有声明自然键时返回 nil
+func GetTableKey(taskName string) []string {
+	task, ok := Store.Get(taskName)
 	if !ok {
 		return nil
 	}
-	req.Url = u
-	req.RuleName, _ = jreq["RuleName"].(string)
-	req.Method, _ = jreq["Method"].(string)
-	req.Priority, _ = jreq["Priority"].(int)
-	reqs = append(reqs, req)
-	return reqs
+	return task.Rule.Key()
 }
 
-// AddJsTask 初始化任务与规则
-func (c *CrawlerStore) AddJsTask(m *collect.TaskModule) {
-	task := &collect.Task{}
-
-	task.Rule.Root = func() ([]*collect.Request, error) {
-		vm := otto.New()
-		vm.Set("AddJsReq", AddJsReqs)
-		v, err := vm.Eval(m.Root)
-		if err != nil {
-			return nil, err
-		}
-		e, err := v.Export()
-		if err != nil {
-			return nil, err
-		}
-		return e.([]*collect.Request), nil
-	}
+// CrawlerStore 任务的注册表，运行时可以动态加载任务模块，因此需要加锁
+type CrawlerStore struct {
+	mu   sync.RWMutex
+	list []*collect.Task          // 任务队列
+	Hash map[string]*collect.Task // 任务哈希表， 任务名 -> 任务
+}
 
-	for _, r := range m.Rules {
-		parseFunc := func(parse string) func(ctx *collect.Context) (collect.ParseResult, error) {
-			return func(ctx *collect.Context) (collect.ParseResult, error) {
-				vm := otto.New()
-				vm.Set("ctx", ctx)
-				v, err := vm.Eval(parse)
-				if err != nil {
-					return collect.ParseResult{}, err
-				}
-				e, err := v.Export()
-				if err != nil {
-					return collect.ParseResult{}, err
-				}
-				if e == nil {
-					return collect.ParseResult{}, err
-				}
-				return e.(collect.ParseResult), err
+// Add 注册任务，同名的任务会被替换
+func (c *CrawlerStore) Add(task *collect.Task) {
+	task.Rule.InitFields()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.Hash[task.Name]; ok {
+		for i, t := range c.list {
+			if t.Name == task.Name {
+				c.list[i] = task
 			}
-		}(r.ParseFunc)
-		if task.Rule.Trunk == nil {
-			task.Rule.Trunk = make(map[string]*collect.Rule, 0)
-		}
-		task.Rule.Trunk[r.Name] = &collect.Rule{
-			ParseFunc: parseFunc,
 		}
+	} else {
+		c.list = append(c.list, task)
 	}
-
 	c.Hash[task.Name] = task
-	c.list = append(c.list, task)
+}
+
+// Get 按任务名查找任务
+func (c *CrawlerStore) Get(name string) (*collect.Task, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	t, ok := c.Hash[name]
+	return t, ok
 }
 
 type Crawler struct {
 	outCh       chan collect.ParseResult // 负责处理爬取后的数据
 	Visited     map[string]bool
 	VisitedLock sync.Mutex
+	taskVisited map[string][]string // 任务名 -> 任务访问过的请求，重新运行任务时清除
 
 	failures     map[string]*collect.Request // 失败请求id -> 失败请求
 	failuresLock sync.Mutex
 
+	pending  int64         // 已加入调度器但尚未处理完的请求数，为 0 时表示没有待抓取的请求
+	done     chan struct{} // 所有请求处理完后关闭
+	doneOnce sync.Once
+	stats    Stats
+
+	tasks     map[string]*taskTracker // 任务名 -> 任务的状态
+	tasksLock sync.Mutex
+	notifier  *notifier // 通知 TaskListener，未设置监听者时为空
+
 	options
 }
 
+// Stats 爬取的进度
+type Stats struct {
+	Pending int64 // 尚未处理完的请求数
+	Fetched int64 // 抓取成功的页面数
+	Failed  int64 // 抓取失败的次数
+	Items   int64 // 输出的数据条数
+	Skipped int64 // 增量爬取时跳过的页面数
+	Invalid int64 // 不符合 Schema 被丢弃的数据条数
+	Dropped int64 // 被处理器丢弃的数据条数
+}
+
 type Scheduler interface {
-	Schedule()                // 负责启动调度器
-	Push(...*collect.Request) // 将请求放入到调度器中
-	Pull() *collect.Request   // 从调度器中获取请求
+	Schedule()                           // 负责启动调度器
+	Push(...*collect.Request)            // 将请求放入到调度器中
+	Pull() *collect.Request              // 从调度器中获取请求
+	Hold(task string)                    // 搁置任务的请求，不再分配给 Worker
+	Release(task string)                 // 恢复分配任务被搁置的请求
+	Drop(task string) []*collect.Request // 丢弃任务在调度器中的请求，返回被丢弃的请求
 }
 
 type ScheduleEngine struct {
 	requestCh   chan *collect.Request
 	workerCh    chan *collect.Request
+	controlCh   chan scheduleControl
 	priReqQueue []*collect.Request
 	reqQueue    []*collect.Request
+	held        map[string][]*collect.Request // 被搁置的任务 -> 搁置的请求
 	Logger      *zap.Logger
 }
 
+type scheduleOp int
+
+const (
+	opHold scheduleOp = iota
+	opRelease
+	opDrop
+)
+
+// scheduleControl 对单个任务的请求的操作，由调度协程执行
+type scheduleControl struct {
+	op    scheduleOp
+	task  string
+	reply chan []*collect.Request
+}
+
 func NewEngine(opts ...Option) *Crawler {
 	options := defaultOptions
 	for _, opt := range opts {
@@ -153,9 +169,21 @@ func NewEngine(opts ...Option) *Crawler {
 	}
 	crawler := &Crawler{}
 	crawler.Visited = make(map[string]bool, 100)
+	crawler.taskVisited = make(map[string][]string)
 	crawler.outCh = make(chan collect.ParseResult)
 	crawler.failures = make(map[string]*collect.Request)
+	crawler.done = make(chan struct{})
+	crawler.tasks = make(map[string]*taskTracker)
+	if options.History == nil {
+		options.History = NewMemHistory()
+	}
 	crawler.options = options
+	if options.TaskListener != nil {
+		crawler.notifier = newNotifier(options.TaskListener)
+	}
+	for _, seed := range crawler.Seeds {
+		crawler.tracker(seed.Name)
+	}
 	return crawler
 }
 
@@ -165,6 +193,8 @@ func NewSchedule() *ScheduleEngine {
 	workCh := make(chan *collect.Request)    // 负责分配任务
 	s.requestCh = requestCh
 	s.workerCh = workCh
+	s.controlCh = make(chan scheduleControl)
+	s.held = make(map[string][]*collect.Request)
 	return s
 }
 
@@ -193,16 +223,73 @@ func (s *ScheduleEngine) Schedule() {
 		}
 		select {
 		case r := <-s.requestCh:
-			if r.Priority > 0 {
-				s.priReqQueue = append(s.priReqQueue, r)
-			} else {
-				s.reqQueue = append(s.reqQueue, r)
+			if _, ok := s.held[r.Task.Name]; ok {
+				s.held[r.Task.Name] = append(s.held[r.Task.Name], r)
+				continue
 			}
+			s.enqueue(r)
 		case ch <- req:
 			req = nil
 			ch = nil
+		case c := <-s.controlCh:
+			// 已取出但尚未分配的请求同样受操作影响
+			if req != nil && req.Task.Name == c.task {
+				s.held[c.task] = append(s.held[c.task], req)
+				req = nil
+				ch = nil
+			}
+			s.control(c)
+		}
+	}
+}
+
+func (s *ScheduleEngine) enqueue(r *collect.Request) {
+	if r.Priority > 0 {
+		s.priReqQueue = append(s.priReqQueue, r)
+	} else {
+		s.reqQueue = append(s.reqQueue, r)
+	}
+}
+
+// control 执行对任务请求的操作，只在调度协程中调用
+func (s *ScheduleEngine) control(c scheduleControl) {
+	switch c.op {
+	case opHold:
+		var held []*collect.Request
+		held, s.priReqQueue = splitTask(s.priReqQueue, c.task)
+		s.held[c.task] = append(s.held[c.task], held...)
+		held, s.reqQueue = splitTask(s.reqQueue, c.task)
+		s.held[c.task] = append(s.held[c.task], held...)
+		if s.held[c.task] == nil {
+			s.held[c.task] = []*collect.Request{}
+		}
+	case opRelease:
+		for _, r := range s.held[c.task] {
+			s.enqueue(r)
+		}
+		delete(s.held, c.task)
+	case opDrop:
+		dropped := s.held[c.task]
+		delete(s.held, c.task)
+		var reqs []*collect.Request
+		reqs, s.priReqQueue = splitTask(s.priReqQueue, c.task)
+		dropped = append(dropped, reqs...)
+		reqs, s.reqQueue = splitTask(s.reqQueue, c.task)
+		dropped = append(dropped, reqs...)
+		c.reply <- dropped
+	}
+}
+
+// splitTask 将队列分为属于任务的请求与其余的请求
+func splitTask(queue []*collect.Request, task string) (matched []*collect.Request, rest []*collect.Request) {
+	for _, r := range queue {
+		if r.Task.Name == task {
+			matched = append(matched, r)
+		} else {
+			rest = append(rest, r)
 		}
 	}
+	return matched, rest
 }
 
 func (s *ScheduleEngine) Push(reqs ...*collect.Request) {
@@ -216,116 +303,354 @@ func (s *ScheduleEngine) Pull() *collect.Request {
 	return r
 }
 
+func (s *ScheduleEngine) Hold(task string) {
+	s.controlCh <- scheduleControl{op: opHold, task: task}
+}
+
+func (s *ScheduleEngine) Release(task string) {
+	s.controlCh <- scheduleControl{op: opRelease, task: task}
+}
+
+func (s *ScheduleEngine) Drop(task string) []*collect.Request {
+	reply := make(chan []*collect.Request, 1)
+	s.controlCh <- scheduleControl{op: opDrop, task: task, reply: reply}
+	return <-reply
+}
+
 //func (s *ScheduleEngine) Output() *collect.Request {
 //	r := <-s.workerCh
 //	return r
 //}
 
+// Run 启动爬虫，所有请求处理完后返回，常驻运行时不返回
 func (crawler *Crawler) Run() {
 	go crawler.Schedule()
 	for i := 0; i < crawler.WorkCount; i++ {
 		go crawler.CreateWork()
 	}
 	crawler.HandleResult()
+	crawler.flush()
+	if crawler.notifier != nil {
+		crawler.notifier.wait()
+	}
+}
+
+// Done 返回一个在所有请求处理完后关闭的通道，常驻运行时不会关闭
+func (crawler *Crawler) Done() <-chan struct{} {
+	return crawler.done
+}
+
+// Stats 返回当前的爬取进度
+func (crawler *Crawler) Stats() Stats {
+	return Stats{
+		Pending: atomic.LoadInt64(&crawler.pending),
+		Fetched: atomic.LoadInt64(&crawler.stats.Fetched),
+		Failed:  atomic.LoadInt64(&crawler.stats.Failed),
+		Items:   atomic.LoadInt64(&crawler.stats.Items),
+		Skipped: atomic.LoadInt64(&crawler.stats.Skipped),
+		Invalid: atomic.LoadInt64(&crawler.stats.Invalid),
+		Dropped: atomic.LoadInt64(&crawler.stats.Dropped),
+	}
+}
+
+// push 将请求加入调度器，加入前增加待处理的请求数，已取消任务的请求被丢弃
+func (crawler *Crawler) push(reqs ...*collect.Request) {
+	reqs = crawler.queue(reqs)
+	if len(reqs) == 0 {
+		return
+	}
+	atomic.AddInt64(&crawler.pending, int64(len(reqs)))
+	go crawler.Scheduler.Push(reqs...)
+}
+
+// outcome 一个请求的处理结果
+type outcome struct {
+	fetched bool
+	failed  bool
+	items   int
+}
+
+// finish 一个请求处理完毕，没有待处理的请求时结束爬取
+func (crawler *Crawler) finish(r *collect.Request, o *outcome) {
+	crawler.requestDone(r, o.fetched, o.failed, o.items)
+	crawler.release(1)
+}
+
+// release 减少待处理的请求数，没有待处理的请求时结束爬取
+func (crawler *Crawler) release(n int) {
+	if n > 0 && atomic.AddInt64(&crawler.pending, -int64(n)) == 0 {
+		crawler.idle()
+	}
+}
+
+// idle 没有待处理的请求，常驻运行时继续等待新的任务
+func (crawler *Crawler) idle() {
+	if crawler.Daemon {
+		return
+	}
+	crawler.doneOnce.Do(func() {
+		close(crawler.done)
+	})
 }
 
 func (crawler *Crawler) Schedule() {
-	var reqs []*collect.Request
+	go crawler.Scheduler.Schedule()
 	for _, task := range crawler.Seeds {
-		t, ok := Store.Hash[task.Name]
-		if !ok {
-			crawler.Logger.Error("task not found", zap.String("task name", task.Name))
-			continue
-		}
-		task.Rule = t.Rule
-		// 获取初始化任务
-		rootReqs, err := task.Rule.Root()
-		if err != nil {
-			crawler.Logger.Error("get root failed",
-				zap.Error(err),
-			)
+		crawler.runTask(task)
+	}
+	if atomic.LoadInt64(&crawler.pending) == 0 {
+		crawler.idle()
+	}
+}
+
+// runTask 生成任务的种子请求并加入调度器
+func (crawler *Crawler) runTask(task *collect.Task) {
+	t, ok := Store.Get(task.Name)
+	if !ok {
+		crawler.Logger.Error("task not found", zap.String("task name", task.Name))
+		crawler.fail(task, errors.New("task not found"))
+		return
+	}
+	task.Rule = t.Rule
+	// 获取初始化任务
+	rootReqs, err := task.Rule.Root()
+	if err != nil {
+		crawler.Logger.Error("get root failed",
+			zap.Error(err),
+		)
+		crawler.fail(task, fmt.Errorf("get root failed:%w", err))
+		return
+	}
+	for _, req := range rootReqs {
+		req.Task = task
+	}
+	if !crawler.start(task, len(rootReqs)) {
+		return
+	}
+	if len(rootReqs) > 0 {
+		crawler.push(rootReqs...)
+	}
+}
+
+func (crawler *Crawler) CreateWork() {
+	for {
+		r := crawler.Scheduler.Pull()
+		// 任务暂停时搁置请求，任务取消时丢弃请求
+		if crawler.park(r) {
 			continue
 		}
-		for _, req := range rootReqs {
-			req.Task = task
-		}
-		reqs = append(reqs, rootReqs...)
+		crawler.handle(r)
 	}
-	go crawler.Scheduler.Schedule()
-	go crawler.Scheduler.Push(reqs...)
 }
 
-func (crawler *Crawler) CreateWork() {
+// handle 抓取并解析一个请求，新的请求在当前请求处理完之前加入调度器
+func (crawler *Crawler) handle(r *collect.Request) {
+	o := &outcome{}
+	defer crawler.finish(r, o)
 	defer func() {
 		if err := recover(); err != nil {
 			crawler.Logger.Error("worker panic", zap.Any("err", err), zap.String("stack", string(debug.Stack())))
 		}
 	}()
-	for {
-		r := crawler.Scheduler.Pull()
-		// 检查当前 request 是否已经达到最大深度限制
-		if err := r.Check(); err != nil {
-			crawler.Logger.Error("check failed")
-			continue
-		}
-		// 判断当前是否已经访问
-		if crawler.HasVisited(r) {
-			crawler.Logger.Debug("request has Visited", zap.String("url:", r.Url))
-			continue
-		}
-		// 设置当前请求已被访问
-		crawler.StoreVisited(r)
 
-		body, err := crawler.Fetcher.Get(r)
-		if err != nil {
-			crawler.Logger.Error("can't fetch ", zap.Error(err))
-			crawler.SetFailure(r)
-			continue
-		}
+	// 检查当前 request 是否已经达到最大深度限制
+	if err := r.Check(); err != nil {
+		crawler.Logger.Error("check failed")
+		return
+	}
+	// 判断当前是否已经访问
+	if crawler.HasVisited(r) {
+		crawler.Logger.Debug("request has Visited", zap.String("url:", r.Url))
+		return
+	}
+	// 设置当前请求已被访问
+	crawler.StoreVisited(r)
 
-		// 获取当前任务对应的规则
-		rule, ok := r.Task.Rule.Trunk[r.RuleName]
-		if !ok {
-			crawler.Logger.Error("rule not found", zap.String("rule name", r.RuleName))
-			continue
-		}
-		// 内容解析
-		result, err := rule.ParseFunc(&collect.Context{
-			Body: body,
-			Req:  r,
-		})
-
-		if err != nil {
-			crawler.Logger.Error("ParseFunc failed ",
-				zap.Error(err),
-				zap.String("url", r.Url),
-			)
-			continue
+	// 增量爬取，跳过在重新抓取间隔内抓取过的页面
+	if crawler.recent(r) {
+		crawler.Logger.Debug("request fetched recently", zap.String("url:", r.Url))
+		atomic.AddInt64(&crawler.stats.Skipped, 1)
+		return
+	}
+
+	// 优先使用任务自身配置的 Fetcher
+	fetcher := crawler.Fetcher
+	if r.Task.Fetcher != nil {
+		fetcher = r.Task.Fetcher
+	}
+	resp, err := collect.Fetch(fetcher, r)
+	if err != nil {
+		crawler.Logger.Error("can't fetch ", zap.Error(err))
+		atomic.AddInt64(&crawler.stats.Failed, 1)
+		o.failed = true
+		crawler.SetFailure(r)
+		return
+	}
+	atomic.AddInt64(&crawler.stats.Fetched, 1)
+	o.fetched = true
+	body := resp.Body
+	unchanged := crawler.record(r, body)
+
+	// 归档原始网页
+	if r.Task.Archiver != nil {
+		crawler.Archive(r, resp)
+	}
+
+	// 获取当前任务对应的规则
+	rule, ok := r.Task.Rule.Trunk[r.RuleName]
+	if !ok {
+		crawler.Logger.Error("rule not found", zap.String("rule name", r.RuleName))
+		return
+	}
+	// 内容解析
+	ctx := &collect.Context{
+		Body: body,
+		Req:  r,
+	}
+	result, err := rule.ParseFunc(ctx)
+
+	if err != nil {
+		crawler.Logger.Error("ParseFunc failed ",
+			zap.Error(err),
+			zap.String("url", r.Url),
+		)
+		return
+	}
+	// FIXME: 为啥要在创建请求任务的时候处理结果呢。。
+	// 新的任务加入队列中
+	if len(result.Requests) > 0 {
+		crawler.push(result.Requests...)
+	}
+	// 内容未变化的页面已经输出过数据
+	if unchanged {
+		result.Items = nil
+	}
+	// 数据先经过处理器再校验，处理器转换过类型的字段按转换后的类型校验
+	result.Items = crawler.process(ctx, result.Items)
+	result.Items = crawler.checkItems(ctx.Req.Task.Pipeline.Schema(rule.Schema), result.Items)
+	o.items = len(result.Items)
+	crawler.outCh <- result
+}
+
+// process 数据依次经过任务的处理器，丢弃处理器丢弃或处理失败的数据
+func (crawler *Crawler) process(ctx *collect.Context, items []interface{}) []interface{} {
+	pipeline := ctx.Req.Task.Pipeline
+	if len(pipeline) == 0 {
+		return items
+	}
+	kept := items[:0]
+	for _, item := range items {
+		if cell, ok := item.(*storage.DataCell); ok {
+			keep, err := pipeline.Process(ctx, cell)
+			if err != nil {
+				crawler.Logger.Warn("process item failed", zap.Error(err), zap.String("url", cell.GetUrl()))
+			}
+			if !keep {
+				atomic.AddInt64(&crawler.stats.Dropped, 1)
+				continue
+			}
 		}
-		// FIXME: 为啥要在创建请求任务的时候处理结果呢。。
-		// 新的任务加入队列中
-		if len(result.Requests) > 0 {
-			go crawler.Scheduler.Push(result.Requests...)
+		kept = append(kept, item)
+	}
+	return kept
+}
+
+// checkItems 丢弃不符合 Schema 的数据
+func (crawler *Crawler) checkItems(schema collect.Schema, items []interface{}) []interface{} {
+	if len(schema) == 0 {
+		return items
+	}
+	valid := items[:0]
+	for _, item := range items {
+		if cell, ok := item.(*storage.DataCell); ok {
+			if err := schema.Validate(cell.GetRuleName(), cell.GetData()); err != nil {
+				crawler.Logger.Warn("drop invalid item", zap.Error(err), zap.String("url", cell.GetUrl()))
+				atomic.AddInt64(&crawler.stats.Invalid, 1)
+				continue
+			}
 		}
-		crawler.outCh <- result
+		valid = append(valid, item)
 	}
+	return valid
 }
 
+// Archive 将抓取到的原始网页写入任务配置的归档中，Fetcher 返回了原始响应时归档原始响应
+func (crawler *Crawler) Archive(r *collect.Request, resp *collect.Response) {
+	rec := &archive.Record{
+		Url:       r.Url,
+		Method:    r.Method,
+		Body:      resp.Body,
+		FetchTime: time.Now(),
+		Task:      r.Task.Name,
+		Rule:      r.RuleName,
+		Depth:     r.Depth,
+	}
+	if resp.Proto != "" {
+		rec.Proto = resp.Proto
+		rec.Status = resp.Status
+		rec.Header = resp.Header
+		rec.Body = resp.Raw
+	}
+	if err := r.Task.Archiver.Archive(rec); err != nil {
+		crawler.Logger.Error("archive failed", zap.Error(err), zap.String("url", r.Url))
+	}
+}
+
+// HandleResult 存储解析出的数据，所有请求处理完后返回
 func (crawler *Crawler) HandleResult() {
 	for {
 		select {
 		case result := <-crawler.outCh:
 			for _, item := range result.Items {
+				atomic.AddInt64(&crawler.stats.Items, 1)
 				switch d := item.(type) {
 				case *storage.DataCell:
 					name := d.GetTaskName()
-					task := Store.Hash[name]
-					if err := task.Storage.Save(d); err != nil {
-						crawler.Logger.Error("")
+					s := crawler.storage(name)
+					if s == nil {
+						crawler.Logger.Warn("task has no storage", zap.String("task", name))
+						break
+					}
+					if err := s.Save(d); err != nil {
+						crawler.Logger.Error("save data failed", zap.Error(err), zap.String("task", name), zap.String("url", d.GetUrl()))
 					}
 				}
 				crawler.Logger.Sugar().Info("get result: ", item)
 			}
+		case <-crawler.done:
+			return
+		}
+	}
+}
+
+// storage 返回任务的存储，优先使用配置中的任务
+func (crawler *Crawler) storage(name string) storage.Storage {
+	for _, seed := range crawler.Seeds {
+		if seed.Name == name && seed.Storage != nil {
+			return seed.Storage
+		}
+	}
+	if t, ok := Store.Get(name); ok {
+		return t.Storage
+	}
+	return nil
+}
+
+// flush 将存储中缓存的数据写入
+func (crawler *Crawler) flush() {
+	flushed := make(map[storage.Storage]bool)
+	for _, seed := range crawler.Seeds {
+		if seed.Storage == nil || flushed[seed.Storage] {
+			continue
+		}
+		flushed[seed.Storage] = true
+		if err := seed.Storage.Flush(); err != nil {
+			crawler.Logger.Error("flush storage failed", zap.Error(err), zap.String("task", seed.Name))
+		}
+	}
+	if f, ok := crawler.History.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			crawler.Logger.Error("flush history failed", zap.Error(err))
 		}
 	}
 }
@@ -344,7 +669,18 @@ func (crawler *Crawler) StoreVisited(reqs ...*collect.Request) {
 	for _, r := range reqs {
 		unique := r.Unique()
 		crawler.Visited[unique] = true
+		crawler.taskVisited[r.Task.Name] = append(crawler.taskVisited[r.Task.Name], unique)
+	}
+}
+
+// clearVisited 清除任务访问过的请求
+func (crawler *Crawler) clearVisited(task string) {
+	crawler.VisitedLock.Lock()
+	defer crawler.VisitedLock.Unlock()
+	for _, unique := range crawler.taskVisited[task] {
+		delete(crawler.Visited, unique)
 	}
+	delete(crawler.taskVisited, task)
 }
 
 func (crawler *Crawler) SetFailure(r *collect.Request) {
@@ -353,7 +689,7 @@ func (crawler *Crawler) SetFailure(r *collect.Request) {
 		unique := r.Unique()
 		delete(crawler.Visited, unique)
 		r.Reload = false // 下次进来就非首次失败了，不是可重复的请求了
-		crawler.Scheduler.Push(r)
+		crawler.push(r)
 		return
 	}
 
@@ -0,0 +1,390 @@
+package engine_test
+
+import (
+	"fmt"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/engine"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStorage 将数据保存在内存中
+type memStorage struct {
+	mu      sync.Mutex
+	cells   []*storage.DataCell
+	flushed bool
+}
+
+func (s *memStorage) Save(cells ...*storage.DataCell) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cells = append(s.cells, cells...)
+	return nil
+}
+
+func (s *memStorage) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flushed = true
+	return nil
+}
+
+func (s *memStorage) Close() error {
+	return s.Flush()
+}
+
+// statusStorage 记录 Flush 的次数，Flush 时查询任务的状态，持有任务的锁调用 Flush 会死锁
+type statusStorage struct {
+	mu      sync.Mutex
+	crawler *engine.Crawler
+	name    string
+	flushes int
+}
+
+func (s *statusStorage) Save(cells ...*storage.DataCell) error {
+	return nil
+}
+
+func (s *statusStorage) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.crawler != nil {
+		s.crawler.TaskStatus(s.name)
+	}
+	s.flushes++
+	return nil
+}
+
+func (s *statusStorage) Close() error {
+	return nil
+}
+
+func (s *statusStorage) flushed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushes
+}
+
+func TestCrawlerRunUntilDone(t *testing.T) {
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				return []*collect.Request{{Url: "https://book.douban.com", Method: "GET", RuleName: "数据tag"}}, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				"数据tag": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{Requests: ctx.FollowLinks("a.tag", "书籍列表")}, nil
+				}},
+				"书籍列表": {ItemFields: []string{"书名"}, ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{Items: []interface{}{ctx.Output(map[string]interface{}{"书名": ctx.Text("h2")})}}, nil
+				}},
+			},
+		},
+		Options: collect.Options{Name: "run_until_done"},
+	})
+
+	s := &memStorage{}
+	seed := collect.NewTask(collect.WithName("run_until_done"), collect.WithStorage(s))
+	seed.MaxDepth = 5
+	seed.Fetcher = pageFetcher{
+		"https://book.douban.com":       `<a class="tag" href="/tag/1">小说</a><a class="tag" href="/tag/2">历史</a><a class="tag" href="/tag/3">失败</a>`,
+		"https://book.douban.com/tag/1": `<h2>三体</h2>`,
+		"https://book.douban.com/tag/2": `<h2>万历十五年</h2>`,
+	}
+	var mu sync.Mutex
+	var states []engine.TaskState
+	crawler := engine.NewEngine(
+		engine.WithWorkCount(2),
+		engine.WithSeeds([]*collect.Task{seed, collect.NewTask(collect.WithName("not_registered"))}),
+		engine.WithScheduler(engine.NewSchedule()),
+		engine.WithTaskListener(func(status engine.TaskStatus) {
+			if status.Name != "run_until_done" {
+				return
+			}
+			mu.Lock()
+			states = append(states, status.State)
+			mu.Unlock()
+		}),
+	)
+
+	finished := make(chan struct{})
+	go func() {
+		crawler.Run()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("crawler did not stop when frontier is empty")
+	}
+
+	stats := crawler.Stats()
+	assert.Equal(t, int64(0), stats.Pending)
+	assert.Equal(t, int64(4), stats.Fetched)
+	assert.Equal(t, int64(3), stats.Items)
+	require.Len(t, s.cells, 3)
+	assert.True(t, s.flushed)
+
+	status, ok := crawler.TaskStatus("run_until_done")
+	require.True(t, ok)
+	assert.Equal(t, engine.TaskCompleted, status.State)
+	assert.Equal(t, int64(0), status.Queued)
+	assert.Equal(t, int64(0), status.InFlight)
+	assert.Equal(t, int64(4), status.Completed)
+	assert.Equal(t, int64(3), status.Items)
+	assert.False(t, status.EndTime.Before(status.StartTime))
+	mu.Lock()
+	assert.Equal(t, []engine.TaskState{engine.TaskRunning, engine.TaskCompleted}, states)
+	mu.Unlock()
+
+	status, ok = crawler.TaskStatus("not_registered")
+	require.True(t, ok)
+	assert.Equal(t, engine.TaskFailed, status.State)
+	assert.NotEmpty(t, status.Err)
+	assert.Error(t, crawler.Pause("run_until_done"))
+}
+
+// gateFetcher 列表页直接返回，其余页面在 gate 关闭前阻塞
+type gateFetcher struct {
+	gate chan struct{}
+}
+
+func (f gateFetcher) Get(r *collect.Request) ([]byte, error) {
+	if r.RuleName != "列表" {
+		<-f.gate
+	}
+	return []byte(""), nil
+}
+
+// startGatedCrawler 运行一个列表页生成 5 个详情页请求的任务，第一个详情页的抓取被阻塞
+func startGatedCrawler(t *testing.T, name string, s *statusStorage) (*engine.Crawler, chan struct{}, chan struct{}) {
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				return []*collect.Request{{Url: "https://example.com/list", Method: "GET", RuleName: "列表"}}, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				"列表": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					var result collect.ParseResult
+					for i := 0; i < 5; i++ {
+						result.Requests = append(result.Requests, ctx.NewRequest(fmt.Sprintf("https://example.com/%d", i), "详情"))
+					}
+					return result, nil
+				}},
+				"详情": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{}, nil
+				}},
+			},
+		},
+		Options: collect.Options{Name: name},
+	})
+	gate := make(chan struct{})
+	seed := collect.NewTask(collect.WithName(name), collect.WithFetcher(gateFetcher{gate: gate}), collect.WithStorage(s))
+	seed.MaxDepth = 5
+	crawler := engine.NewEngine(
+		engine.WithWorkCount(1),
+		engine.WithSeeds([]*collect.Task{seed}),
+		engine.WithScheduler(engine.NewSchedule()),
+	)
+	s.mu.Lock()
+	s.crawler, s.name = crawler, name
+	s.mu.Unlock()
+	finished := make(chan struct{})
+	go func() {
+		crawler.Run()
+		close(finished)
+	}()
+	require.Eventually(t, func() bool {
+		status, _ := crawler.TaskStatus(name)
+		return status.InFlight == 1 && status.Queued == 4
+	}, 5*time.Second, 10*time.Millisecond)
+	return crawler, gate, finished
+}
+
+func TestCrawlerPauseResume(t *testing.T) {
+	s := &statusStorage{}
+	crawler, gate, finished := startGatedCrawler(t, "pause_resume", s)
+	require.NoError(t, crawler.Pause("pause_resume"))
+	close(gate)
+
+	// 暂停期间只完成已经在处理的请求
+	require.Eventually(t, func() bool {
+		status, _ := crawler.TaskStatus("pause_resume")
+		return status.InFlight == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	status, _ := crawler.TaskStatus("pause_resume")
+	assert.Equal(t, engine.TaskPaused, status.State)
+	assert.Equal(t, int64(2), status.Completed)
+	assert.Equal(t, int64(4), status.Queued)
+
+	require.NoError(t, crawler.Resume("pause_resume"))
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("crawler did not finish after resume")
+	}
+	status, _ = crawler.TaskStatus("pause_resume")
+	assert.Equal(t, engine.TaskCompleted, status.State)
+	assert.Equal(t, int64(6), status.Completed)
+	// 任务结束时写入一次，爬虫退出时再写入一次
+	assert.Equal(t, 2, s.flushed())
+}
+
+func TestCrawlerCancel(t *testing.T) {
+	s := &statusStorage{}
+	crawler, gate, finished := startGatedCrawler(t, "cancel", s)
+	require.NoError(t, crawler.Cancel("cancel"))
+	// 取消时写入已经缓存的数据
+	assert.Equal(t, 1, s.flushed())
+	close(gate)
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("crawler did not finish after cancel")
+	}
+	status, _ := crawler.TaskStatus("cancel")
+	assert.Equal(t, engine.TaskCancelled, status.State)
+	assert.Equal(t, int64(2), status.Completed)
+	assert.Equal(t, int64(0), status.Queued)
+	assert.Error(t, crawler.Resume("cancel"))
+	// 正在处理的请求完成后、爬虫退出时各写入一次
+	assert.Equal(t, 3, s.flushed())
+}
+
+// countFetcher 记录抓取的次数
+type countFetcher struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (f *countFetcher) Get(r *collect.Request) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.count++
+	return []byte(`<a href="/1">1</a><a href="/2">2</a>`), nil
+}
+
+func (f *countFetcher) fetched() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.count
+}
+
+func TestCrawlerStart(t *testing.T) {
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				return []*collect.Request{{Url: "https://example.com/start", Method: "GET", RuleName: "列表"}}, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				"列表": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{Requests: ctx.FollowLinks("a", "详情")}, nil
+				}},
+				"详情": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{}, nil
+				}},
+			},
+		},
+		Options: collect.Options{Name: "start"},
+	})
+	fetcher := &countFetcher{}
+	seed := collect.NewTask(collect.WithName("start"), collect.WithFetcher(fetcher))
+	seed.MaxDepth = 5
+	crawler := engine.NewEngine(
+		engine.WithWorkCount(2),
+		engine.WithSeeds([]*collect.Task{seed}),
+		engine.WithScheduler(engine.NewSchedule()),
+		engine.WithDaemon(true),
+	)
+	go crawler.Run()
+	completed := func() bool {
+		status, _ := crawler.TaskStatus("start")
+		return status.State == engine.TaskCompleted
+	}
+	require.Eventually(t, completed, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 3, fetcher.fetched())
+
+	// 常驻运行时所有请求处理完后不会结束
+	select {
+	case <-crawler.Done():
+		t.Fatal("daemon crawler should not be done")
+	default:
+	}
+
+	// 重新运行时清除任务访问过的请求
+	require.NoError(t, crawler.Start("start"))
+	require.Eventually(t, completed, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 6, fetcher.fetched())
+	status, _ := crawler.TaskStatus("start")
+	assert.Equal(t, int64(3), status.Completed)
+}
+
+func TestCrawlerPipeline(t *testing.T) {
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				return []*collect.Request{{Url: "https://book.douban.com", Method: "GET", RuleName: "书籍列表"}}, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				// 页数声明为字符串，由处理器转换为整数；价格声明为数字，由处理器从 "45.00元" 中提取
+				"书籍列表": {
+					Schema: collect.Schema{
+						{Name: "书名", Type: collect.FieldString},
+						{Name: "页数", Type: collect.FieldString},
+						{Name: "价格", Type: collect.FieldFloat},
+					},
+					ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+						var items []interface{}
+						ctx.Find("h2").Each(func(i int, s *goquery.Selection) {
+							items = append(items, ctx.Output(map[string]interface{}{
+								"书名": s.Text(),
+								"页数": s.AttrOr("data-pages", ""),
+								"价格": s.AttrOr("data-price", ""),
+							}))
+						})
+						return collect.ParseResult{Items: items}, nil
+					},
+				},
+			},
+		},
+		Options: collect.Options{Name: "pipeline"},
+	})
+
+	s := &memStorage{}
+	drop, err := collect.DropMatch("^$", "书名")
+	require.NoError(t, err)
+	pages, err := collect.Coerce(collect.FieldInt, "页数")
+	require.NoError(t, err)
+	price, err := collect.Coerce(collect.FieldFloat, "价格")
+	require.NoError(t, err)
+	seed := collect.NewTask(
+		collect.WithName("pipeline"),
+		collect.WithStorage(s),
+		collect.WithPipeline(collect.Trim(), drop, pages, price, collect.Dedupe("书名")),
+	)
+	seed.Fetcher = pageFetcher{"https://book.douban.com": `<h2 data-pages="302页" data-price="45.00元"> 三体 </h2><h2>三体</h2><h2> </h2><h2 data-pages="191页">活着</h2>`}
+	crawler := engine.NewEngine(
+		engine.WithWorkCount(1),
+		engine.WithSeeds([]*collect.Task{seed}),
+		engine.WithScheduler(engine.NewSchedule()),
+	)
+	crawler.Run()
+
+	stats := crawler.Stats()
+	assert.Equal(t, int64(2), stats.Items)
+	assert.Equal(t, int64(2), stats.Dropped)
+	assert.Equal(t, int64(0), stats.Invalid)
+	require.Len(t, s.cells, 2)
+	assert.Equal(t, "三体", s.cells[0].GetData()["书名"])
+	assert.Equal(t, int64(302), s.cells[0].GetData()["页数"])
+	assert.Equal(t, 45.0, s.cells[0].GetData()["价格"])
+	assert.Equal(t, "活着", s.cells[1].GetData()["书名"])
+	assert.Equal(t, int64(191), s.cells[1].GetData()["页数"])
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/collect"
+	"time"
+)
+
+// scriptRuntime 动态规则的脚本运行时，将 TaskModule 中的脚本编译为规则
+type scriptRuntime interface {
+	// compileRoot 编译生成种子请求的脚本
+	compileRoot(name string, src string) (func() ([]*collect.Request, error), error)
+	// compileRule 编译解析规则的脚本
+	compileRule(name string, src string) (func(*collect.Context) (collect.ParseResult, error), error)
+}
+
+func newScriptRuntime(language string, timeout time.Duration) (scriptRuntime, error) {
+	switch language {
+	case "", collect.LanguageJS:
+		return newJSRuntime(timeout), nil
+	case collect.LanguageLua:
+		return newLuaRuntime(timeout), nil
+	}
+	return nil, fmt.Errorf("unknown script language %q", language)
+}
+
+// AddJsTask 初始化任务与规则，根据 Language 选择脚本运行时
+func (c *CrawlerStore) AddJsTask(m *collect.TaskModule) error {
+	if m.Name == "" {
+		return errors.New("js task name can not be empty")
+	}
+	task := collect.NewTask(
+		collect.WithName(m.Name),
+		collect.WithURL(m.Url),
+		collect.WithCookie(m.Cookie),
+	)
+	if m.WaitTime > 0 {
+		task.WaitTime = m.WaitTime
+	}
+	if m.MaxDepth > 0 {
+		task.MaxDepth = m.MaxDepth
+	}
+
+	rt, err := newScriptRuntime(m.Language, time.Duration(m.Timeout)*time.Millisecond)
+	if err != nil {
+		return fmt.Errorf("task %s: %w", m.Name, err)
+	}
+
+	task.Rule.Root, err = rt.compileRoot(m.Name+".root", m.Root)
+	if err != nil {
+		return fmt.Errorf("compile root script of %s failed:%w", m.Name, err)
+	}
+
+	task.Rule.Trunk = make(map[string]*collect.Rule, len(m.Rules))
+	for _, r := range m.Rules {
+		parse, err := rt.compileRule(m.Name+"."+r.Name, r.ParseFunc)
+		if err != nil {
+			return fmt.Errorf("compile rule %s of %s failed:%w", r.Name, m.Name, err)
+		}
+		task.Rule.Trunk[r.Name] = &collect.Rule{
+			ItemFields: r.ItemFields,
+			ParseFunc:  parse,
+			Key:        r.Key,
+			History:    r.History,
+		}
+	}
+
+	c.Add(task)
+	return nil
+}
@@ -0,0 +1,240 @@
+package engine
+
+import (
+	"fmt"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/storage"
+	"sort"
+)
+
+// Problem 校验任务时发现的问题
+type Problem struct {
+	Task string
+	Rule string // 与具体规则无关时为空
+	Msg  string
+}
+
+func (p Problem) String() string {
+	if p.Rule == "" {
+		return fmt.Sprintf("task %s: %s", p.Task, p.Msg)
+	}
+	return fmt.Sprintf("task %s, rule %s: %s", p.Task, p.Rule, p.Msg)
+}
+
+// ValidateSeeds 校验配置中的任务，任务需要已经注册到 Store 中。
+// samplePages 大于 0 时使用任务的 Fetcher 试运行，最多抓取 samplePages 个页面
+func (c *CrawlerStore) ValidateSeeds(seeds []*collect.Task, samplePages int) []Problem {
+	var problems []Problem
+	for _, seed := range seeds {
+		t, ok := c.Get(seed.Name)
+		if !ok {
+			problems = append(problems, Problem{Task: seed.Name, Msg: "task not found in store"})
+			continue
+		}
+		// 与 Crawler.Schedule 相同，配置中的任务使用注册的规则
+		task := *seed
+		task.Rule = t.Rule
+		problems = append(problems, Validate(&task, samplePages)...)
+	}
+	return problems
+}
+
+// Validate 校验任务的规则树：种子请求与解析生成的请求引用的规则必须存在，
+// 输出的数据字段必须在 ItemFields 中声明。
+// samplePages 为 0 时只执行 Root，否则从种子请求开始试运行，每条规则最多抓取一个页面
+func Validate(task *collect.Task, samplePages int) []Problem {
+	v := &validator{task: task, sampled: make(map[string]bool), unknown: make(map[string]bool)}
+	v.check()
+	if samplePages > 0 && task.Fetcher != nil {
+		v.sample(samplePages)
+	}
+	return v.problems
+}
+
+type validator struct {
+	task     *collect.Task
+	roots    []*collect.Request
+	sampled  map[string]bool // 已经试运行过的规则
+	unknown  map[string]bool // 已经报告过的未知规则，同一页面中的多个链接只报告一次
+	problems []Problem
+}
+
+func (v *validator) report(rule string, format string, args ...interface{}) {
+	v.problems = append(v.problems, Problem{Task: v.task.Name, Rule: rule, Msg: fmt.Sprintf(format, args...)})
+}
+
+// check 静态检查规则树，并执行 Root 检查种子请求
+func (v *validator) check() {
+	tree := v.task.Rule
+	if tree.Root == nil {
+		v.report("", "root is not set")
+		return
+	}
+	if len(tree.Trunk) == 0 {
+		v.report("", "no rules")
+	}
+	names := make([]string, 0, len(tree.Trunk))
+	for name := range tree.Trunk {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := tree.Trunk[name]
+		if r == nil || r.ParseFunc == nil {
+			v.report(name, "parse func is not set")
+		}
+		if r != nil {
+			v.checkKey(name, r)
+		}
+	}
+	v.checkTableKey(names)
+
+	roots, err := v.root()
+	if err != nil {
+		v.report("", "root failed: %v", err)
+		return
+	}
+	if len(roots) == 0 {
+		v.report("", "root returns no request")
+	}
+	for _, req := range roots {
+		req.Task = v.task
+		v.checkRequest("", req)
+	}
+	v.roots = roots
+}
+
+func (v *validator) root() (reqs []*collect.Request, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("panic: %v", e)
+		}
+	}()
+	return v.task.Rule.Root()
+}
+
+// checkRequest 检查请求引用的规则是否存在
+func (v *validator) checkRequest(from string, req *collect.Request) bool {
+	if r, ok := v.task.Rule.Trunk[req.RuleName]; !ok || r == nil {
+		if key := from + "->" + req.RuleName; !v.unknown[key] {
+			v.unknown[key] = true
+			v.report(from, "request %s refers to unknown rule %q", req.Url, req.RuleName)
+		}
+		return false
+	}
+	return true
+}
+
+// checkKey 检查自然键的字段是否在 ItemFields 中声明
+func (v *validator) checkKey(ruleName string, r *collect.Rule) {
+	if r.History && len(r.Key) == 0 {
+		v.report(ruleName, "history requires key")
+	}
+	fields := map[string]bool{"Url": true}
+	for _, f := range r.ItemFields {
+		fields[f] = true
+	}
+	for _, k := range r.Key {
+		if !fields[k] {
+			v.report(ruleName, "key field %q is not declared in ItemFields", k)
+		}
+	}
+}
+
+// checkTableKey 任务中所有规则的数据写入同一张表，声明了自然键的规则需要使用相同的键
+func (v *validator) checkTableKey(names []string) {
+	key := v.task.Rule.Key()
+	for _, name := range names {
+		r := v.task.Rule.Trunk[name]
+		if r == nil || len(r.Key) == 0 || equalKey(r.Key, key) {
+			continue
+		}
+		v.report(name, "key %v differs from key %v of the task table", r.Key, key)
+	}
+}
+
+func equalKey(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// checkItems 检查解析结果中的数据字段是否在 ItemFields 中声明
+func (v *validator) checkItems(ruleName string, items []interface{}) {
+	fields := make(map[string]bool)
+	for _, f := range v.task.Rule.Trunk[ruleName].ItemFields {
+		fields[f] = true
+	}
+	reported := make(map[string]bool)
+	for _, item := range items {
+		cell, ok := item.(*storage.DataCell)
+		if !ok {
+			continue
+		}
+		if len(fields) == 0 {
+			v.report(ruleName, "outputs data but ItemFields is empty")
+			return
+		}
+		data, ok := cell.Data[storage.KeyData].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for k := range data {
+			if !fields[k] && !reported[k] {
+				reported[k] = true
+				v.report(ruleName, "field %q is not declared in ItemFields", k)
+			}
+		}
+	}
+}
+
+// sample 从种子请求开始广度优先地试运行，只跟进尚未试运行过的规则
+func (v *validator) sample(maxPages int) {
+	queue := append([]*collect.Request(nil), v.roots...)
+	for pages := 0; len(queue) > 0 && pages < maxPages; {
+		req := queue[0]
+		queue = queue[1:]
+		rule, ok := v.task.Rule.Trunk[req.RuleName]
+		if !ok || rule == nil || rule.ParseFunc == nil || v.sampled[req.RuleName] {
+			continue
+		}
+		v.sampled[req.RuleName] = true
+		pages++
+
+		body, err := v.task.Fetcher.Get(req)
+		if err != nil {
+			v.report(req.RuleName, "fetch %s failed: %v", req.Url, err)
+			continue
+		}
+		result, err := ParseOnce(rule, &collect.Context{Body: body, Req: req})
+		if err != nil {
+			v.report(req.RuleName, "parse %s failed: %v", req.Url, err)
+			continue
+		}
+		v.checkItems(req.RuleName, result.Items)
+		for _, child := range result.Requests {
+			if child.Task == nil {
+				child.Task = v.task
+			}
+			if v.checkRequest(req.RuleName, child) {
+				queue = append(queue, child)
+			}
+		}
+	}
+}
+
+// ParseOnce 执行一次规则的解析函数，解析函数 panic 时返回错误
+func ParseOnce(rule *collect.Rule, ctx *collect.Context) (result collect.ParseResult, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("panic: %v", e)
+		}
+	}()
+	return rule.ParseFunc(ctx)
+}
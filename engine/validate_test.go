@@ -0,0 +1,98 @@
+package engine_test
+
+import (
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/engine"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// pageFetcher 按网址返回固定页面的 Fetcher
+type pageFetcher map[string]string
+
+func (f pageFetcher) Get(r *collect.Request) ([]byte, error) {
+	return []byte(f[r.Url]), nil
+}
+
+func TestValidate(t *testing.T) {
+	store := newStore()
+	book := collect.NewTask(collect.WithName("book"))
+	book.Rule = collect.RuleTree{
+		Root: func() ([]*collect.Request, error) {
+			return []*collect.Request{
+				{Url: "https://book.douban.com", RuleName: "数据tag"},
+				{Url: "https://book.douban.com/top", RuleName: "排行榜"},
+			}, nil
+		},
+		Trunk: map[string]*collect.Rule{
+			"数据tag": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+				return collect.ParseResult{Requests: ctx.FollowLinks("a.tag", "书籍列表")}, nil
+			}},
+			"书籍列表": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+				reqs := ctx.FollowLinks("h2 a", "书籍简介") // 规则名写错
+				return collect.ParseResult{Requests: reqs}, nil
+			}},
+			"书籍详情": {
+				ItemFields: []string{"书名"},
+				ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{}, nil
+				},
+			},
+			"标签": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+				return collect.ParseResult{Items: []interface{}{ctx.Output(map[string]interface{}{"标签": ctx.Text("a")})}}, nil
+			}},
+		},
+	}
+	store.Add(book)
+
+	// 静态检查只能发现种子请求中的问题
+	problems := store.ValidateSeeds([]*collect.Task{
+		collect.NewTask(collect.WithName("book")),
+		collect.NewTask(collect.WithName("movie")),
+	}, 0)
+	require.Len(t, problems, 2)
+	assert.Equal(t, "task book: request https://book.douban.com/top refers to unknown rule \"排行榜\"", problems[0].String())
+	assert.Equal(t, "task movie: task not found in store", problems[1].String())
+
+	// 试运行跟进链接
+	seed := collect.NewTask(collect.WithName("book"))
+	seed.Fetcher = pageFetcher{
+		"https://book.douban.com":                        `<a class="tag" href="/tag/小说">小说</a><a class="tag" href="/tag/历史">历史</a>`,
+		"https://book.douban.com/tag/%E5%B0%8F%E8%AF%B4": `<h2><a href="/subject/1/">三体</a></h2><h2><a href="/subject/2/">活着</a></h2>`,
+	}
+	problems = store.ValidateSeeds([]*collect.Task{seed}, 10)
+	require.Len(t, problems, 2)
+	assert.Equal(t, "书籍列表", problems[1].Rule)
+	assert.Contains(t, problems[1].Msg, `unknown rule "书籍简介"`)
+
+	// 输出的字段没有在 ItemFields 中声明
+	seed.Rule = book.Rule
+	seed.Rule.Root = func() ([]*collect.Request, error) {
+		return []*collect.Request{{Url: "https://book.douban.com", RuleName: "标签"}}, nil
+	}
+	problems = engine.Validate(seed, 1)
+	require.Len(t, problems, 1)
+	assert.Equal(t, "task book, rule 标签: outputs data but ItemFields is empty", problems[0].String())
+}
+
+func TestValidateTableKey(t *testing.T) {
+	parse := func(ctx *collect.Context) (collect.ParseResult, error) {
+		return collect.ParseResult{}, nil
+	}
+	task := collect.NewTask(collect.WithName("table_key"))
+	task.Rule = collect.RuleTree{
+		Root: func() ([]*collect.Request, error) {
+			return []*collect.Request{{Url: "https://book.douban.com", RuleName: "书籍简介"}}, nil
+		},
+		Trunk: map[string]*collect.Rule{
+			"书籍简介": {ItemFields: []string{"书名"}, Key: []string{"Url"}, ParseFunc: parse},
+			"书评":   {ItemFields: []string{"书名", "评分"}, ParseFunc: parse},
+			"作者":   {ItemFields: []string{"姓名"}, Key: []string{"姓名"}, ParseFunc: parse},
+		},
+	}
+	// 规则的数据写入同一张表，自然键需要一致，没有声明自然键的规则不受影响
+	problems := engine.Validate(task, 0)
+	require.Len(t, problems, 1)
+	assert.Equal(t, "task table_key, rule 作者: key [姓名] differs from key [Url] of the task table", problems[0].String())
+}
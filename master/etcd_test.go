@@ -0,0 +1,226 @@
+package master
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	pb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/mvccpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"sort"
+	"sync"
+)
+
+// fakeEtcd 内存中的 etcd，实现 Master 与 Worker 用到的 KV 与 Watch 接口
+type fakeEtcd struct {
+	mu       sync.Mutex
+	rev      int64
+	kvs      map[string]*mvccpb.KeyValue
+	watchers []*fakeWatch
+}
+
+func newFakeEtcd() *fakeEtcd {
+	return &fakeEtcd{kvs: make(map[string]*mvccpb.KeyValue)}
+}
+
+// client 返回使用 fakeEtcd 的客户端
+func (e *fakeEtcd) client() *clientv3.Client {
+	return &clientv3.Client{KV: e, Watcher: e}
+}
+
+func (e *fakeEtcd) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.put(key, val)
+	return &clientv3.PutResponse{Header: e.header()}, nil
+}
+
+// put 写入键值并通知监听者，调用时需持有 e.mu
+func (e *fakeEtcd) put(key, val string) {
+	e.rev++
+	prev := e.kvs[key]
+	kv := &mvccpb.KeyValue{Key: []byte(key), Value: []byte(val), ModRevision: e.rev, CreateRevision: e.rev, Version: 1}
+	if prev != nil {
+		kv.CreateRevision = prev.CreateRevision
+		kv.Version = prev.Version + 1
+	}
+	e.kvs[key] = kv
+	e.notify(&clientv3.Event{Type: clientv3.EventTypePut, Kv: kv, PrevKv: prev})
+}
+
+func (e *fakeEtcd) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	op := clientv3.OpGet(key, opts...)
+	var kvs []*mvccpb.KeyValue
+	for k, kv := range e.kvs {
+		if inRange(k, key, op.RangeBytes()) {
+			kvs = append(kvs, kv)
+		}
+	}
+	sort.Slice(kvs, func(i, j int) bool { return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0 })
+	return &clientv3.GetResponse{Header: e.header(), Kvs: kvs, Count: int64(len(kvs))}, nil
+}
+
+func (e *fakeEtcd) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	op := clientv3.OpDelete(key, opts...)
+	var deleted int64
+	for k, kv := range e.kvs {
+		if !inRange(k, key, op.RangeBytes()) {
+			continue
+		}
+		e.rev++
+		delete(e.kvs, k)
+		deleted++
+		e.notify(&clientv3.Event{Type: clientv3.EventTypeDelete, Kv: &mvccpb.KeyValue{Key: kv.Key, ModRevision: e.rev}, PrevKv: kv})
+	}
+	return &clientv3.DeleteResponse{Header: e.header(), Deleted: deleted}, nil
+}
+
+func (e *fakeEtcd) Compact(ctx context.Context, rev int64, opts ...clientv3.CompactOption) (*clientv3.CompactResponse, error) {
+	return &clientv3.CompactResponse{}, nil
+}
+
+func (e *fakeEtcd) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	return clientv3.OpResponse{}, errors.New("fake etcd: Do is not supported")
+}
+
+func (e *fakeEtcd) Txn(ctx context.Context) clientv3.Txn {
+	return &fakeTxn{etcd: e}
+}
+
+func (e *fakeEtcd) header() *pb.ResponseHeader {
+	return &pb.ResponseHeader{Revision: e.rev}
+}
+
+// inRange 键是否在 [key, end) 中，end 为空时只匹配 key
+func inRange(k string, key string, end []byte) bool {
+	if len(end) == 0 {
+		return k == key
+	}
+	return k >= key && k < string(end)
+}
+
+// fakeTxn 只支持比较 ModRevision 后写入
+type fakeTxn struct {
+	etcd  *fakeEtcd
+	cmps  []clientv3.Cmp
+	then  []clientv3.Op
+	elses []clientv3.Op
+}
+
+func (t *fakeTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	t.cmps = cs
+	return t
+}
+
+func (t *fakeTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	t.then = ops
+	return t
+}
+
+func (t *fakeTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	t.elses = ops
+	return t
+}
+
+func (t *fakeTxn) Commit() (*clientv3.TxnResponse, error) {
+	e := t.etcd
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ok := true
+	for _, c := range t.cmps {
+		target, isMod := c.TargetUnion.(*pb.Compare_ModRevision)
+		if c.Target != pb.Compare_MOD || !isMod || c.Result != pb.Compare_EQUAL {
+			return nil, errors.New("fake etcd: only ModRevision equal is supported")
+		}
+		var rev int64
+		if kv, exist := e.kvs[string(c.Key)]; exist {
+			rev = kv.ModRevision
+		}
+		ok = ok && rev == target.ModRevision
+	}
+	ops := t.then
+	if !ok {
+		ops = t.elses
+	}
+	for _, op := range ops {
+		if !op.IsPut() {
+			return nil, errors.New("fake etcd: only put is supported in txn")
+		}
+		e.put(string(op.KeyBytes()), string(op.ValueBytes()))
+	}
+	return &clientv3.TxnResponse{Header: e.header(), Succeeded: ok}, nil
+}
+
+// fakeWatch 一个监听者，事件按顺序在单独的协程中发送
+type fakeWatch struct {
+	key    string
+	end    []byte
+	ch     chan clientv3.WatchResponse
+	mu     sync.Mutex
+	queue  []*clientv3.Event
+	signal chan struct{}
+}
+
+func (e *fakeEtcd) Watch(ctx context.Context, key string, opts ...clientv3.OpOption) clientv3.WatchChan {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	w := &fakeWatch{
+		key:    key,
+		end:    clientv3.OpGet(key, opts...).RangeBytes(),
+		ch:     make(chan clientv3.WatchResponse),
+		signal: make(chan struct{}, 1),
+	}
+	e.watchers = append(e.watchers, w)
+	go w.run(ctx)
+	return w.ch
+}
+
+func (e *fakeEtcd) RequestProgress(ctx context.Context) error {
+	return nil
+}
+
+func (e *fakeEtcd) Close() error {
+	return nil
+}
+
+// notify 将事件加入匹配的监听者的队列，调用时需持有 e.mu
+func (e *fakeEtcd) notify(ev *clientv3.Event) {
+	for _, w := range e.watchers {
+		if !inRange(string(ev.Kv.Key), w.key, w.end) {
+			continue
+		}
+		w.mu.Lock()
+		w.queue = append(w.queue, ev)
+		w.mu.Unlock()
+		select {
+		case w.signal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *fakeWatch) run(ctx context.Context) {
+	defer close(w.ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.signal:
+		}
+		w.mu.Lock()
+		events := w.queue
+		w.queue = nil
+		w.mu.Unlock()
+		for _, ev := range events {
+			select {
+			case w.ch <- clientv3.WatchResponse{Events: []*clientv3.Event{ev}}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/Nrich-sunny/crawler/cmd/worker"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/engine"
 	proto "github.com/Nrich-sunny/crawler/proto/crawler"
+	"github.com/Nrich-sunny/crawler/taskmodule"
 	"github.com/bwmarrin/snowflake"
 	"github.com/golang/protobuf/ptypes/empty"
 	"go-micro.dev/v4/registry"
@@ -17,6 +19,7 @@ import (
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -38,8 +41,21 @@ type ResourceSpec struct {
 	Name         string // 资源名称（任务名称）
 	AssignedNode string // 资源分配到的 Worker 节点: "{NodeID}|{NodeAddress}"
 	CreationTime int64  // 资源创建时间
+	State        string // 任务的运行状态，由 Worker 上报: pending、running、paused、completed、failed
+	StateTime    int64  // 状态更新时间
+	Control      string // 客户端对任务的操作，由 Worker 执行: pause、resume、cancel
+	ControlRev   int64  // 操作的序号，每次操作加一，Worker 据此区分新的操作与重复的事件
 }
 
+// 客户端对任务的操作
+const (
+	ControlPause  = "pause"
+	ControlResume = "resume"
+	ControlCancel = "cancel"
+	// ControlStart 资源新建或重新分配到 Worker 时重新运行任务，由 Worker 监听资源时生成，不写入 etcd
+	ControlStart = "start"
+)
+
 // WorkerNodeSpec 描述 Worker 节点的状态
 type WorkerNodeSpec struct {
 	Node    *registry.Node // Worker 节点的信息
@@ -58,6 +74,7 @@ type Master struct {
 	ID        string                     // Master 的 ID
 	ready     int32                      // 用于标记当前 Master 是否为 Leader
 	leaderID  string                     // 记录当前集群中的 Leader
+	mu        sync.Mutex                 // 保护 workNodes 与 resources，选主循环、客户端请求与定时任务会并发访问
 	workNodes map[string]*WorkerNodeSpec // 记录当前集群中所有的 Worker 节点的信息
 	resources map[string]*ResourceSpec   // 记录当前集群中所有的资源(爬虫任务可以视为一种资源)
 	IDGen     *snowflake.Node            // 生成全局唯一 ID
@@ -106,6 +123,11 @@ func New(id string, opts ...Option) (*Master, error) {
 	// 将 Seeds 中的任务写入 etcd
 	m.AddSeed()
 
+	// 定时重新爬取的任务
+	if err := m.scheduleSeeds(); err != nil {
+		return nil, err
+	}
+
 	// 启动 Master 的选主逻辑
 	go m.Campaign()
 
@@ -147,12 +169,14 @@ func (m *Master) AddSeed() {
 }
 
 func (m *Master) AddResources(rs []*ResourceSpec) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	for _, r := range rs {
 		m.addResource(r)
 	}
 }
 
-// AddResource 将资源写入 etcd，同时为其分配 Worker 节点
+// addResource 将资源写入 etcd，同时为其分配 Worker 节点，调用时需持有 m.mu
 func (m *Master) addResource(r *ResourceSpec) (*WorkerNodeSpec, error) {
 	// 资源各个字段填充
 	r.ID = m.IDGen.Generate().String()
@@ -189,6 +213,8 @@ func (m *Master) AddResource(ctx context.Context, req *proto.ResourceSpec, resp
 	r := &ResourceSpec{
 		Name: req.Name,
 	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	nodeSpec, err := m.addResource(r)
 	if err != nil {
 		return err
@@ -202,6 +228,8 @@ func (m *Master) AddResource(ctx context.Context, req *proto.ResourceSpec, resp
 
 // DeleteResource 允许客户端删除资源
 func (m *Master) DeleteResource(ctx context.Context, req *proto.ResourceSpec, empty *empty.Empty) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	r, ok := m.resources[req.Name]
 	if ok {
 		// 删除 etcd 中的资源
@@ -224,8 +252,82 @@ func (m *Master) DeleteResource(ctx context.Context, req *proto.ResourceSpec, em
 	return nil
 }
 
+// PauseTask 暂停任务，Worker 搁置任务的请求
+func (m *Master) PauseTask(ctx context.Context, req *proto.ResourceSpec, empty *empty.Empty) error {
+	return m.controlTask(ctx, req.Name, ControlPause)
+}
+
+// ResumeTask 恢复暂停的任务
+func (m *Master) ResumeTask(ctx context.Context, req *proto.ResourceSpec, empty *empty.Empty) error {
+	return m.controlTask(ctx, req.Name, ControlResume)
+}
+
+// CancelTask 取消任务，Worker 丢弃任务等待中的请求
+func (m *Master) CancelTask(ctx context.Context, req *proto.ResourceSpec, empty *empty.Empty) error {
+	return m.controlTask(ctx, req.Name, ControlCancel)
+}
+
+// controlTask 将操作写入 etcd 中的资源，由分配到的 Worker 监听执行
+func (m *Master) controlTask(ctx context.Context, name string, control string) error {
+	ok, err := updateResource(ctx, m.etcdCli, name, func(r *ResourceSpec) {
+		r.Control = control
+		r.ControlRev++
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("resource %s not found", name)
+	}
+	m.logger.Info("control task", zap.String("name", name), zap.String("control", control))
+	return nil
+}
+
+// AddTaskModule 允许客户端上传 JS 任务模块，校验通过后写入 etcd，由 Worker 监听加载
+func (m *Master) AddTaskModule(ctx context.Context, req *proto.TaskModule, empty *empty.Empty) error {
+	module := &collect.TaskModule{
+		Property: collect.Property{
+			Name:     req.Name,
+			Url:      req.Url,
+			Cookie:   req.Cookie,
+			WaitTime: req.WaitTime,
+			MaxDepth: int(req.MaxDepth),
+		},
+		Language: req.Language,
+		Timeout:  req.Timeout,
+		Root:     req.RootScript,
+	}
+	for _, r := range req.Rule {
+		module.Rules = append(module.Rules, collect.RuleModule{
+			Name:       r.Name,
+			ItemFields: r.ItemFields,
+			ParseFunc:  r.ParseScript,
+			Key:        r.Key,
+			History:    r.History,
+		})
+	}
+
+	// 在临时的任务注册表中编译一次，提前发现脚本错误
+	store := &engine.CrawlerStore{Hash: map[string]*collect.Task{}}
+	if err := store.AddJsTask(module); err != nil {
+		return fmt.Errorf("invalid task module:%w", err)
+	}
+
+	b, err := json.Marshal(module)
+	if err != nil {
+		return err
+	}
+	if _, err := m.etcdCli.Put(ctx, taskmodule.EtcdKey(module.Name), string(b)); err != nil {
+		m.logger.Error("put task module failed", zap.Error(err))
+		return err
+	}
+	m.logger.Info("add task module", zap.String("name", module.Name))
+	return nil
+}
+
 // Assign 为资源分配 Worker 节点, 计算当前的资源应该被分配到哪个节点
 // 最小负载法
+// Assign 选择负载最小的 Worker 节点，调用时需持有 m.mu
 func (m *Master) Assign(r *ResourceSpec) (*WorkerNodeSpec, error) {
 	// 遍历所有节点，找到合适的 Worker 节点
 	candidates := make([]*WorkerNodeSpec, 0, len(m.workNodes))
@@ -245,6 +347,7 @@ func (m *Master) Assign(r *ResourceSpec) (*WorkerNodeSpec, error) {
 	return nil, errors.New("no worker nodes")
 }
 
+// reAssign 重新分配没有节点或节点已下线的资源，调用时需持有 m.mu
 func (m *Master) reAssign() {
 	// 需要重新分配 worker 节点的资源
 	rs := make([]*ResourceSpec, 0, len(m.resources))
@@ -266,7 +369,37 @@ func (m *Master) reAssign() {
 		}
 	}
 	// 重新分配资源
-	m.AddResources(rs)
+	for _, r := range rs {
+		m.reAssignResource(r)
+	}
+}
+
+// reAssignResource 为资源分配新的 Worker 节点，只修改 etcd 中资源的 AssignedNode，
+// 保留 Worker 上报的状态与客户端的操作
+func (m *Master) reAssignResource(r *ResourceSpec) {
+	nodeAssigned, err := m.Assign(r)
+	if err != nil || nodeAssigned.Node == nil {
+		m.logger.Error("assign worker failed", zap.String("name", r.Name), zap.Error(err))
+		return
+	}
+	assigned := nodeAssigned.Node.Id + "|" + nodeAssigned.Node.Address
+	var updated *ResourceSpec
+	ok, err := updateResource(context.Background(), m.etcdCli, r.Name, func(cur *ResourceSpec) {
+		cur.AssignedNode = assigned
+		updated = cur
+	})
+	if err != nil {
+		m.logger.Error("reassign resource failed", zap.String("name", r.Name), zap.Error(err))
+		return
+	}
+	if !ok {
+		// 资源已从 etcd 中删除
+		delete(m.resources, r.Name)
+		return
+	}
+	m.logger.Debug("reassign resource", zap.String("name", r.Name), zap.String("node", assigned))
+	m.resources[r.Name] = updated
+	nodeAssigned.Payload++
 }
 
 func (m *Master) IsLeader() bool {
@@ -297,6 +430,7 @@ func (m *Master) Campaign() {
 	}
 
 	workerNodeChange := m.WatchWorker()
+	resourceChange := m.WatchResource()
 
 	for {
 		select {
@@ -324,6 +458,7 @@ func (m *Master) Campaign() {
 		// workerNodeChange 负责监听当前集群中 Worker 节点的变化
 		case resp := <-workerNodeChange:
 			m.logger.Info("watch worker change", zap.Any("worker:", resp))
+			m.mu.Lock()
 			// 全量更新 Worker 节点信息
 			m.updateWorkNodes()
 			// 全量更新资源的状态
@@ -332,6 +467,11 @@ func (m *Master) Campaign() {
 			}
 			// 重新分配资源
 			m.reAssign()
+			m.mu.Unlock()
+
+		// resourceChange 负责监听 Worker 上报的任务状态
+		case r := <-resourceChange:
+			m.updateResourceState(r)
 
 		case <-time.After(20 * time.Second):
 			resp, err := election.Leader(context.Background())
@@ -362,7 +502,7 @@ func (m *Master) elect(election *concurrency.Election, ch chan error) {
 // WatchWorker 监听 Worker 节点的信息，感知到 Worker 节点的注册与销毁
 func (m *Master) WatchWorker() chan *registry.Result {
 	// 监听 Worker 节点的变化
-	watcher, err := m.registry.Watch(registry.WatchService(worker.ServiceName))
+	watcher, err := m.registry.Watch(registry.WatchService(m.workerServiceName))
 	if err != nil {
 		panic(err)
 	}
@@ -382,8 +522,57 @@ func (m *Master) WatchWorker() chan *registry.Result {
 	return ch
 }
 
+// WatchResource 监听 etcd 中资源的变化，将更新后的资源发送到通道
+func (m *Master) WatchResource() chan *ResourceSpec {
+	ch := make(chan *ResourceSpec)
+	go func() {
+		watchCh := m.etcdCli.Watch(context.Background(), RESOURCEPATH, clientv3.WithPrefix())
+		for w := range watchCh {
+			if w.Err() != nil {
+				m.logger.Error("watch resource failed", zap.Error(w.Err()))
+				continue
+			}
+			for _, ev := range w.Events {
+				if ev.Type != clientv3.EventTypePut {
+					continue
+				}
+				r, err := decode(ev.Kv.Value)
+				if err != nil || r == nil {
+					m.logger.Error("decode resource failed", zap.Error(err))
+					continue
+				}
+				ch <- r
+			}
+		}
+	}()
+	return ch
+}
+
+// updateResourceState 更新内存中资源的运行状态与操作
+func (m *Master) updateResourceState(r *ResourceSpec) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.resources[r.Name]
+	if !ok {
+		return
+	}
+	old.Control = r.Control
+	old.ControlRev = r.ControlRev
+	if old.State == r.State {
+		return
+	}
+	m.logger.Info("resource state changed",
+		zap.String("name", r.Name),
+		zap.String("state", r.State),
+	)
+	old.State = r.State
+	old.StateTime = r.StateTime
+}
+
 func (m *Master) BecomeLeader() error {
 	// 当 Master 成为新的 Leader 后，全量更新当前 Worker 的节点状态 和 资源的状态
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	// 全量加载当前的 Worker 节点
 	m.updateWorkNodes()
 	// 全量获取 etcd 中当前最新的资源信息
@@ -399,7 +588,7 @@ func (m *Master) BecomeLeader() error {
 	return nil
 }
 
-// loadResource 全量地获取一次 etcd 中当前最新的资源信息, 并把它保存到内存中
+// loadResource 全量地获取一次 etcd 中当前最新的资源信息, 并把它保存到内存中，调用时需持有 m.mu
 func (m *Master) loadResource() error {
 	resp, err := m.etcdCli.Get(context.Background(), RESOURCEPATH, clientv3.WithPrefix(), clientv3.WithSerializable())
 	if err != nil {
@@ -433,9 +622,9 @@ func (m *Master) loadResource() error {
 	return nil
 }
 
-// updateWorkNodes 更新当前集群中的 Worker 节点信息
+// updateWorkNodes 更新当前集群中的 Worker 节点信息，调用时需持有 m.mu
 func (m *Master) updateWorkNodes() {
-	services, err := m.registry.GetService(worker.ServiceName)
+	services, err := m.registry.GetService(m.workerServiceName)
 	if err != nil {
 		m.logger.Error("get service ", zap.Error(err))
 	}
@@ -506,6 +695,121 @@ func getResourcePath(name string) string {
 	return fmt.Sprintf("%s/%s", RESOURCEPATH, name)
 }
 
+// UpdateResourceState 由 Worker 调用，将任务的运行状态写回 etcd 中的资源。
+// 资源不存在时（例如 Worker 单独运行）不做修改
+func UpdateResourceState(ctx context.Context, cli *clientv3.Client, name string, state string) error {
+	_, err := updateResource(ctx, cli, name, func(r *ResourceSpec) {
+		r.State = state
+		r.StateTime = time.Now().UnixNano()
+	})
+	return err
+}
+
+// ReportTaskState 由 Worker 调用，将爬虫中任务的运行状态上报到 Master 的资源中。
+// 爬虫在单独的协程中按顺序调用监听者，写入 etcd 不会阻塞任务
+func ReportTaskState(logger *zap.Logger, cli *clientv3.Client) engine.TaskListener {
+	return func(status engine.TaskStatus) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := UpdateResourceState(ctx, cli, status.Name, string(status.State)); err != nil {
+			logger.Error("report task state failed",
+				zap.String("task", status.Name),
+				zap.String("state", string(status.State)),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// ControlTask 由 Worker 调用，暂停、恢复或取消爬虫中的任务
+func ControlTask(logger *zap.Logger, crawler *engine.Crawler) func(name string, control string) {
+	return func(name string, control string) {
+		var err error
+		switch control {
+		case ControlPause:
+			err = crawler.Pause(name)
+		case ControlResume:
+			err = crawler.Resume(name)
+		case ControlCancel:
+			err = crawler.Cancel(name)
+		case ControlStart:
+			err = crawler.Start(name)
+		default:
+			err = fmt.Errorf("unknown control %s", control)
+		}
+		if err != nil {
+			logger.Error("control task failed",
+				zap.String("task", name),
+				zap.String("control", control),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// updateResource 修改 etcd 中的资源，返回资源是否存在
+func updateResource(ctx context.Context, cli *clientv3.Client, name string, update func(r *ResourceSpec)) (bool, error) {
+	key := getResourcePath(name)
+	for {
+		resp, err := cli.Get(ctx, key)
+		if err != nil {
+			return false, fmt.Errorf("etcd get failed:%w", err)
+		}
+		if len(resp.Kvs) == 0 {
+			return false, nil
+		}
+		kv := resp.Kvs[0]
+		r, err := decode(kv.Value)
+		if err != nil || r == nil {
+			return true, fmt.Errorf("decode resource failed:%w", err)
+		}
+		update(r)
+		// 资源在读取后被修改（例如重新分配）时重试，避免覆盖其他字段
+		txn, err := cli.Txn(ctx).
+			If(clientv3.Compare(clientv3.ModRevision(key), "=", kv.ModRevision)).
+			Then(clientv3.OpPut(key, encode(r))).
+			Commit()
+		if err != nil {
+			return true, fmt.Errorf("etcd txn failed:%w", err)
+		}
+		if txn.Succeeded {
+			return true, nil
+		}
+	}
+}
+
+// WatchTaskControl 由 Worker 调用，监听分配到节点 nodeID 的资源上的操作。
+// 资源新建（例如定时任务重新创建资源）或重新分配到该节点时，操作为 ControlStart
+func WatchTaskControl(ctx context.Context, cli *clientv3.Client, nodeID string, handle func(name string, control string)) {
+	for w := range cli.Watch(ctx, RESOURCEPATH, clientv3.WithPrefix(), clientv3.WithPrevKV()) {
+		for _, ev := range w.Events {
+			if ev.Type != clientv3.EventTypePut {
+				continue
+			}
+			r, err := decode(ev.Kv.Value)
+			if err != nil || r == nil {
+				continue
+			}
+			if id, err := getNodeID(r.AssignedNode); err != nil || id != nodeID {
+				continue
+			}
+			var prev *ResourceSpec
+			if ev.PrevKv != nil {
+				prev, _ = decode(ev.PrevKv.Value)
+			}
+			if prev == nil || prev.AssignedNode != r.AssignedNode {
+				handle(r.Name, ControlStart)
+				continue
+			}
+			// 状态上报同样会触发事件，只执行序号变化后的操作
+			if r.Control == "" || prev.ControlRev == r.ControlRev {
+				continue
+			}
+			handle(r.Name, r.Control)
+		}
+	}
+}
+
 func encode(s *ResourceSpec) string {
 	b, _ := json.Marshal(s)
 	return string(b)
@@ -0,0 +1,221 @@
+package master
+
+import (
+	"context"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/engine"
+	proto "github.com/Nrich-sunny/crawler/proto/crawler"
+	"github.com/bwmarrin/snowflake"
+	"github.com/golang/protobuf/ptypes/empty"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go-micro.dev/v4/registry"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pageFetcher 所有页面返回空内容，并记录抓取的次数
+type pageFetcher struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (f *pageFetcher) Get(r *collect.Request) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.count++
+	return []byte(""), nil
+}
+
+func (f *pageFetcher) fetched() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.count
+}
+
+// gateFetcher 页面的抓取在 gate 关闭前阻塞
+type gateFetcher struct {
+	gate chan struct{}
+}
+
+func (f gateFetcher) Get(r *collect.Request) ([]byte, error) {
+	<-f.gate
+	return []byte(""), nil
+}
+
+// newTestMaster 使用 cli 的 Master，资源 name 分配到节点 worker1，并在协程中处理资源的变化
+func newTestMaster(t *testing.T, cli *clientv3.Client, name string) *Master {
+	m := &Master{
+		resources: make(map[string]*ResourceSpec),
+		workNodes: make(map[string]*WorkerNodeSpec),
+		etcdCli:   cli,
+		options:   defaultOptions,
+	}
+	r := &ResourceSpec{Name: name, AssignedNode: "worker1|127.0.0.1:9090"}
+	_, err := cli.Put(context.Background(), getResourcePath(name), encode(r))
+	require.NoError(t, err)
+	m.resources[name] = r
+	resourceChange := m.WatchResource()
+	go func() {
+		for r := range resourceChange {
+			m.updateResourceState(r)
+		}
+	}()
+	return m
+}
+
+// resource 返回 Master 内存中资源的副本
+func (m *Master) resource(name string) ResourceSpec {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.resources[name]; ok {
+		return *r
+	}
+	return ResourceSpec{}
+}
+
+// newTestCrawler 运行一个只有种子请求的任务，任务状态上报到 cli
+func newTestCrawler(cli *clientv3.Client, name string, fetcher collect.Fetcher, opts ...engine.Option) *engine.Crawler {
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{
+			Root: func() ([]*collect.Request, error) {
+				return []*collect.Request{{Url: "https://example.com/" + name, Method: "GET", RuleName: "首页"}}, nil
+			},
+			Trunk: map[string]*collect.Rule{
+				"首页": {ParseFunc: func(ctx *collect.Context) (collect.ParseResult, error) {
+					return collect.ParseResult{}, nil
+				}},
+			},
+		},
+		Options: collect.Options{Name: name},
+	})
+	seed := collect.NewTask(collect.WithName(name), collect.WithFetcher(fetcher))
+	return engine.NewEngine(append([]engine.Option{
+		engine.WithWorkCount(1),
+		engine.WithSeeds([]*collect.Task{seed}),
+		engine.WithScheduler(engine.NewSchedule()),
+		engine.WithTaskListener(ReportTaskState(zap.NewNop(), cli)),
+	}, opts...)...)
+}
+
+func TestReportTaskState(t *testing.T) {
+	cli := newFakeEtcd().client()
+	m := newTestMaster(t, cli, "report_state")
+	crawler := newTestCrawler(cli, "report_state", &pageFetcher{})
+	crawler.Run()
+
+	// Run 返回前状态已写入 etcd
+	resp, err := cli.Get(context.Background(), getResourcePath("report_state"))
+	require.NoError(t, err)
+	require.Len(t, resp.Kvs, 1)
+	r, err := decode(resp.Kvs[0].Value)
+	require.NoError(t, err)
+	assert.Equal(t, string(engine.TaskCompleted), r.State)
+	assert.Equal(t, "worker1|127.0.0.1:9090", r.AssignedNode)
+
+	// Master 通过监听资源得到 Worker 上报的状态
+	assert.Eventually(t, func() bool {
+		return m.resource("report_state").State == string(engine.TaskCompleted)
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTaskControl(t *testing.T) {
+	cli := newFakeEtcd().client()
+	m := newTestMaster(t, cli, "task_control")
+	gate := make(chan struct{})
+	crawler := newTestCrawler(cli, "task_control", gateFetcher{gate: gate})
+	go WatchTaskControl(context.Background(), cli, "worker1", ControlTask(zap.NewNop(), crawler))
+	finished := make(chan struct{})
+	go func() {
+		crawler.Run()
+		close(finished)
+	}()
+
+	// Master 看到的状态
+	waitState := func(state engine.TaskState) {
+		require.Eventually(t, func() bool {
+			return m.resource("task_control").State == string(state)
+		}, 5*time.Second, 10*time.Millisecond, "want %s", state)
+	}
+	waitState(engine.TaskRunning)
+	req := &proto.ResourceSpec{Name: "task_control"}
+
+	require.NoError(t, m.PauseTask(context.Background(), req, &empty.Empty{}))
+	waitState(engine.TaskPaused)
+	status, _ := crawler.TaskStatus("task_control")
+	assert.Equal(t, engine.TaskPaused, status.State)
+
+	// 任务在 Worker 中恢复后，与上一次相同的暂停操作同样会被执行
+	require.NoError(t, crawler.Resume("task_control"))
+	waitState(engine.TaskRunning)
+	require.NoError(t, m.PauseTask(context.Background(), req, &empty.Empty{}))
+	waitState(engine.TaskPaused)
+
+	require.NoError(t, m.ResumeTask(context.Background(), req, &empty.Empty{}))
+	waitState(engine.TaskRunning)
+	assert.Equal(t, ControlResume, m.resource("task_control").Control)
+	assert.Equal(t, int64(3), m.resource("task_control").ControlRev)
+
+	close(gate)
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("crawler did not finish")
+	}
+	waitState(engine.TaskCompleted)
+	assert.Error(t, m.PauseTask(context.Background(), &proto.ResourceSpec{Name: "not_found"}, &empty.Empty{}))
+}
+
+func TestReAssign(t *testing.T) {
+	cli := newFakeEtcd().client()
+	m := newTestMaster(t, cli, "reassign")
+	m.workNodes["worker2"] = &WorkerNodeSpec{Node: &registry.Node{Id: "worker2", Address: "127.0.0.1:9091"}}
+
+	// Worker 上报的状态与客户端的操作只写入了 etcd
+	_, err := updateResource(context.Background(), cli, "reassign", func(r *ResourceSpec) {
+		r.State = string(engine.TaskPaused)
+		r.Control = ControlPause
+		r.ControlRev = 1
+	})
+	require.NoError(t, err)
+
+	m.reAssign()
+	resp, err := cli.Get(context.Background(), getResourcePath("reassign"))
+	require.NoError(t, err)
+	r, err := decode(resp.Kvs[0].Value)
+	require.NoError(t, err)
+	assert.Equal(t, "worker2|127.0.0.1:9091", r.AssignedNode)
+	assert.Equal(t, string(engine.TaskPaused), r.State)
+	assert.Equal(t, ControlPause, r.Control)
+	assert.Equal(t, int64(1), r.ControlRev)
+	assert.Equal(t, 1, m.workNodes["worker2"].Payload)
+}
+
+func TestRecreateResource(t *testing.T) {
+	cli := newFakeEtcd().client()
+	m := newTestMaster(t, cli, "recreate")
+	node, err := snowflake.NewNode(1)
+	require.NoError(t, err)
+	m.IDGen = node
+	m.workNodes["worker1"] = &WorkerNodeSpec{Node: &registry.Node{Id: "worker1", Address: "127.0.0.1:9090"}}
+
+	fetcher := &pageFetcher{}
+	crawler := newTestCrawler(cli, "recreate", fetcher, engine.WithDaemon(true))
+	go WatchTaskControl(context.Background(), cli, "worker1", ControlTask(zap.NewNop(), crawler))
+	go crawler.Run()
+	require.Eventually(t, func() bool {
+		return m.resource("recreate").State == string(engine.TaskCompleted)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, fetcher.fetched())
+
+	// 定时任务重新创建资源后，Worker 重新运行任务
+	oldID := m.resource("recreate").ID
+	require.NoError(t, m.recreateResource("recreate"))
+	require.Eventually(t, func() bool {
+		return fetcher.fetched() == 2 && m.resource("recreate").State == string(engine.TaskCompleted)
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.NotEqual(t, oldID, m.resource("recreate").ID)
+}
@@ -12,10 +12,13 @@ type options struct {
 	GRPCAddress string
 	registry    registry.Registry // 注册中心
 	Seeds       []*collect.Task
+	// workerServiceName Worker 在注册中心的服务名
+	workerServiceName string
 }
 
 var defaultOptions = options{
-	logger: zap.NewNop(),
+	logger:            zap.NewNop(),
+	workerServiceName: "go.micro.server.worker",
 }
 
 type Option func(opts *options)
@@ -49,3 +52,9 @@ func WithSeeds(seed []*collect.Task) Option {
 		opts.Seeds = seed
 	}
 }
+
+func WithWorkerServiceName(name string) Option {
+	return func(opts *options) {
+		opts.workerServiceName = name
+	}
+}
@@ -0,0 +1,56 @@
+package master
+
+import (
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+)
+
+// scheduleSeeds 为配置了 Schedule 的任务启动定时器，到期时由 Leader 重新创建资源
+func (m *Master) scheduleSeeds() error {
+	c := cron.New()
+	for _, seed := range m.Seeds {
+		if seed.Schedule == "" {
+			continue
+		}
+		s, err := collect.ParseSchedule(seed.Schedule)
+		if err != nil {
+			return err
+		}
+		name := seed.Name
+		c.Schedule(s, cron.FuncJob(func() {
+			if !m.IsLeader() {
+				return
+			}
+			if err := m.recreateResource(name); err != nil {
+				m.logger.Error("recreate resource failed", zap.String("name", name), zap.Error(err))
+			}
+		}))
+		m.logger.Info("schedule task", zap.String("name", name), zap.String("schedule", seed.Schedule))
+	}
+	c.Start()
+	return nil
+}
+
+// recreateResource 删除资源后重新创建，资源会被重新分配到 Worker 节点并再次爬取
+func (m *Master) recreateResource(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if r, ok := m.resources[name]; ok {
+		if _, err := m.etcdCli.Delete(context.Background(), getResourcePath(name)); err != nil {
+			return err
+		}
+		if id, err := getNodeID(r.AssignedNode); err == nil {
+			if node, ok := m.workNodes[id]; ok {
+				node.Payload--
+			}
+		}
+		delete(m.resources, name)
+	}
+	_, err := m.addResource(&ResourceSpec{Name: name})
+	if err == nil {
+		m.logger.Info("recreate resource", zap.String("name", name))
+	}
+	return err
+}
@@ -27,36 +27,18 @@ var DoubanBookTask = &collect.Task{
 			"数据tag": {ParseFunc: ParseTag},
 			"书籍列表":  {ParseFunc: ParseBookList},
 			"书籍简介": {
-				ItemFields: []string{
-					"书名",
-					"作者",
-					"页数",
-					"出版社",
-					"得分",
-					"价格",
-					"简介",
-				},
+				Schema: collect.MustSchemaOf(Book{}),
+				// 同一本书重复抓取时更新原有的数据
+				Key:       []string{"Url"},
 				ParseFunc: ParseBookDetail,
 			},
 		},
 	},
 }
 
-const TagRe = `<a href="([^"]+)" class="tag">([^<]+)</a>`
-
 func ParseTag(ctx *collect.Context) (collect.ParseResult, error) {
-	re := regexp.MustCompile(TagRe)
-	matches := re.FindAllSubmatch(ctx.Body, -1)
-	result := collect.ParseResult{}
-
-	for _, m := range matches {
-		result.Requests = append(result.Requests, &collect.Request{
-			Method:   "GET",
-			Task:     ctx.Req.Task,
-			Url:      "https://book.douban.com" + string(m[1]),
-			Depth:    ctx.Req.Depth + 1,
-			RuleName: "书籍列表",
-		})
+	result := collect.ParseResult{
+		Requests: ctx.FollowLinks("a.tag", "书籍列表"),
 	}
 	zap.S().Debugln("parse book tag,count:", len(result.Requests))
 	return result, nil
@@ -94,20 +76,34 @@ var priceRe = regexp.MustCompile(`<span class="pl">定价:</span>([^<]+)<br/>`)
 var scoreRe = regexp.MustCompile(`<strong class="ll rating_num " property="v:average">([^<]+)</strong>`)
 var introRe = regexp.MustCompile(`<div class="intro">[\d\D]*?<p>([^<]+)</p></div>`)
 
+// Book 书籍简介规则输出的数据
+type Book struct {
+	Name      string `item:"书名,required"`
+	Author    string `item:"作者"`
+	Pages     int    `item:"页数"`
+	Publisher string `item:"出版社"`
+	Score     string `item:"得分"`
+	Price     string `item:"价格"`
+	Intro     string `item:"简介"`
+}
+
 func ParseBookDetail(ctx *collect.Context) (collect.ParseResult, error) {
-	bookName := ctx.Req.TempData.Get("book_name")
+	bookName, _ := ctx.Req.TempData.Get("book_name").(string)
 	page, _ := strconv.Atoi(ExtractStr(ctx.Body, pageRe))
 
-	book := map[string]interface{}{
-		"书名":  bookName,
-		"作者":  ExtractStr(ctx.Body, authorRe),
-		"页数":  page,
-		"出版社": ExtractStr(ctx.Body, publicRe),
-		"得分":  ExtractStr(ctx.Body, scoreRe),
-		"价格":  ExtractStr(ctx.Body, priceRe),
-		"简介":  ExtractStr(ctx.Body, introRe),
+	book := Book{
+		Name:      bookName,
+		Author:    ExtractStr(ctx.Body, authorRe),
+		Pages:     page,
+		Publisher: ExtractStr(ctx.Body, publicRe),
+		Score:     ExtractStr(ctx.Body, scoreRe),
+		Price:     ExtractStr(ctx.Body, priceRe),
+		Intro:     ExtractStr(ctx.Body, introRe),
+	}
+	data, err := ctx.OutputItem(book)
+	if err != nil {
+		return collect.ParseResult{}, err
 	}
-	data := ctx.Output(book)
 	result := collect.ParseResult{
 		Items: []interface{}{data},
 	}
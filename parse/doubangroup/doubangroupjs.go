@@ -22,20 +22,19 @@ var DoubangroupJSTask = &collect.TaskModule{
 		   };
 			arr.push(obj);
 		};
-		console.log(arr[0].Url);
 		AddJsReq(arr);
 		`,
 	Rules: []collect.RuleModule{
 		{
 			Name: "解析网站URL",
 			ParseFunc: `
-				ctx.ParseJSReg("解析阳台房","(https://www.douban.com/group/topic/[0-9a-z]+/)"[^>]*>([^<]+)</a>");
+				ctx.ParseJSReg("解析阳台房", '(https://www.douban.com/group/topic/[0-9a-z]+/)"[^>]*>([^<]+)</a>');
 			`,
 		},
 		{
 			Name: "解析阳台房",
 			ParseFunc: `
-				ctx.OutputJS("<div class="topic-content">[\s\S]*?阳台[\s\S]*?<div");
+				ctx.OutputJS('<div class="topic-content">[\\s\\S]*?阳台[\\s\\S]*?<div');
 			`,
 		},
 	},
@@ -4,6 +4,7 @@ import (
 	"github.com/Nrich-sunny/crawler/collect"
 	"log"
 	"regexp"
+	"strconv"
 )
 
 const urlListRe = `(https://www.douban.com/group/topic/[0-9a-z]+/)"[^>]*>([^<]+)</a>`
@@ -16,7 +17,7 @@ var DoubangroupTask = &collect.Task{
 		Root: func() ([]*collect.Request, error) {
 			var roots []*collect.Request
 			for i := 0; i < 25; i += 25 {
-				str := "https://www.douban.com/group/szsh/discussion?start=%d" + string(i)
+				str := "https://www.douban.com/group/szsh/discussion?start=" + strconv.Itoa(i)
 				roots = append(roots, &collect.Request{
 					Priority: 1,
 					Url:      str,
@@ -148,6 +148,196 @@ func (x *WorkerNodeSpec) GetAddress() string {
 	return ""
 }
 
+type TaskModule struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name       string        `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Url        string        `protobuf:"bytes,2,opt,name=url,proto3" json:"url,omitempty"`
+	Cookie     string        `protobuf:"bytes,3,opt,name=cookie,proto3" json:"cookie,omitempty"`
+	WaitTime   int64         `protobuf:"varint,4,opt,name=wait_time,json=waitTime,proto3" json:"wait_time,omitempty"`
+	MaxDepth   int32         `protobuf:"varint,5,opt,name=max_depth,json=maxDepth,proto3" json:"max_depth,omitempty"`
+	Timeout    int64         `protobuf:"varint,6,opt,name=timeout,proto3" json:"timeout,omitempty"`
+	RootScript string        `protobuf:"bytes,7,opt,name=root_script,json=rootScript,proto3" json:"root_script,omitempty"`
+	Rule       []*RuleModule `protobuf:"bytes,8,rep,name=rule,proto3" json:"rule,omitempty"`
+	Language   string        `protobuf:"bytes,9,opt,name=language,proto3" json:"language,omitempty"`
+}
+
+func (x *TaskModule) Reset() {
+	*x = TaskModule{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_crawler_crawler_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TaskModule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TaskModule) ProtoMessage() {}
+
+func (x *TaskModule) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_crawler_crawler_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TaskModule.ProtoReflect.Descriptor instead.
+func (*TaskModule) Descriptor() ([]byte, []int) {
+	return file_proto_crawler_crawler_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *TaskModule) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *TaskModule) GetUrl() string {
+	if x != nil {
+		return x.Url
+	}
+	return ""
+}
+
+func (x *TaskModule) GetCookie() string {
+	if x != nil {
+		return x.Cookie
+	}
+	return ""
+}
+
+func (x *TaskModule) GetWaitTime() int64 {
+	if x != nil {
+		return x.WaitTime
+	}
+	return 0
+}
+
+func (x *TaskModule) GetMaxDepth() int32 {
+	if x != nil {
+		return x.MaxDepth
+	}
+	return 0
+}
+
+func (x *TaskModule) GetTimeout() int64 {
+	if x != nil {
+		return x.Timeout
+	}
+	return 0
+}
+
+func (x *TaskModule) GetRootScript() string {
+	if x != nil {
+		return x.RootScript
+	}
+	return ""
+}
+
+func (x *TaskModule) GetRule() []*RuleModule {
+	if x != nil {
+		return x.Rule
+	}
+	return nil
+}
+
+func (x *TaskModule) GetLanguage() string {
+	if x != nil {
+		return x.Language
+	}
+	return ""
+}
+
+type RuleModule struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name        string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	ItemFields  []string `protobuf:"bytes,2,rep,name=item_fields,json=itemFields,proto3" json:"item_fields,omitempty"`
+	ParseScript string   `protobuf:"bytes,3,opt,name=parse_script,json=parseScript,proto3" json:"parse_script,omitempty"`
+	Key         []string `protobuf:"bytes,4,rep,name=key,proto3" json:"key,omitempty"`
+	History     bool     `protobuf:"varint,5,opt,name=history,proto3" json:"history,omitempty"`
+}
+
+func (x *RuleModule) Reset() {
+	*x = RuleModule{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_proto_crawler_crawler_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RuleModule) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RuleModule) ProtoMessage() {}
+
+func (x *RuleModule) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_crawler_crawler_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RuleModule.ProtoReflect.Descriptor instead.
+func (*RuleModule) Descriptor() ([]byte, []int) {
+	return file_proto_crawler_crawler_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *RuleModule) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *RuleModule) GetItemFields() []string {
+	if x != nil {
+		return x.ItemFields
+	}
+	return nil
+}
+
+func (x *RuleModule) GetParseScript() string {
+	if x != nil {
+		return x.ParseScript
+	}
+	return ""
+}
+
+func (x *RuleModule) GetKey() []string {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+func (x *RuleModule) GetHistory() bool {
+	if x != nil {
+		return x.History
+	}
+	return false
+}
+
 var File_proto_crawler_crawler_proto protoreflect.FileDescriptor
 
 var file_proto_crawler_crawler_proto_rawDesc = []byte{
@@ -168,20 +358,67 @@ var file_proto_crawler_crawler_proto_rawDesc = []byte{
 	0x4e, 0x6f, 0x64, 0x65, 0x53, 0x70, 0x65, 0x63, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01,
 	0x20, 0x01, 0x28, 0x09, 0x52, 0x02, 0x69, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x41, 0x64, 0x64, 0x72,
 	0x65, 0x73, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x41, 0x64, 0x64, 0x72, 0x65,
-	0x73, 0x73, 0x32, 0xb5, 0x01, 0x0a, 0x0d, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x4d, 0x61,
-	0x73, 0x74, 0x65, 0x72, 0x12, 0x4c, 0x0a, 0x0b, 0x41, 0x64, 0x64, 0x52, 0x65, 0x73, 0x6f, 0x75,
-	0x72, 0x63, 0x65, 0x12, 0x0d, 0x2e, 0x52, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x53, 0x70,
-	0x65, 0x63, 0x1a, 0x0f, 0x2e, 0x57, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x4e, 0x6f, 0x64, 0x65, 0x53,
-	0x70, 0x65, 0x63, 0x22, 0x1d, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x17, 0x3a, 0x01, 0x2a, 0x22, 0x12,
+	0x73, 0x73, 0x22, 0xfc, 0x01, 0x0a, 0x0a, 0x54, 0x61, 0x73, 0x6b, 0x4d, 0x6f, 0x64, 0x75, 0x6c,
+	0x65, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x04, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x75, 0x72, 0x6c, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x03, 0x75, 0x72, 0x6c, 0x12, 0x16, 0x0a, 0x06, 0x63, 0x6f, 0x6f, 0x6b, 0x69,
+	0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x63, 0x6f, 0x6f, 0x6b, 0x69, 0x65, 0x12,
+	0x1b, 0x0a, 0x09, 0x77, 0x61, 0x69, 0x74, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x08, 0x77, 0x61, 0x69, 0x74, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x1b, 0x0a, 0x09,
+	0x6d, 0x61, 0x78, 0x5f, 0x64, 0x65, 0x70, 0x74, 0x68, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x08, 0x6d, 0x61, 0x78, 0x44, 0x65, 0x70, 0x74, 0x68, 0x12, 0x18, 0x0a, 0x07, 0x74, 0x69, 0x6d,
+	0x65, 0x6f, 0x75, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x07, 0x74, 0x69, 0x6d, 0x65,
+	0x6f, 0x75, 0x74, 0x12, 0x1f, 0x0a, 0x0b, 0x72, 0x6f, 0x6f, 0x74, 0x5f, 0x73, 0x63, 0x72, 0x69,
+	0x70, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0a, 0x72, 0x6f, 0x6f, 0x74, 0x53, 0x63,
+	0x72, 0x69, 0x70, 0x74, 0x12, 0x1f, 0x0a, 0x04, 0x72, 0x75, 0x6c, 0x65, 0x18, 0x08, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x52, 0x75, 0x6c, 0x65, 0x4d, 0x6f, 0x64, 0x75, 0x6c, 0x65, 0x52,
+	0x04, 0x72, 0x75, 0x6c, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x6c, 0x61, 0x6e, 0x67, 0x75, 0x61, 0x67,
+	0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x6c, 0x61, 0x6e, 0x67, 0x75, 0x61, 0x67,
+	0x65, 0x22, 0x90, 0x01, 0x0a, 0x0a, 0x52, 0x75, 0x6c, 0x65, 0x4d, 0x6f, 0x64, 0x75, 0x6c, 0x65,
+	0x12, 0x12, 0x0a, 0x04, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04,
+	0x6e, 0x61, 0x6d, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x69, 0x74, 0x65, 0x6d, 0x5f, 0x66, 0x69, 0x65,
+	0x6c, 0x64, 0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0a, 0x69, 0x74, 0x65, 0x6d, 0x46,
+	0x69, 0x65, 0x6c, 0x64, 0x73, 0x12, 0x21, 0x0a, 0x0c, 0x70, 0x61, 0x72, 0x73, 0x65, 0x5f, 0x73,
+	0x63, 0x72, 0x69, 0x70, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x70, 0x61, 0x72,
+	0x73, 0x65, 0x53, 0x63, 0x72, 0x69, 0x70, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18,
+	0x04, 0x20, 0x03, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x68, 0x69,
+	0x73, 0x74, 0x6f, 0x72, 0x79, 0x18, 0x05, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x68, 0x69, 0x73,
+	0x74, 0x6f, 0x72, 0x79, 0x32, 0x97, 0x04, 0x0a, 0x0d, 0x43, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72,
+	0x4d, 0x61, 0x73, 0x74, 0x65, 0x72, 0x12, 0x4c, 0x0a, 0x0b, 0x41, 0x64, 0x64, 0x52, 0x65, 0x73,
+	0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x0d, 0x2e, 0x52, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65,
+	0x53, 0x70, 0x65, 0x63, 0x1a, 0x0f, 0x2e, 0x57, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x4e, 0x6f, 0x64,
+	0x65, 0x53, 0x70, 0x65, 0x63, 0x22, 0x1d, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x17, 0x3a, 0x01, 0x2a,
+	0x22, 0x12, 0x2f, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x2f, 0x72, 0x65, 0x73, 0x6f, 0x75,
+	0x72, 0x63, 0x65, 0x73, 0x12, 0x56, 0x0a, 0x0e, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65,
+	0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x12, 0x0d, 0x2e, 0x52, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63,
+	0x65, 0x53, 0x70, 0x65, 0x63, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x1d, 0x82,
+	0xd3, 0xe4, 0x93, 0x02, 0x17, 0x3a, 0x01, 0x2a, 0x2a, 0x12, 0x2f, 0x63, 0x72, 0x61, 0x77, 0x6c,
+	0x65, 0x72, 0x2f, 0x72, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x73, 0x12, 0x51, 0x0a, 0x0d,
+	0x41, 0x64, 0x64, 0x54, 0x61, 0x73, 0x6b, 0x4d, 0x6f, 0x64, 0x75, 0x6c, 0x65, 0x12, 0x0b, 0x2e,
+	0x54, 0x61, 0x73, 0x6b, 0x4d, 0x6f, 0x64, 0x75, 0x6c, 0x65, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70,
+	0x74, 0x79, 0x22, 0x1b, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x15, 0x3a, 0x01, 0x2a, 0x22, 0x10, 0x2f,
+	0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x2f, 0x6d, 0x6f, 0x64, 0x75, 0x6c, 0x65, 0x73, 0x12,
+	0x57, 0x0a, 0x09, 0x50, 0x61, 0x75, 0x73, 0x65, 0x54, 0x61, 0x73, 0x6b, 0x12, 0x0d, 0x2e, 0x52,
+	0x65, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x53, 0x70, 0x65, 0x63, 0x1a, 0x16, 0x2e, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d,
+	0x70, 0x74, 0x79, 0x22, 0x23, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x1d, 0x3a, 0x01, 0x2a, 0x22, 0x18,
 	0x2f, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x2f, 0x72, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63,
-	0x65, 0x73, 0x12, 0x56, 0x0a, 0x0e, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x65, 0x52, 0x65, 0x73, 0x6f,
-	0x75, 0x72, 0x63, 0x65, 0x12, 0x0d, 0x2e, 0x52, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x53,
-	0x70, 0x65, 0x63, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x1d, 0x82, 0xd3, 0xe4,
-	0x93, 0x02, 0x17, 0x3a, 0x01, 0x2a, 0x2a, 0x12, 0x2f, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72,
-	0x2f, 0x72, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x73, 0x42, 0x0f, 0x5a, 0x0d, 0x70, 0x72,
-	0x6f, 0x74, 0x6f, 0x2f, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x62, 0x06, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x33,
+	0x65, 0x73, 0x2f, 0x70, 0x61, 0x75, 0x73, 0x65, 0x12, 0x59, 0x0a, 0x0a, 0x52, 0x65, 0x73, 0x75,
+	0x6d, 0x65, 0x54, 0x61, 0x73, 0x6b, 0x12, 0x0d, 0x2e, 0x52, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63,
+	0x65, 0x53, 0x70, 0x65, 0x63, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x24, 0x82,
+	0xd3, 0xe4, 0x93, 0x02, 0x1e, 0x3a, 0x01, 0x2a, 0x22, 0x19, 0x2f, 0x63, 0x72, 0x61, 0x77, 0x6c,
+	0x65, 0x72, 0x2f, 0x72, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x73, 0x2f, 0x72, 0x65, 0x73,
+	0x75, 0x6d, 0x65, 0x12, 0x59, 0x0a, 0x0a, 0x43, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x54, 0x61, 0x73,
+	0x6b, 0x12, 0x0d, 0x2e, 0x52, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x53, 0x70, 0x65, 0x63,
+	0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x22, 0x24, 0x82, 0xd3, 0xe4, 0x93, 0x02, 0x1e,
+	0x3a, 0x01, 0x2a, 0x22, 0x19, 0x2f, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x2f, 0x72, 0x65,
+	0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x73, 0x2f, 0x63, 0x61, 0x6e, 0x63, 0x65, 0x6c, 0x42, 0x0f,
+	0x5a, 0x0d, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x2f, 0x63, 0x72, 0x61, 0x77, 0x6c, 0x65, 0x72, 0x62,
+	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
 }
 
 var (
@@ -196,22 +433,33 @@ func file_proto_crawler_crawler_proto_rawDescGZIP() []byte {
 	return file_proto_crawler_crawler_proto_rawDescData
 }
 
-var file_proto_crawler_crawler_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proto_crawler_crawler_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
 var file_proto_crawler_crawler_proto_goTypes = []interface{}{
 	(*ResourceSpec)(nil),   // 0: ResourceSpec
 	(*WorkerNodeSpec)(nil), // 1: WorkerNodeSpec
-	(*emptypb.Empty)(nil),  // 2: google.protobuf.Empty
+	(*TaskModule)(nil),     // 2: TaskModule
+	(*RuleModule)(nil),     // 3: RuleModule
+	(*emptypb.Empty)(nil),  // 4: google.protobuf.Empty
 }
 var file_proto_crawler_crawler_proto_depIdxs = []int32{
-	0, // 0: CrawlerMaster.AddResource:input_type -> ResourceSpec
-	0, // 1: CrawlerMaster.DeleteResource:input_type -> ResourceSpec
-	1, // 2: CrawlerMaster.AddResource:output_type -> WorkerNodeSpec
-	2, // 3: CrawlerMaster.DeleteResource:output_type -> google.protobuf.Empty
-	2, // [2:4] is the sub-list for method output_type
-	0, // [0:2] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	3, // 0: TaskModule.rule:type_name -> RuleModule
+	0, // 1: CrawlerMaster.AddResource:input_type -> ResourceSpec
+	0, // 2: CrawlerMaster.DeleteResource:input_type -> ResourceSpec
+	2, // 3: CrawlerMaster.AddTaskModule:input_type -> TaskModule
+	0, // 4: CrawlerMaster.PauseTask:input_type -> ResourceSpec
+	0, // 5: CrawlerMaster.ResumeTask:input_type -> ResourceSpec
+	0, // 6: CrawlerMaster.CancelTask:input_type -> ResourceSpec
+	1, // 7: CrawlerMaster.AddResource:output_type -> WorkerNodeSpec
+	4, // 8: CrawlerMaster.DeleteResource:output_type -> google.protobuf.Empty
+	4, // 9: CrawlerMaster.AddTaskModule:output_type -> google.protobuf.Empty
+	4, // 10: CrawlerMaster.PauseTask:output_type -> google.protobuf.Empty
+	4, // 11: CrawlerMaster.ResumeTask:output_type -> google.protobuf.Empty
+	4, // 12: CrawlerMaster.CancelTask:output_type -> google.protobuf.Empty
+	7, // [7:13] is the sub-list for method output_type
+	1, // [1:7] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_proto_crawler_crawler_proto_init() }
@@ -244,6 +492,30 @@ func file_proto_crawler_crawler_proto_init() {
 				return nil
 			}
 		}
+		file_proto_crawler_crawler_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TaskModule); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_proto_crawler_crawler_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RuleModule); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -251,7 +523,7 @@ func file_proto_crawler_crawler_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_proto_crawler_crawler_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   4,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -37,14 +37,36 @@ func NewCrawlerMasterEndpoints() []*api.Endpoint {
 			Name:    "CrawlerMaster.AddResource",
 			Path:    []string{"/crawler/resources"},
 			Method:  []string{"POST"},
-			Body:    "*",
 			Handler: "rpc",
 		},
 		{
 			Name:    "CrawlerMaster.DeleteResource",
 			Path:    []string{"/crawler/resources"},
 			Method:  []string{"DELETE"},
-			Body:    "*",
+			Handler: "rpc",
+		},
+		{
+			Name:    "CrawlerMaster.AddTaskModule",
+			Path:    []string{"/crawler/modules"},
+			Method:  []string{"POST"},
+			Handler: "rpc",
+		},
+		{
+			Name:    "CrawlerMaster.PauseTask",
+			Path:    []string{"/crawler/resources/pause"},
+			Method:  []string{"POST"},
+			Handler: "rpc",
+		},
+		{
+			Name:    "CrawlerMaster.ResumeTask",
+			Path:    []string{"/crawler/resources/resume"},
+			Method:  []string{"POST"},
+			Handler: "rpc",
+		},
+		{
+			Name:    "CrawlerMaster.CancelTask",
+			Path:    []string{"/crawler/resources/cancel"},
+			Method:  []string{"POST"},
 			Handler: "rpc",
 		},
 	}
@@ -55,6 +77,10 @@ func NewCrawlerMasterEndpoints() []*api.Endpoint {
 type CrawlerMasterService interface {
 	AddResource(ctx context.Context, in *ResourceSpec, opts ...client.CallOption) (*WorkerNodeSpec, error)
 	DeleteResource(ctx context.Context, in *ResourceSpec, opts ...client.CallOption) (*emptypb.Empty, error)
+	AddTaskModule(ctx context.Context, in *TaskModule, opts ...client.CallOption) (*emptypb.Empty, error)
+	PauseTask(ctx context.Context, in *ResourceSpec, opts ...client.CallOption) (*emptypb.Empty, error)
+	ResumeTask(ctx context.Context, in *ResourceSpec, opts ...client.CallOption) (*emptypb.Empty, error)
+	CancelTask(ctx context.Context, in *ResourceSpec, opts ...client.CallOption) (*emptypb.Empty, error)
 }
 
 type crawlerMasterService struct {
@@ -89,17 +115,65 @@ func (c *crawlerMasterService) DeleteResource(ctx context.Context, in *ResourceS
 	return out, nil
 }
 
+func (c *crawlerMasterService) AddTaskModule(ctx context.Context, in *TaskModule, opts ...client.CallOption) (*emptypb.Empty, error) {
+	req := c.c.NewRequest(c.name, "CrawlerMaster.AddTaskModule", in)
+	out := new(emptypb.Empty)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crawlerMasterService) PauseTask(ctx context.Context, in *ResourceSpec, opts ...client.CallOption) (*emptypb.Empty, error) {
+	req := c.c.NewRequest(c.name, "CrawlerMaster.PauseTask", in)
+	out := new(emptypb.Empty)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crawlerMasterService) ResumeTask(ctx context.Context, in *ResourceSpec, opts ...client.CallOption) (*emptypb.Empty, error) {
+	req := c.c.NewRequest(c.name, "CrawlerMaster.ResumeTask", in)
+	out := new(emptypb.Empty)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crawlerMasterService) CancelTask(ctx context.Context, in *ResourceSpec, opts ...client.CallOption) (*emptypb.Empty, error) {
+	req := c.c.NewRequest(c.name, "CrawlerMaster.CancelTask", in)
+	out := new(emptypb.Empty)
+	err := c.c.Call(ctx, req, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // Server API for CrawlerMaster service
 
 type CrawlerMasterHandler interface {
 	AddResource(context.Context, *ResourceSpec, *WorkerNodeSpec) error
 	DeleteResource(context.Context, *ResourceSpec, *emptypb.Empty) error
+	AddTaskModule(context.Context, *TaskModule, *emptypb.Empty) error
+	PauseTask(context.Context, *ResourceSpec, *emptypb.Empty) error
+	ResumeTask(context.Context, *ResourceSpec, *emptypb.Empty) error
+	CancelTask(context.Context, *ResourceSpec, *emptypb.Empty) error
 }
 
 func RegisterCrawlerMasterHandler(s server.Server, hdlr CrawlerMasterHandler, opts ...server.HandlerOption) error {
 	type crawlerMaster interface {
 		AddResource(ctx context.Context, in *ResourceSpec, out *WorkerNodeSpec) error
 		DeleteResource(ctx context.Context, in *ResourceSpec, out *emptypb.Empty) error
+		AddTaskModule(ctx context.Context, in *TaskModule, out *emptypb.Empty) error
+		PauseTask(ctx context.Context, in *ResourceSpec, out *emptypb.Empty) error
+		ResumeTask(ctx context.Context, in *ResourceSpec, out *emptypb.Empty) error
+		CancelTask(ctx context.Context, in *ResourceSpec, out *emptypb.Empty) error
 	}
 	type CrawlerMaster struct {
 		crawlerMaster
@@ -109,14 +183,36 @@ func RegisterCrawlerMasterHandler(s server.Server, hdlr CrawlerMasterHandler, op
 		Name:    "CrawlerMaster.AddResource",
 		Path:    []string{"/crawler/resources"},
 		Method:  []string{"POST"},
-		Body:    "*",
 		Handler: "rpc",
 	}))
 	opts = append(opts, api.WithEndpoint(&api.Endpoint{
 		Name:    "CrawlerMaster.DeleteResource",
 		Path:    []string{"/crawler/resources"},
 		Method:  []string{"DELETE"},
-		Body:    "*",
+		Handler: "rpc",
+	}))
+	opts = append(opts, api.WithEndpoint(&api.Endpoint{
+		Name:    "CrawlerMaster.AddTaskModule",
+		Path:    []string{"/crawler/modules"},
+		Method:  []string{"POST"},
+		Handler: "rpc",
+	}))
+	opts = append(opts, api.WithEndpoint(&api.Endpoint{
+		Name:    "CrawlerMaster.PauseTask",
+		Path:    []string{"/crawler/resources/pause"},
+		Method:  []string{"POST"},
+		Handler: "rpc",
+	}))
+	opts = append(opts, api.WithEndpoint(&api.Endpoint{
+		Name:    "CrawlerMaster.ResumeTask",
+		Path:    []string{"/crawler/resources/resume"},
+		Method:  []string{"POST"},
+		Handler: "rpc",
+	}))
+	opts = append(opts, api.WithEndpoint(&api.Endpoint{
+		Name:    "CrawlerMaster.CancelTask",
+		Path:    []string{"/crawler/resources/cancel"},
+		Method:  []string{"POST"},
 		Handler: "rpc",
 	}))
 	return s.Handle(s.NewHandler(&CrawlerMaster{h}, opts...))
@@ -133,3 +229,19 @@ func (h *crawlerMasterHandler) AddResource(ctx context.Context, in *ResourceSpec
 func (h *crawlerMasterHandler) DeleteResource(ctx context.Context, in *ResourceSpec, out *emptypb.Empty) error {
 	return h.CrawlerMasterHandler.DeleteResource(ctx, in, out)
 }
+
+func (h *crawlerMasterHandler) AddTaskModule(ctx context.Context, in *TaskModule, out *emptypb.Empty) error {
+	return h.CrawlerMasterHandler.AddTaskModule(ctx, in, out)
+}
+
+func (h *crawlerMasterHandler) PauseTask(ctx context.Context, in *ResourceSpec, out *emptypb.Empty) error {
+	return h.CrawlerMasterHandler.PauseTask(ctx, in, out)
+}
+
+func (h *crawlerMasterHandler) ResumeTask(ctx context.Context, in *ResourceSpec, out *emptypb.Empty) error {
+	return h.CrawlerMasterHandler.ResumeTask(ctx, in, out)
+}
+
+func (h *crawlerMasterHandler) CancelTask(ctx context.Context, in *ResourceSpec, out *emptypb.Empty) error {
+	return h.CrawlerMasterHandler.CancelTask(ctx, in, out)
+}
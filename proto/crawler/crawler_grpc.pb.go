@@ -22,6 +22,10 @@ const _ = grpc.SupportPackageIsVersion7
 const (
 	CrawlerMaster_AddResource_FullMethodName    = "/CrawlerMaster/AddResource"
 	CrawlerMaster_DeleteResource_FullMethodName = "/CrawlerMaster/DeleteResource"
+	CrawlerMaster_AddTaskModule_FullMethodName  = "/CrawlerMaster/AddTaskModule"
+	CrawlerMaster_PauseTask_FullMethodName      = "/CrawlerMaster/PauseTask"
+	CrawlerMaster_ResumeTask_FullMethodName     = "/CrawlerMaster/ResumeTask"
+	CrawlerMaster_CancelTask_FullMethodName     = "/CrawlerMaster/CancelTask"
 )
 
 // CrawlerMasterClient is the client API for CrawlerMaster service.
@@ -30,6 +34,10 @@ const (
 type CrawlerMasterClient interface {
 	AddResource(ctx context.Context, in *ResourceSpec, opts ...grpc.CallOption) (*WorkerNodeSpec, error)
 	DeleteResource(ctx context.Context, in *ResourceSpec, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	AddTaskModule(ctx context.Context, in *TaskModule, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	PauseTask(ctx context.Context, in *ResourceSpec, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	ResumeTask(ctx context.Context, in *ResourceSpec, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	CancelTask(ctx context.Context, in *ResourceSpec, opts ...grpc.CallOption) (*emptypb.Empty, error)
 }
 
 type crawlerMasterClient struct {
@@ -58,12 +66,52 @@ func (c *crawlerMasterClient) DeleteResource(ctx context.Context, in *ResourceSp
 	return out, nil
 }
 
+func (c *crawlerMasterClient) AddTaskModule(ctx context.Context, in *TaskModule, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, CrawlerMaster_AddTaskModule_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crawlerMasterClient) PauseTask(ctx context.Context, in *ResourceSpec, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, CrawlerMaster_PauseTask_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crawlerMasterClient) ResumeTask(ctx context.Context, in *ResourceSpec, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, CrawlerMaster_ResumeTask_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *crawlerMasterClient) CancelTask(ctx context.Context, in *ResourceSpec, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, CrawlerMaster_CancelTask_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // CrawlerMasterServer is the server API for CrawlerMaster service.
 // All implementations must embed UnimplementedCrawlerMasterServer
 // for forward compatibility
 type CrawlerMasterServer interface {
 	AddResource(context.Context, *ResourceSpec) (*WorkerNodeSpec, error)
 	DeleteResource(context.Context, *ResourceSpec) (*emptypb.Empty, error)
+	AddTaskModule(context.Context, *TaskModule) (*emptypb.Empty, error)
+	PauseTask(context.Context, *ResourceSpec) (*emptypb.Empty, error)
+	ResumeTask(context.Context, *ResourceSpec) (*emptypb.Empty, error)
+	CancelTask(context.Context, *ResourceSpec) (*emptypb.Empty, error)
 	mustEmbedUnimplementedCrawlerMasterServer()
 }
 
@@ -77,6 +125,18 @@ func (UnimplementedCrawlerMasterServer) AddResource(context.Context, *ResourceSp
 func (UnimplementedCrawlerMasterServer) DeleteResource(context.Context, *ResourceSpec) (*emptypb.Empty, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteResource not implemented")
 }
+func (UnimplementedCrawlerMasterServer) AddTaskModule(context.Context, *TaskModule) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddTaskModule not implemented")
+}
+func (UnimplementedCrawlerMasterServer) PauseTask(context.Context, *ResourceSpec) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method PauseTask not implemented")
+}
+func (UnimplementedCrawlerMasterServer) ResumeTask(context.Context, *ResourceSpec) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ResumeTask not implemented")
+}
+func (UnimplementedCrawlerMasterServer) CancelTask(context.Context, *ResourceSpec) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelTask not implemented")
+}
 func (UnimplementedCrawlerMasterServer) mustEmbedUnimplementedCrawlerMasterServer() {}
 
 // UnsafeCrawlerMasterServer may be embedded to opt out of forward compatibility for this service.
@@ -126,6 +186,78 @@ func _CrawlerMaster_DeleteResource_Handler(srv interface{}, ctx context.Context,
 	return interceptor(ctx, in, info, handler)
 }
 
+func _CrawlerMaster_AddTaskModule_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TaskModule)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrawlerMasterServer).AddTaskModule(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CrawlerMaster_AddTaskModule_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrawlerMasterServer).AddTaskModule(ctx, req.(*TaskModule))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CrawlerMaster_PauseTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResourceSpec)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrawlerMasterServer).PauseTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CrawlerMaster_PauseTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrawlerMasterServer).PauseTask(ctx, req.(*ResourceSpec))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CrawlerMaster_ResumeTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResourceSpec)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrawlerMasterServer).ResumeTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CrawlerMaster_ResumeTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrawlerMasterServer).ResumeTask(ctx, req.(*ResourceSpec))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CrawlerMaster_CancelTask_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResourceSpec)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CrawlerMasterServer).CancelTask(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CrawlerMaster_CancelTask_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CrawlerMasterServer).CancelTask(ctx, req.(*ResourceSpec))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // CrawlerMaster_ServiceDesc is the grpc.ServiceDesc for CrawlerMaster service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -141,6 +273,22 @@ var CrawlerMaster_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteResource",
 			Handler:    _CrawlerMaster_DeleteResource_Handler,
 		},
+		{
+			MethodName: "AddTaskModule",
+			Handler:    _CrawlerMaster_AddTaskModule_Handler,
+		},
+		{
+			MethodName: "PauseTask",
+			Handler:    _CrawlerMaster_PauseTask_Handler,
+		},
+		{
+			MethodName: "ResumeTask",
+			Handler:    _CrawlerMaster_ResumeTask_Handler,
+		},
+		{
+			MethodName: "CancelTask",
+			Handler:    _CrawlerMaster_CancelTask_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/crawler/crawler.proto",
@@ -0,0 +1,148 @@
+package sqldb
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HistorySuffix 历史表名的后缀
+const HistorySuffix = "_history"
+
+// ReplacedAt 历史表中记录数据被替换时间的字段
+const ReplacedAt = "replaced_at"
+
+// historyTable 历史表与原表的字段相同，另外记录数据被替换的时间
+func historyTable(t TableMetaData) TableMetaData {
+	columns := make([]Field, 0, len(t.ColumnNames)+1)
+	columns = append(columns, t.ColumnNames...)
+	columns = append(columns, Field{Title: ReplacedAt, Type: TypeString})
+	return TableMetaData{
+		TableName:   t.TableName + HistorySuffix,
+		ColumnNames: columns,
+		AutoKey:     true,
+	}
+}
+
+// insertWithHistory 在同一个事务中将内容变化的旧数据写入历史表，再插入或更新数据
+func (d *Sqldb) insertWithHistory(t TableMetaData) (err error) {
+	keys, _, err := splitKey(t)
+	if err != nil {
+		return err
+	}
+	upsert, err := InsertSQL(d.dialect, t)
+	if err != nil {
+		return err
+	}
+	n := len(t.ColumnNames)
+	if len(t.Args) != n*t.DataCount {
+		return fmt.Errorf("insert %s: %d args for %d rows of %d columns", t.TableName, len(t.Args), t.DataCount, n)
+	}
+
+	index := make(map[string]int, n)
+	for i, c := range t.ColumnNames {
+		index[c.Title] = i
+	}
+	ignore := make(map[string]bool, len(keys)+len(t.IgnoreChanges))
+	for _, k := range keys {
+		ignore[k] = true
+	}
+	for _, c := range t.IgnoreChanges {
+		ignore[c] = true
+	}
+	conds := make([]string, 0, len(keys))
+	for i, k := range keys {
+		conds = append(conds, d.dialect.Quote(k)+` = `+d.dialect.Placeholder(i+1))
+	}
+	query := `SELECT ` + strings.Join(quote(d.dialect, columnTitles(t.ColumnNames)), `,`) +
+		` FROM ` + d.dialect.Quote(t.TableName) + ` WHERE ` + strings.Join(conds, ` AND `)
+	history := historyTable(t)
+	history.DataCount = 1
+	insertHistory, err := InsertSQL(d.dialect, history)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	replacedAt := time.Now().Format(time.RFC3339)
+	for i := 0; i < t.DataCount; i++ {
+		row := t.Args[i*n : (i+1)*n]
+		keyArgs := make([]interface{}, 0, len(keys))
+		for _, k := range keys {
+			keyArgs = append(keyArgs, row[index[k]])
+		}
+		old := make([]interface{}, n)
+		dest := make([]interface{}, n)
+		for j := range old {
+			dest[j] = &old[j]
+		}
+		err = tx.QueryRow(query, keyArgs...).Scan(dest...)
+		if err == sql.ErrNoRows {
+			err = nil
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !changed(t.ColumnNames, ignore, old, row) {
+			continue
+		}
+		if _, err = tx.Exec(insertHistory, append(old, replacedAt)...); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec(upsert, t.Args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func columnTitles(fields []Field) []string {
+	titles := make([]string, 0, len(fields))
+	for _, f := range fields {
+		titles = append(titles, f.Title)
+	}
+	return titles
+}
+
+// changed 比较数据库中的旧数据与新数据，忽略 ignore 中的字段
+func changed(fields []Field, ignore map[string]bool, old []interface{}, row []interface{}) bool {
+	for i, f := range fields {
+		if ignore[f.Title] {
+			continue
+		}
+		if normalize(old[i]) != normalize(row[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize 不同驱动读出的值类型不同，统一转换为字符串比较
+func normalize(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case []byte:
+		return string(v)
+	case bool:
+		if v {
+			return "1"
+		}
+		return "0"
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,168 @@
+package sqldb
+
+import (
+	"encoding/json"
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+// MigrationTable 记录每张表结构版本的迁移表
+const MigrationTable = "crawler_migrations"
+
+var migrationColumns = []Field{
+	{Title: "table_name", Type: TypeString},
+	{Title: "version", Type: TypeInt},
+	{Title: "columns", Type: TypeText}, // 本次创建或添加的字段，JSON
+	{Title: "applied_at", Type: TypeString},
+}
+
+// columns 查询表中已有的字段及其通用类型，表不存在时返回 false
+func (d *Sqldb) columns(table string) (map[string]string, bool, error) {
+	var n int
+	if err := d.db.QueryRow(d.dialect.TableExistsQuery(), table).Scan(&n); err != nil {
+		return nil, false, fmt.Errorf("check table %s failed:%w", table, err)
+	}
+	if n == 0 {
+		return nil, false, nil
+	}
+	rows, err := d.db.Query(`SELECT * FROM ` + d.dialect.Quote(table) + ` WHERE 1 = 0`)
+	if err != nil {
+		return nil, true, fmt.Errorf("query columns of %s failed:%w", table, err)
+	}
+	defer rows.Close()
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, true, fmt.Errorf("query columns of %s failed:%w", table, err)
+	}
+	cols := make(map[string]string, len(types))
+	for _, ct := range types {
+		cols[ct.Name()] = GenericType(ct.DatabaseTypeName())
+	}
+	return cols, true, nil
+}
+
+func (d *Sqldb) exec(sql string, args ...interface{}) error {
+	d.logger.Debug("exec", zap.String("sql", sql))
+	_, err := d.db.Exec(sql, args...)
+	return err
+}
+
+// migrate 创建表或添加缺少的字段，并在迁移表中记录新的版本。
+// 表有自然键时创建唯一索引，需要保留历史时同时迁移历史表
+func (d *Sqldb) migrate(t TableMetaData) error {
+	if err := d.migrateColumns(t); err != nil {
+		return err
+	}
+	if len(t.UniqueKey) > 0 {
+		if err := d.ensureUniqueIndex(t.TableName, t.UniqueKey); err != nil {
+			return err
+		}
+	}
+	if t.History {
+		return d.migrateColumns(historyTable(t))
+	}
+	return nil
+}
+
+func (d *Sqldb) migrateColumns(t TableMetaData) error {
+	if err := checkTable(t); err != nil {
+		return err
+	}
+	var changed []Field
+	existing, ok, err := d.columns(t.TableName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		sql, err := CreateTableSQL(d.dialect, t)
+		if err != nil {
+			return err
+		}
+		if err := d.exec(sql); err != nil {
+			return err
+		}
+		changed = t.ColumnNames
+	} else {
+		for _, c := range t.ColumnNames {
+			if _, ok := existing[c.Title]; ok {
+				continue
+			}
+			sql, err := AddColumnSQL(d.dialect, t.TableName, c)
+			if err != nil {
+				return err
+			}
+			if err := d.exec(sql); err != nil {
+				return fmt.Errorf("add column %s failed:%w", c.Title, err)
+			}
+			changed = append(changed, c)
+		}
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	return d.recordMigration(t.TableName, changed)
+}
+
+// recordMigration 在迁移表中记录表的新版本
+func (d *Sqldb) recordMigration(table string, changed []Field) error {
+	sql, err := CreateTableSQL(d.dialect, TableMetaData{
+		TableName:   MigrationTable,
+		ColumnNames: migrationColumns,
+		AutoKey:     true,
+	})
+	if err != nil {
+		return err
+	}
+	if err := d.exec(sql); err != nil {
+		return err
+	}
+
+	var version int64
+	row := d.db.QueryRow(`SELECT COALESCE(MAX(`+d.dialect.Quote("version")+`), 0) FROM `+
+		d.dialect.Quote(MigrationTable)+` WHERE `+d.dialect.Quote("table_name")+` = `+d.dialect.Placeholder(1), table)
+	if err := row.Scan(&version); err != nil {
+		return err
+	}
+	cols, err := json.Marshal(changed)
+	if err != nil {
+		return err
+	}
+	insert := TableMetaData{
+		TableName:   MigrationTable,
+		ColumnNames: migrationColumns,
+		Args:        []interface{}{table, version + 1, string(cols), time.Now().Format(time.RFC3339)},
+		DataCount:   1,
+	}
+	sql, err = InsertSQL(d.dialect, insert)
+	if err != nil {
+		return err
+	}
+	if err := d.exec(sql, insert.Args...); err != nil {
+		return err
+	}
+	d.logger.Info("migrate table", zap.String("table", table), zap.Int64("version", version+1))
+	return nil
+}
+
+// ensureUniqueIndex 自然键的唯一索引不存在时创建
+func (d *Sqldb) ensureUniqueIndex(table string, keys []string) error {
+	var n int
+	name := UniqueIndexName(table, keys)
+	if err := d.db.QueryRow(d.dialect.IndexExistsQuery(), table, name).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	sql, err := UniqueIndexSQL(d.dialect, table, keys)
+	if err != nil {
+		return err
+	}
+	if err := d.exec(sql); err != nil {
+		// 已有重复数据时无法创建唯一索引，需要先清理
+		return fmt.Errorf("create unique index %s on %s failed:%w", name, table, err)
+	}
+	d.logger.Info("create unique index", zap.String("table", table), zap.Strings("keys", keys))
+	return nil
+}
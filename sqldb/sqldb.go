@@ -1,13 +1,18 @@
 package sqldb
 
 /** 本模块是一个更加底层的模块，只进行数据的存储
-**	使用了原生的 MySQL 语句来与数据库交互
+**	使用原生的 SQL 语句与数据库交互，语句按连接地址对应的方言生成
  */
 
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
 	"go.uber.org/zap"
+	"hash/fnv"
 	"strings"
 )
 
@@ -15,12 +20,14 @@ import (
 type DBer interface {
 	CreateTable(t TableMetaData) error
 	Insert(t TableMetaData) error
+	Close() error
 }
 
 // Sqldb : DBer 的实现
 type Sqldb struct {
 	options
-	db *sql.DB
+	dialect Dialect
+	db      *sql.DB
 }
 
 func New(opts ...Option) (*Sqldb, error) {
@@ -40,21 +47,31 @@ func New(opts ...Option) (*Sqldb, error) {
 //	return &Sqldb{}
 //}
 
-// OpenDB 用于与数据库建立连接，需要从外部传入远程 MySQL 数据库的连接地址
+// OpenDB 用于与数据库建立连接，按连接地址的 scheme 选择方言
 func (d *Sqldb) OpenDB() error {
-	db, err := sql.Open("mysql", d.sqlUrl)
+	dialect, dsn, err := ParseURL(d.sqlUrl)
 	if err != nil {
 		return err
 	}
-	db.SetMaxOpenConns(2048)
-	db.SetMaxIdleConns(2048)
+	db, err := sql.Open(dialect.DriverName(), dsn)
+	if err != nil {
+		return err
+	}
+	db.SetMaxOpenConns(dialect.MaxOpenConns())
+	db.SetMaxIdleConns(dialect.MaxOpenConns())
 	if err = db.Ping(); err != nil {
 		return err
 	}
+	d.dialect = dialect
 	d.db = db
 	return nil
 }
 
+// Dialect 返回数据库的方言
+func (d *Sqldb) Dialect() Dialect {
+	return d.dialect
+}
+
 type Field struct {
 	Title string // 字段名
 	Type  string // 字段属性(类型)
@@ -66,44 +83,172 @@ type TableMetaData struct {
 	Args        []interface{} // 要插入的数据
 	DataCount   int           // 插入数据的数量
 	AutoKey     bool          // 标识是否为表创建自增主键
+	UniqueKey   []string      // 自然键，不为空时创建唯一索引，插入键相同的数据时更新原有数据
+	History     bool          // 更新的数据内容变化时，将旧的数据保存到历史表 {TableName}_history
+	// IgnoreChanges 判断数据内容是否变化时忽略的字段，例如抓取时间
+	IgnoreChanges []string
 }
 
-// CreateTable 拼接 MySQL 语句， 执行数据库操作
+// CreateTable 创建表，表已经存在时添加缺少的字段，表结构变化时记录到迁移表中
 func (d *Sqldb) CreateTable(t TableMetaData) error {
+	return d.migrate(t)
+}
+
+// ColumnTypes 返回表中字段的通用类型，表不存在时返回空
+func (d *Sqldb) ColumnTypes(table string) (map[string]string, error) {
+	cols, _, err := d.columns(table)
+	return cols, err
+}
+
+// Insert 批量插入数据，表有自然键时按方言的方式更新键相同的数据
+func (d *Sqldb) Insert(t TableMetaData) error {
+	if t.History && len(t.UniqueKey) > 0 {
+		return d.insertWithHistory(t)
+	}
+	sql, err := InsertSQL(d.dialect, t)
+	if err != nil {
+		return err
+	}
+
+	d.logger.Debug("insert table", zap.String("sql", sql))
+
+	_, err = d.db.Exec(sql, t.Args...)
+	return err
+}
+
+// checkIdent 检查表名与字段名。标识符都会被引用，只需要拒绝引用也无法表示的名称
+func checkIdent(names ...string) error {
+	for _, name := range names {
+		if name == "" {
+			return errors.New("identifier can not be empty")
+		}
+		if strings.ContainsRune(name, 0) {
+			return fmt.Errorf("invalid identifier %q", name)
+		}
+	}
+	return nil
+}
+
+func checkTable(t TableMetaData) error {
+	if err := checkIdent(t.TableName); err != nil {
+		return err
+	}
+	for _, c := range t.ColumnNames {
+		if err := checkIdent(c.Title); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateTableSQL 生成方言对应的建表语句
+func CreateTableSQL(dialect Dialect, t TableMetaData) (string, error) {
 	if len(t.ColumnNames) == 0 {
-		return errors.New("column can not be empty")
+		return "", errors.New("column can not be empty")
+	}
+	if err := checkTable(t); err != nil {
+		return "", err
 	}
 
-	sql := `CREATE TABLE IF NOT EXISTS ` + t.TableName + " ("
+	columns := make([]string, 0, len(t.ColumnNames)+1)
 	if t.AutoKey {
-		sql += `id INT(12) NOT NULL PRIMARY KEY AUTO_INCREMENT,`
+		columns = append(columns, dialect.AutoKey())
 	}
-	for _, t := range t.ColumnNames {
-		sql += t.Title + ` ` + t.Type + `,`
+	for _, c := range t.ColumnNames {
+		columns = append(columns, dialect.Quote(c.Title)+` `+dialect.ColumnType(c.Type))
 	}
-	sql = sql[:len(sql)-1] + `) ENGINE=MyISAM DEFAULT CHARSET=utf8;`
+	return `CREATE TABLE IF NOT EXISTS ` + dialect.Quote(t.TableName) +
+		` (` + strings.Join(columns, `,`) + `)` + dialect.TableOptions() + `;`, nil
+}
 
-	d.logger.Debug("crate table", zap.String("sql", sql))
+// InsertSQL 生成方言对应的批量插入语句
+func InsertSQL(dialect Dialect, t TableMetaData) (string, error) {
+	if len(t.ColumnNames) == 0 {
+		return "", errors.New("empty columns")
+	}
+	if err := checkTable(t); err != nil {
+		return "", err
+	}
 
-	_, err := d.db.Exec(sql)
-	return err
+	columns := make([]string, 0, len(t.ColumnNames))
+	for _, c := range t.ColumnNames {
+		columns = append(columns, dialect.Quote(c.Title))
+	}
+	rows := make([]string, 0, t.DataCount)
+	n := 0
+	for i := 0; i < t.DataCount; i++ {
+		values := make([]string, 0, len(columns))
+		for range columns {
+			n++
+			values = append(values, dialect.Placeholder(n))
+		}
+		rows = append(rows, `(`+strings.Join(values, `,`)+`)`)
+	}
+	sql := `INSERT INTO ` + dialect.Quote(t.TableName) + ` (` + strings.Join(columns, `,`) +
+		`) VALUES ` + strings.Join(rows, `,`)
+	if len(t.UniqueKey) > 0 {
+		keys, updates, err := splitKey(t)
+		if err != nil {
+			return "", err
+		}
+		sql += dialect.Upsert(quote(dialect, keys), quote(dialect, updates))
+	}
+	return sql + `;`, nil
 }
 
-func (d *Sqldb) Insert(t TableMetaData) error {
-	if len(t.ColumnNames) == 0 {
-		return errors.New("empty columns")
+// splitKey 将字段分为自然键与其余需要更新的字段
+func splitKey(t TableMetaData) ([]string, []string, error) {
+	isKey := make(map[string]bool, len(t.UniqueKey))
+	for _, k := range t.UniqueKey {
+		isKey[k] = true
 	}
+	var keys, updates []string
+	for _, c := range t.ColumnNames {
+		if isKey[c.Title] {
+			keys = append(keys, c.Title)
+		} else {
+			updates = append(updates, c.Title)
+		}
+	}
+	if len(keys) != len(isKey) {
+		return nil, nil, fmt.Errorf("unique key %v not in columns of %s", t.UniqueKey, t.TableName)
+	}
+	return keys, updates, nil
+}
 
-	sql := `INSERT INTO ` + t.TableName + `(`
-	for _, v := range t.ColumnNames {
-		sql += v.Title + ","
+func quote(dialect Dialect, names []string) []string {
+	quoted := make([]string, 0, len(names))
+	for _, name := range names {
+		quoted = append(quoted, dialect.Quote(name))
 	}
-	sql = sql[:len(sql)-1] + `) VALUES `
-	blank := ",(" + strings.Repeat(",?", len(t.ColumnNames))[1:] + ")"
-	sql += strings.Repeat(blank, t.DataCount)[1:] + `;`
+	return quoted
+}
 
-	d.logger.Debug("insert table", zap.String("sql", sql))
+// UniqueIndexName 自然键对应的唯一索引名，使用哈希避免超出数据库对索引名长度的限制
+func UniqueIndexName(table string, keys []string) string {
+	h := fnv.New32a()
+	h.Write([]byte(table + "\x00" + strings.Join(keys, "\x00")))
+	return fmt.Sprintf("uk_%08x", h.Sum32())
+}
 
-	_, err := d.db.Exec(sql, t.Args...)
-	return err
+// UniqueIndexSQL 生成方言对应的创建唯一索引语句
+func UniqueIndexSQL(dialect Dialect, table string, keys []string) (string, error) {
+	if err := checkIdent(append([]string{table}, keys...)...); err != nil {
+		return "", err
+	}
+	return `CREATE UNIQUE INDEX ` + dialect.Quote(UniqueIndexName(table, keys)) + ` ON ` +
+		dialect.Quote(table) + ` (` + strings.Join(quote(dialect, keys), `,`) + `);`, nil
+}
+
+func (d *Sqldb) Close() error {
+	return d.db.Close()
+}
+
+// AddColumnSQL 生成方言对应的添加字段语句
+func AddColumnSQL(dialect Dialect, table string, f Field) (string, error) {
+	if err := checkIdent(table, f.Title); err != nil {
+		return "", err
+	}
+	return `ALTER TABLE ` + dialect.Quote(table) + ` ADD COLUMN ` +
+		dialect.Quote(f.Title) + ` ` + dialect.ColumnType(f.Type) + `;`, nil
 }
@@ -0,0 +1,198 @@
+package filestorage
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+	"io"
+	"strings"
+)
+
+// encoder 将数据按文件格式编码
+type encoder interface {
+	Write(cell *storage.DataCell) (int, error) // 返回写入的字节数(压缩前)
+	Flush() error
+	Close() error // 写入文件的结尾，不关闭文件
+}
+
+func newEncoder(format string, w io.Writer, fields []string, compress bool) (encoder, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonlEncoder{stream: newStream(w, compress)}, nil
+	case FormatCSV:
+		return newCSVEncoder(newStream(w, compress), fields)
+	case FormatParquet:
+		return newParquetEncoder(w, fields, compress)
+	}
+	return nil, fmt.Errorf("unsupported file format %q", format)
+}
+
+// stream 带缓冲的输出，可选 gzip 压缩
+type stream struct {
+	*bufio.Writer
+	gz *gzip.Writer
+}
+
+func newStream(w io.Writer, compress bool) *stream {
+	s := &stream{}
+	if compress {
+		s.gz = gzip.NewWriter(w)
+		w = s.gz
+	}
+	s.Writer = bufio.NewWriter(w)
+	return s
+}
+
+func (s *stream) Flush() error {
+	if err := s.Writer.Flush(); err != nil {
+		return err
+	}
+	if s.gz != nil {
+		return s.gz.Flush()
+	}
+	return nil
+}
+
+func (s *stream) Close() error {
+	if err := s.Writer.Flush(); err != nil {
+		return err
+	}
+	if s.gz != nil {
+		return s.gz.Close()
+	}
+	return nil
+}
+
+// jsonlEncoder 每行一个 JSON 对象，包含任务名、规则名、网址、抓取时间与数据
+type jsonlEncoder struct {
+	*stream
+}
+
+func (e *jsonlEncoder) Write(cell *storage.DataCell) (int, error) {
+	b, err := json.Marshal(cell.Data)
+	if err != nil {
+		return 0, err
+	}
+	return e.stream.Write(append(b, '\n'))
+}
+
+// csvEncoder 第一行为表头，之后每行一条数据
+type csvEncoder struct {
+	*stream
+	w      *csv.Writer
+	fields []string
+}
+
+func newCSVEncoder(s *stream, fields []string) (*csvEncoder, error) {
+	e := &csvEncoder{stream: s, w: csv.NewWriter(s), fields: fields}
+	if err := e.w.Write(fields); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *csvEncoder) Write(cell *storage.DataCell) (int, error) {
+	record := row(cell, e.fields)
+	n := 0
+	values := make([]string, len(record))
+	for i, v := range record {
+		if v != nil {
+			values[i] = *v
+			n += len(*v)
+		}
+	}
+	return n, e.w.Write(values)
+}
+
+func (e *csvEncoder) Flush() error {
+	e.w.Flush()
+	if err := e.w.Error(); err != nil {
+		return err
+	}
+	return e.stream.Flush()
+}
+
+func (e *csvEncoder) Close() error {
+	e.w.Flush()
+	if err := e.w.Error(); err != nil {
+		return err
+	}
+	return e.stream.Close()
+}
+
+// parquetEncoder 所有字段都是可为空的 UTF-8 字符串
+type parquetEncoder struct {
+	w      *writer.CSVWriter
+	fields []string
+}
+
+func newParquetEncoder(w io.Writer, fields []string, compress bool) (*parquetEncoder, error) {
+	md := make([]string, 0, len(fields))
+	for _, f := range fields {
+		// 字段名会作为 Parquet 的标签解析，不能包含逗号与等号
+		if strings.ContainsAny(f, ",=") {
+			return nil, fmt.Errorf("invalid parquet field name %q", f)
+		}
+		md = append(md, "name="+f+", type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL")
+	}
+	pw, err := writer.NewCSVWriterFromWriter(md, w, 1)
+	if err != nil {
+		return nil, err
+	}
+	if compress {
+		pw.CompressionType = parquet.CompressionCodec_GZIP
+	}
+	return &parquetEncoder{w: pw, fields: fields}, nil
+}
+
+func (e *parquetEncoder) Write(cell *storage.DataCell) (int, error) {
+	record := row(cell, e.fields)
+	n := 0
+	for _, v := range record {
+		if v != nil {
+			n += len(*v)
+		}
+	}
+	return n, e.w.WriteString(record)
+}
+
+func (e *parquetEncoder) Flush() error {
+	return e.w.Flush(true)
+}
+
+func (e *parquetEncoder) Close() error {
+	return e.w.WriteStop()
+}
+
+// row 按字段顺序取出数据的值，数据中没有的字段为 nil，非字符串的值序列化为 JSON
+func row(cell *storage.DataCell, fields []string) []*string {
+	data := cell.GetData()
+	record := make([]*string, len(fields))
+	for i, f := range fields {
+		var v interface{}
+		switch f {
+		case "Url", "Time":
+			v = cell.Data[f]
+		default:
+			v = data[f]
+		}
+		switch v := v.(type) {
+		case nil:
+		case string:
+			record[i] = &v
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				continue
+			}
+			s := string(b)
+			record[i] = &s
+		}
+	}
+	return record
+}
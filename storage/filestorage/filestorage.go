@@ -0,0 +1,278 @@
+package filestorage
+
+/** 本模块将数据写入文件，支持 JSON Lines、CSV 与 Parquet 格式。
+**	每个任务写入单独的文件，文件超过指定大小或写入时间后轮转，超过写入时间的文件即使没有新数据也由定时器完成轮转。
+**	正在写入的文件以 .open 结尾，轮转或关闭时去掉后缀，因此没有后缀的文件都是完整的
+ */
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/engine"
+	"github.com/Nrich-sunny/crawler/storage"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	FormatJSONL   = "jsonl"
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+const openSuffix = ".open" // 正在写入的文件的后缀，轮转时去掉
+
+var ErrClosed = errors.New("file storage is closed")
+
+// FileStorage : storage.Storage 的实现，可以被多个任务并发使用
+type FileStorage struct {
+	mu     sync.Mutex
+	files  map[string]*file // 任务名 -> 正在写入的文件
+	serial int              // 文件序号
+	closed bool
+	err    error // 定时轮转时的错误，由下一次 Flush 返回
+	options
+}
+
+// file 正在写入的文件
+type file struct {
+	name    string   // 完成后的文件名
+	fields  []string // 创建文件时任务的字段，CSV 与 Parquet 的表头
+	f       *os.File
+	enc     encoder
+	size    int64 // 已写入的字节数(压缩前)
+	created time.Time
+	timer   *time.Timer // 超过写入时间后轮转
+}
+
+func New(opts ...Option) (*FileStorage, error) {
+	options := defaultOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	switch options.format {
+	case FormatJSONL, FormatCSV, FormatParquet:
+	default:
+		return nil, fmt.Errorf("unsupported file format %q", options.format)
+	}
+	s := &FileStorage{}
+	s.options = options
+	s.files = make(map[string]*file)
+	return s, nil
+}
+
+// Save 将数据写入任务对应的文件，返回第一个错误
+func (s *FileStorage) Save(dataCells ...*storage.DataCell) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	var err error
+	for _, cell := range dataCells {
+		if e := s.write(cell); e != nil {
+			s.logger.Error("write file failed", zap.String("task", cell.GetTaskName()), zap.Error(e))
+			if err == nil {
+				err = fmt.Errorf("write data of %s failed:%w", cell.GetTaskName(), e)
+			}
+		}
+	}
+	return err
+}
+
+func (s *FileStorage) write(cell *storage.DataCell) error {
+	task := cell.GetTaskName()
+	fields := taskFields(cell)
+	f := s.files[task]
+	if f != nil && s.expired(f, fields) {
+		delete(s.files, task)
+		if err := s.finish(f); err != nil {
+			return err
+		}
+		f = nil
+	}
+	if f == nil {
+		var err error
+		if f, err = s.create(task, fields); err != nil {
+			return err
+		}
+		s.files[task] = f
+	}
+	n, err := f.enc.Write(cell)
+	f.size += int64(n)
+	return err
+}
+
+// expired 文件超过大小或写入时间，或者任务的字段发生变化时需要轮转
+func (s *FileStorage) expired(f *file, fields []string) bool {
+	if s.maxSize > 0 && f.size >= s.maxSize {
+		return true
+	}
+	if s.maxAge > 0 && time.Since(f.created) >= s.maxAge {
+		return true
+	}
+	// CSV 与 Parquet 的表头在创建文件时确定，动态加载的规则新增字段后写入新的文件
+	return s.format != FormatJSONL && !equal(f.fields, fields)
+}
+
+func (s *FileStorage) create(task string, fields []string) (*file, error) {
+	dir := strings.ReplaceAll(s.dir, "{task}", safeName(task))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s.serial++
+	name := fmt.Sprintf("%s-%s-%05d%s", safeName(task), time.Now().UTC().Format("20060102150405"), s.serial, s.suffix())
+	name = filepath.Join(dir, name)
+	f, err := os.OpenFile(name+openSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := newEncoder(s.format, f, fields, s.gzip)
+	if err != nil {
+		f.Close()
+		os.Remove(name + openSuffix)
+		return nil, err
+	}
+	s.logger.Debug("create file", zap.String("file", name))
+	nf := &file{name: name, fields: fields, f: f, enc: enc, created: time.Now()}
+	if s.maxAge > 0 {
+		nf.timer = time.AfterFunc(s.maxAge, func() { s.rotateOnTimer(task, nf) })
+	}
+	return nf, nil
+}
+
+// rotateOnTimer 文件超过写入时间后完成轮转，文件已经被轮转时什么也不做
+func (s *FileStorage) rotateOnTimer(task string, f *file) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.files[task] != f {
+		return
+	}
+	delete(s.files, task)
+	if err := s.finish(f); err != nil {
+		s.logger.Error("rotate file failed", zap.String("task", task), zap.Error(err))
+		s.err = err
+	}
+}
+
+func (s *FileStorage) suffix() string {
+	suffix := "." + s.format
+	if s.gzip && s.format != FormatParquet {
+		suffix += ".gz"
+	}
+	return suffix
+}
+
+// finish 写入文件的结尾并关闭文件，去掉 .open 后缀
+func (s *FileStorage) finish(f *file) error {
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	err := f.enc.Close()
+	if e := f.f.Close(); e != nil && err == nil {
+		err = e
+	}
+	if err != nil {
+		return fmt.Errorf("close file %s failed:%w", f.name, err)
+	}
+	s.logger.Debug("finish file", zap.String("file", f.name), zap.Int64("size", f.size))
+	return os.Rename(f.name+openSuffix, f.name)
+}
+
+// Flush 将缓存的数据写入文件，超过写入时间的文件同时完成轮转
+func (s *FileStorage) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.err
+	s.err = nil
+	for task, f := range s.files {
+		var e error
+		if s.maxAge > 0 && time.Since(f.created) >= s.maxAge {
+			delete(s.files, task)
+			e = s.finish(f)
+		} else {
+			e = f.enc.Flush()
+		}
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Close 完成所有正在写入的文件
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var err error
+	for task, f := range s.files {
+		delete(s.files, task)
+		if e := s.finish(f); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// taskFields 任务中所有规则输出的字段，与 sqlstorage 的表字段相同
+func taskFields(cell *storage.DataCell) []string {
+	var fields []string
+	seen := make(map[string]bool)
+	add := func(f string) {
+		if !seen[f] {
+			seen[f] = true
+			fields = append(fields, f)
+		}
+	}
+	if task, ok := engine.Store.Get(cell.GetTaskName()); ok {
+		names := make([]string, 0, len(task.Rule.Trunk))
+		for name := range task.Rule.Trunk {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, f := range task.Rule.Trunk[name].ItemFields {
+				add(f)
+			}
+		}
+	} else {
+		data := cell.GetData()
+		keys := make([]string, 0, len(data))
+		for k := range data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			add(k)
+		}
+	}
+	add("Url")
+	add("Time")
+	return fields
+}
+
+func equal(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// safeName 任务名中的路径分隔符替换为下划线
+func safeName(name string) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+}
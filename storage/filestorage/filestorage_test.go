@@ -0,0 +1,153 @@
+package filestorage_test
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/engine"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/Nrich-sunny/crawler/storage/filestorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func init() {
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{Trunk: map[string]*collect.Rule{
+			"书籍简介": {ItemFields: []string{"书名", "评分"}},
+		}},
+		Options: collect.Options{Name: "file_book"},
+	})
+}
+
+func book(title string, score interface{}) *storage.DataCell {
+	return &storage.DataCell{Data: map[string]interface{}{
+		"Task": "file_book",
+		"Rule": "书籍简介",
+		"Url":  "https://book.douban.com/subject/1/",
+		"Time": "2022-11-20 10:00:00",
+		"Data": map[string]interface{}{"书名": title, "评分": score},
+	}}
+}
+
+func glob(t *testing.T, pattern string) []string {
+	files, err := filepath.Glob(pattern)
+	require.NoError(t, err)
+	return files
+}
+
+func TestJSONLRotate(t *testing.T) {
+	dir := t.TempDir()
+	s, err := filestorage.New(
+		filestorage.WithDir(filepath.Join(dir, "{task}")),
+		filestorage.WithMaxSize(1),
+		filestorage.WithGzip(true),
+	)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(book("三体", 9.3), book("活着", 9.4)))
+	require.NoError(t, s.Flush())
+	// 写入中的文件带有 .open 后缀
+	assert.Len(t, glob(t, filepath.Join(dir, "file_book", "*.jsonl.gz")), 1)
+	assert.Len(t, glob(t, filepath.Join(dir, "file_book", "*.open")), 1)
+	require.NoError(t, s.Close())
+	assert.Empty(t, glob(t, filepath.Join(dir, "file_book", "*.open")))
+	assert.Equal(t, filestorage.ErrClosed, s.Save(book("围城", 8.9)))
+
+	// 超过 MaxSize 后轮转，每条数据写入一个新文件
+	files := glob(t, filepath.Join(dir, "file_book", "file_book-*.jsonl.gz"))
+	require.Len(t, files, 2)
+	var titles []string
+	for _, name := range files {
+		f, err := os.Open(name)
+		require.NoError(t, err)
+		zr, err := gzip.NewReader(f)
+		require.NoError(t, err)
+		scanner := bufio.NewScanner(zr)
+		for scanner.Scan() {
+			var line struct {
+				Rule string
+				Data map[string]interface{}
+			}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+			assert.Equal(t, "书籍简介", line.Rule)
+			titles = append(titles, line.Data["书名"].(string))
+		}
+		require.NoError(t, scanner.Err())
+		f.Close()
+	}
+	assert.ElementsMatch(t, []string{"三体", "活着"}, titles)
+}
+
+func TestMaxAge(t *testing.T) {
+	dir := t.TempDir()
+	s, err := filestorage.New(filestorage.WithDir(dir), filestorage.WithMaxAge(30*time.Millisecond))
+	require.NoError(t, err)
+	require.NoError(t, s.Save(book("三体", 9.3)))
+	// 超过写入时间后，没有新的数据也会完成轮转
+	require.Eventually(t, func() bool {
+		return len(glob(t, filepath.Join(dir, "*.open"))) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, glob(t, filepath.Join(dir, "*.jsonl")), 1)
+
+	require.NoError(t, s.Save(book("活着", 9.4)))
+	require.NoError(t, s.Close())
+	assert.Len(t, glob(t, filepath.Join(dir, "*.jsonl")), 2)
+}
+
+func TestCSV(t *testing.T) {
+	dir := t.TempDir()
+	s, err := filestorage.New(filestorage.WithFormat(filestorage.FormatCSV), filestorage.WithDir(dir))
+	require.NoError(t, err)
+	require.NoError(t, s.Save(book("三体", 9.3), book("活着, 余华", nil)))
+	require.NoError(t, s.Close())
+
+	files := glob(t, filepath.Join(dir, "*.csv"))
+	require.Len(t, files, 1)
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"书名", "评分", "Url", "Time"},
+		{"三体", "9.3", "https://book.douban.com/subject/1/", "2022-11-20 10:00:00"},
+		{"活着, 余华", "", "https://book.douban.com/subject/1/", "2022-11-20 10:00:00"},
+	}, records)
+}
+
+func TestParquet(t *testing.T) {
+	dir := t.TempDir()
+	s, err := filestorage.New(filestorage.WithFormat(filestorage.FormatParquet), filestorage.WithDir(dir), filestorage.WithGzip(true))
+	require.NoError(t, err)
+	require.NoError(t, s.Save(book("三体", 9.3), book("活着", nil)))
+	require.NoError(t, s.Close())
+
+	files := glob(t, filepath.Join(dir, "*.parquet"))
+	require.Len(t, files, 1)
+	fr, err := local.NewLocalFileReader(files[0])
+	require.NoError(t, err)
+	defer fr.Close()
+	pr, err := reader.NewParquetReader(fr, nil, 1)
+	require.NoError(t, err)
+	defer pr.ReadStop()
+	assert.Equal(t, int64(2), pr.GetNumRows())
+	var names []string
+	for _, info := range pr.SchemaHandler.Infos[1:] {
+		names = append(names, info.ExName)
+	}
+	assert.Equal(t, []string{"书名", "评分", "Url", "Time"}, names)
+	titles, _, _, err := pr.ReadColumnByIndex(0, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"三体", "活着"}, titles)
+	scores, _, _, err := pr.ReadColumnByIndex(1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{"9.3", nil}, scores)
+}
@@ -0,0 +1,60 @@
+package filestorage
+
+import (
+	"go.uber.org/zap"
+	"time"
+)
+
+type options struct {
+	logger  *zap.Logger
+	format  string        // 文件格式
+	dir     string        // 输出目录，{task} 替换为任务名
+	maxSize int64         // 单个文件的最大字节数(压缩前)，超过后轮转，0 表示不按大小轮转
+	maxAge  time.Duration // 单个文件的最长写入时间，超过后轮转，0 表示不按时间轮转
+	gzip    bool          // JSON Lines 与 CSV 文件使用 gzip 压缩，Parquet 文件使用 GZIP 编码压缩数据页
+}
+
+var defaultOptions = options{
+	logger:  zap.NewNop(),
+	format:  FormatJSONL,
+	dir:     "data/{task}",
+	maxSize: 128 << 20,
+}
+
+type Option func(opts *options)
+
+func WithLogger(logger *zap.Logger) Option {
+	return func(opts *options) {
+		opts.logger = logger
+	}
+}
+
+func WithFormat(format string) Option {
+	return func(opts *options) {
+		opts.format = format
+	}
+}
+
+func WithDir(dir string) Option {
+	return func(opts *options) {
+		opts.dir = dir
+	}
+}
+
+func WithMaxSize(maxSize int64) Option {
+	return func(opts *options) {
+		opts.maxSize = maxSize
+	}
+}
+
+func WithMaxAge(maxAge time.Duration) Option {
+	return func(opts *options) {
+		opts.maxAge = maxAge
+	}
+}
+
+func WithGzip(gzip bool) Option {
+	return func(opts *options) {
+		opts.gzip = gzip
+	}
+}
@@ -0,0 +1,327 @@
+package natsstorage
+
+/** 本模块将数据发布到 NATS JetStream，供下游的消费者订阅。
+**	每个任务发布到单独的主题，可以按网址的哈希分区，同一网址的数据总是发布到同一个分区。
+**	消息异步发布，等待确认的消息达到 BatchCount 条或 Flush 时等待 JetStream 的确认，
+**	一批消息共用一个确认期限，没有确认的消息在释放锁后重新发布
+ */
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EncodingJSON     = "json"
+	EncodingProtobuf = "protobuf" // google.protobuf.Struct
+)
+
+// 消息头
+const (
+	HeaderTask        = "Crawler-Task"
+	HeaderRule        = "Crawler-Rule"
+	HeaderUrl         = "Crawler-Url"
+	HeaderContentType = "Content-Type"
+)
+
+var ErrClosed = errors.New("nats storage is closed")
+
+// NatsStorage : storage.Storage 的实现，可以被多个任务并发使用
+type NatsStorage struct {
+	mu      sync.Mutex
+	nc      *nats.Conn
+	js      nats.JetStreamContext
+	pending []published // 等待确认的消息
+	closed  bool
+	options
+}
+
+func New(opts ...Option) (*NatsStorage, error) {
+	options := defaultOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	switch options.encoding {
+	case EncodingJSON, EncodingProtobuf:
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", options.encoding)
+	}
+	s := &NatsStorage{}
+	s.options = options
+	s.nc = options.conn
+	if s.nc == nil {
+		nc, err := nats.Connect(s.url, nats.Name("crawler"))
+		if err != nil {
+			return nil, fmt.Errorf("connect nats failed:%w", err)
+		}
+		s.nc = nc
+	}
+	js, err := s.nc.JetStream()
+	if err != nil {
+		s.closeConn()
+		return nil, err
+	}
+	s.js = js
+	if s.stream != "" {
+		if err := s.ensureStream(); err != nil {
+			s.closeConn()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ensureStream 流不存在时创建
+func (s *NatsStorage) ensureStream() error {
+	if _, err := s.js.StreamInfo(s.stream); err == nil {
+		return nil
+	} else if !errors.Is(err, nats.ErrStreamNotFound) {
+		return err
+	}
+	_, err := s.js.AddStream(&nats.StreamConfig{
+		Name:     s.stream,
+		Subjects: []string{s.subject + ".>"},
+	})
+	if err != nil {
+		return fmt.Errorf("create stream %s failed:%w", s.stream, err)
+	}
+	s.logger.Info("create stream", zap.String("stream", s.stream), zap.String("subject", s.subject))
+	return nil
+}
+
+// published 已发布的消息及其数据
+type published struct {
+	msg  *nats.Msg
+	cell *storage.DataCell
+	f    nats.PubAckFuture
+}
+
+// Save 异步发布数据，等待确认的消息过多时等待确认。出错时返回 *storage.SaveError，
+// 其中包含发布失败与没有确认的数据，包括之前保存的数据
+func (s *NatsStorage) Save(dataCells ...*storage.DataCell) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	var err, ackErr error
+	var unsaved []*storage.DataCell
+	var failed []published
+	for _, cell := range dataCells {
+		msg, e := s.message(cell)
+		if e == nil {
+			var f nats.PubAckFuture
+			if f, e = s.js.PublishMsgAsync(msg); e == nil {
+				s.pending = append(s.pending, published{msg: msg, cell: cell, f: f})
+			}
+		}
+		if e != nil {
+			s.logger.Error("publish item failed", zap.String("task", cell.GetTaskName()), zap.Error(e))
+			unsaved = append(unsaved, cell)
+			if err == nil {
+				err = fmt.Errorf("publish item of %s failed:%w", cell.GetTaskName(), e)
+			}
+			continue
+		}
+		if len(s.pending) >= s.BatchCount {
+			ps, e := s.wait()
+			failed = append(failed, ps...)
+			if ackErr == nil {
+				ackErr = e
+			}
+		}
+	}
+	s.mu.Unlock()
+	lost, e := s.retry(failed, ackErr)
+	unsaved = append(unsaved, lost...)
+	if err == nil {
+		err = e
+	}
+	return saveError(unsaved, err)
+}
+
+// saveError 没有错误时返回 nil，否则返回包含没有保存的数据的 *storage.SaveError
+func saveError(cells []*storage.DataCell, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &storage.SaveError{Cells: cells, Err: err}
+}
+
+// Subject 数据发布的主题
+func (s *NatsStorage) Subject(cell *storage.DataCell) string {
+	subject := s.subject + "." + token(cell.GetTaskName())
+	if s.partitions > 0 {
+		h := fnv.New32a()
+		h.Write([]byte(cell.GetUrl()))
+		subject += "." + strconv.Itoa(int(h.Sum32()%uint32(s.partitions)))
+	}
+	return subject
+}
+
+func (s *NatsStorage) message(cell *storage.DataCell) (*nats.Msg, error) {
+	msg := nats.NewMsg(s.Subject(cell))
+	var err error
+	switch s.encoding {
+	case EncodingProtobuf:
+		msg.Data, err = encodeProto(cell)
+		msg.Header.Set(HeaderContentType, "application/protobuf")
+	default:
+		msg.Data, err = json.Marshal(cell.Data)
+		msg.Header.Set(HeaderContentType, "application/json")
+	}
+	if err != nil {
+		return nil, err
+	}
+	msg.Header.Set(HeaderTask, cell.GetTaskName())
+	msg.Header.Set(HeaderRule, cell.GetRuleName())
+	msg.Header.Set(HeaderUrl, cell.GetUrl())
+	// 重试时 JetStream 按消息 ID 去重，避免确认超时但已保存的消息被保存两次
+	sum := sha1.Sum(append([]byte(msg.Subject+"\n"), msg.Data...))
+	msg.Header.Set(nats.MsgIdHdr, hex.EncodeToString(sum[:]))
+	return msg, nil
+}
+
+// encodeProto 将数据编码为 google.protobuf.Struct，
+// structpb 不支持的类型先经过 JSON 转换
+func encodeProto(cell *storage.DataCell) ([]byte, error) {
+	st, err := structpb.NewStruct(cell.Data)
+	if err != nil {
+		b, err := json.Marshal(cell.Data)
+		if err != nil {
+			return nil, err
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		if st, err = structpb.NewStruct(m); err != nil {
+			return nil, err
+		}
+	}
+	return proto.Marshal(st)
+}
+
+// wait 在同一个期限内等待所有消息的确认，返回没有确认的消息与第一个错误，调用时需持有 s.mu
+func (s *NatsStorage) wait() ([]published, error) {
+	pending := s.pending
+	s.pending = nil
+	return s.await(pending)
+}
+
+// await 等待一批消息的确认，所有消息共用 AckTimeout 的期限
+func (s *NatsStorage) await(ps []published) ([]published, error) {
+	if len(ps) == 0 {
+		return nil, nil
+	}
+	timer := time.NewTimer(s.AckTimeout)
+	defer timer.Stop()
+	select {
+	case <-s.js.PublishAsyncComplete():
+	case <-timer.C:
+	}
+	var failed []published
+	var err error
+	for _, p := range ps {
+		select {
+		case <-p.f.Ok():
+			continue
+		case e := <-p.f.Err():
+			if err == nil {
+				err = e
+			}
+		default:
+			if err == nil {
+				err = nats.ErrTimeout
+			}
+		}
+		failed = append(failed, p)
+	}
+	return failed, err
+}
+
+// retry 重新发布没有确认的消息，最多 Retries 轮，每一轮共用一个确认期限，返回仍然没有确认的数据。
+// 调用时不持有 s.mu，重试期间其他任务可以继续保存。JetStream 按消息 ID 去重
+func (s *NatsStorage) retry(ps []published, err error) ([]*storage.DataCell, error) {
+	total := len(ps)
+	for i := 0; i < s.Retries && len(ps) > 0; i++ {
+		var retried, unpublished []published
+		for _, p := range ps {
+			f, e := s.js.PublishMsgAsync(p.msg)
+			if e != nil {
+				err = e
+				unpublished = append(unpublished, p)
+				continue
+			}
+			retried = append(retried, published{msg: p.msg, cell: p.cell, f: f})
+		}
+		failed, e := s.await(retried)
+		if e != nil {
+			err = e
+		}
+		ps = append(unpublished, failed...)
+	}
+	if len(ps) == 0 {
+		return nil, nil
+	}
+	cells := make([]*storage.DataCell, 0, len(ps))
+	for _, p := range ps {
+		s.logger.Error("item not acknowledged", zap.String("subject", p.msg.Subject), zap.Error(err))
+		cells = append(cells, p.cell)
+	}
+	return cells, fmt.Errorf("%d of %d items not acknowledged:%w", len(ps), total, err)
+}
+
+// Flush 等待所有已发布消息的确认，出错时返回 *storage.SaveError
+func (s *NatsStorage) Flush() error {
+	s.mu.Lock()
+	failed, err := s.wait()
+	s.mu.Unlock()
+	return saveError(s.retry(failed, err))
+}
+
+// Close 等待所有消息的确认并关闭连接，之后不能再保存数据
+func (s *NatsStorage) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	failed, err := s.wait()
+	s.mu.Unlock()
+	err = saveError(s.retry(failed, err))
+	s.closeConn()
+	return err
+}
+
+// closeConn 只关闭自己创建的连接
+func (s *NatsStorage) closeConn() {
+	if s.conn == nil {
+		s.nc.Close()
+	}
+}
+
+// token 主题中的一段，不能包含分隔符、通配符与空白字符
+func token(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, name)
+}
@@ -0,0 +1,152 @@
+package natsstorage_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/Nrich-sunny/crawler/storage/natsstorage"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"testing"
+	"time"
+)
+
+// runServer 启动开启了 JetStream 的内嵌 NATS 服务
+func runServer(t *testing.T) *server.Server {
+	ns, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  t.TempDir(),
+	})
+	require.NoError(t, err)
+	go ns.Start()
+	require.True(t, ns.ReadyForConnections(5*time.Second))
+	t.Cleanup(ns.Shutdown)
+	return ns
+}
+
+func book(url string, title string) *storage.DataCell {
+	return &storage.DataCell{Data: map[string]interface{}{
+		"Task": "douban_book_list",
+		"Rule": "书籍简介",
+		"Url":  url,
+		"Time": "2022-11-20 10:00:00",
+		"Data": map[string]interface{}{"书名": title, "页数": 302},
+	}}
+}
+
+func TestNatsStorage(t *testing.T) {
+	ns := runServer(t)
+	s, err := natsstorage.New(
+		natsstorage.WithUrl(ns.ClientURL()),
+		natsstorage.WithStream("CRAWLER"),
+		natsstorage.WithPartitions(4),
+		natsstorage.WithBatchCount(2),
+	)
+	require.NoError(t, err)
+	cells := []*storage.DataCell{
+		book("https://book.douban.com/subject/1/", "三体"),
+		book("https://book.douban.com/subject/2/", "活着"),
+		book("https://book.douban.com/subject/1/", "三体"), // 重复的消息被去重
+	}
+	require.NoError(t, s.Save(cells...))
+	require.NoError(t, s.Close())
+	assert.Equal(t, natsstorage.ErrClosed, s.Save(cells[0]))
+
+	nc, err := nats.Connect(ns.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	info, err := js.StreamInfo("CRAWLER")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), info.State.Msgs)
+
+	sub, err := js.SubscribeSync("crawler.items.douban_book_list.*", nats.DeliverAll())
+	require.NoError(t, err)
+	for _, cell := range cells[:2] {
+		msg, err := sub.NextMsg(time.Second)
+		require.NoError(t, err)
+		// 同一网址总是发布到同一个分区
+		assert.Equal(t, s.Subject(cell), msg.Subject)
+		assert.Equal(t, cell.Data["Url"], msg.Header.Get(natsstorage.HeaderUrl))
+		assert.Equal(t, "书籍简介", msg.Header.Get(natsstorage.HeaderRule))
+		var data map[string]interface{}
+		require.NoError(t, json.Unmarshal(msg.Data, &data))
+		assert.Equal(t, cell.Data["Data"].(map[string]interface{})["书名"], data["Data"].(map[string]interface{})["书名"])
+	}
+}
+
+func TestNatsStorageProtobuf(t *testing.T) {
+	ns := runServer(t)
+	nc, err := nats.Connect(ns.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+	s, err := natsstorage.New(
+		natsstorage.WithConn(nc),
+		natsstorage.WithStream("ITEMS"),
+		natsstorage.WithSubject("items"),
+		natsstorage.WithEncoding(natsstorage.EncodingProtobuf),
+	)
+	require.NoError(t, err)
+	cell := book("https://book.douban.com/subject/1/", "三体")
+	cell.Data["Data"].(map[string]interface{})["标签"] = []string{"科幻", "小说"}
+	require.NoError(t, s.Save(cell))
+	require.NoError(t, s.Flush())
+	require.NoError(t, s.Close())
+	// 使用外部传入的连接时不关闭连接
+	assert.False(t, nc.IsClosed())
+
+	js, err := nc.JetStream()
+	require.NoError(t, err)
+	sub, err := js.SubscribeSync("items.douban_book_list", nats.DeliverAll())
+	require.NoError(t, err)
+	msg, err := sub.NextMsg(time.Second)
+	require.NoError(t, err)
+	var st structpb.Struct
+	require.NoError(t, proto.Unmarshal(msg.Data, &st))
+	data := st.Fields["Data"].GetStructValue().AsMap()
+	assert.Equal(t, "三体", data["书名"])
+	assert.Equal(t, float64(302), data["页数"])
+	assert.Equal(t, []interface{}{"科幻", "小说"}, data["标签"])
+}
+
+func TestNatsStorageAckTimeout(t *testing.T) {
+	ns := runServer(t)
+	// 订阅者收到消息但不回复确认
+	nc, err := nats.Connect(ns.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+	sub, err := nc.SubscribeSync("crawler.items.>")
+	require.NoError(t, err)
+	defer sub.Unsubscribe()
+	require.NoError(t, nc.Flush())
+
+	s, err := natsstorage.New(
+		natsstorage.WithUrl(ns.ClientURL()),
+		natsstorage.WithBatchCount(100),
+		natsstorage.WithAckTimeout(100*time.Millisecond),
+		natsstorage.WithRetries(2),
+	)
+	require.NoError(t, err)
+	for i := 0; i < 20; i++ {
+		require.NoError(t, s.Save(book(fmt.Sprintf("https://book.douban.com/subject/%d/", i), "三体")))
+	}
+
+	// 一批消息共用一个确认期限，而不是每条消息分别等待
+	start := time.Now()
+	err = s.Flush()
+	assert.Less(t, time.Since(start), 2*time.Second)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "20 of 20 items not acknowledged")
+	assert.ErrorIs(t, err, nats.ErrTimeout)
+	cells, ok := storage.FailedCells(err)
+	require.True(t, ok)
+	assert.Len(t, cells, 20)
+	require.NoError(t, s.Close())
+}
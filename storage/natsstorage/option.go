@@ -0,0 +1,92 @@
+package natsstorage
+
+import (
+	"github.com/nats-io/nats.go"
+	"go.uber.org/zap"
+	"time"
+)
+
+type options struct {
+	logger     *zap.Logger
+	url        string
+	conn       *nats.Conn    // 不为空时不再根据 url 连接 NATS，Close 时也不关闭连接
+	subject    string        // 主题前缀，数据发布到 {subject}.{task}
+	stream     string        // 不为空时创建 JetStream 流，保存前缀下的所有主题
+	encoding   string        // 消息编码
+	partitions int           // 按网址哈希分区的数量，主题为 {subject}.{task}.{partition}，0 表示不分区
+	BatchCount int           // 等待确认的消息达到该数量时等待确认
+	AckTimeout time.Duration // 等待一批消息确认的最长时间
+	Retries    int           // 没有确认的消息重新发布的轮数
+}
+
+var defaultOptions = options{
+	logger:     zap.NewNop(),
+	url:        nats.DefaultURL,
+	subject:    "crawler.items",
+	encoding:   EncodingJSON,
+	BatchCount: 256,
+	AckTimeout: 5 * time.Second,
+	Retries:    2,
+}
+
+type Option func(opts *options)
+
+func WithLogger(logger *zap.Logger) Option {
+	return func(opts *options) {
+		opts.logger = logger
+	}
+}
+
+func WithUrl(url string) Option {
+	return func(opts *options) {
+		opts.url = url
+	}
+}
+
+func WithConn(conn *nats.Conn) Option {
+	return func(opts *options) {
+		opts.conn = conn
+	}
+}
+
+func WithSubject(subject string) Option {
+	return func(opts *options) {
+		opts.subject = subject
+	}
+}
+
+func WithStream(stream string) Option {
+	return func(opts *options) {
+		opts.stream = stream
+	}
+}
+
+func WithEncoding(encoding string) Option {
+	return func(opts *options) {
+		opts.encoding = encoding
+	}
+}
+
+func WithPartitions(partitions int) Option {
+	return func(opts *options) {
+		opts.partitions = partitions
+	}
+}
+
+func WithBatchCount(batchCount int) Option {
+	return func(opts *options) {
+		opts.BatchCount = batchCount
+	}
+}
+
+func WithAckTimeout(d time.Duration) Option {
+	return func(opts *options) {
+		opts.AckTimeout = d
+	}
+}
+
+func WithRetries(retries int) Option {
+	return func(opts *options) {
+		opts.Retries = retries
+	}
+}
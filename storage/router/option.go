@@ -0,0 +1,54 @@
+package router
+
+import (
+	"go.uber.org/zap"
+	"time"
+)
+
+type options struct {
+	logger        *zap.Logger
+	spoolDir      string        // 写入失败的数据保存的目录，为空时只记录日志
+	QueueSize     int           // 每个存储的缓冲队列长度，队列满时数据直接写入 spool
+	RetryInterval time.Duration // 重新写入 spool 中数据的间隔
+	FlushTimeout  time.Duration // 等待单个存储写入缓存数据的最长时间
+}
+
+var defaultOptions = options{
+	logger:        zap.NewNop(),
+	spoolDir:      "spool",
+	QueueSize:     1024,
+	RetryInterval: 30 * time.Second,
+	FlushTimeout:  30 * time.Second,
+}
+
+type Option func(opts *options)
+
+func WithLogger(logger *zap.Logger) Option {
+	return func(opts *options) {
+		opts.logger = logger
+	}
+}
+
+func WithSpoolDir(dir string) Option {
+	return func(opts *options) {
+		opts.spoolDir = dir
+	}
+}
+
+func WithQueueSize(size int) Option {
+	return func(opts *options) {
+		opts.QueueSize = size
+	}
+}
+
+func WithRetryInterval(d time.Duration) Option {
+	return func(opts *options) {
+		opts.RetryInterval = d
+	}
+}
+
+func WithFlushTimeout(d time.Duration) Option {
+	return func(opts *options) {
+		opts.FlushTimeout = d
+	}
+}
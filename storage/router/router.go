@@ -0,0 +1,393 @@
+package router
+
+/** 本模块将数据分发到多个存储，每个任务可以选择写入哪些存储。
+**	每个存储有单独的缓冲队列与写入协程，一个存储写入缓慢或失败不会影响其他存储。
+**	写入失败的数据保存到本地的 spool 文件中，定时重新写入，因此数据至少写入一次。
+**	批量写入的存储通过 *storage.SaveError 报告没有写入的数据，只有这些数据写入 spool
+ */
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/storage"
+	"go.uber.org/zap"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultSink 没有指定存储的任务写入的存储名
+const DefaultSink = "default"
+
+var ErrClosed = errors.New("storage router is closed")
+
+// Router : storage.Storage 的实现，Save 写入默认的存储
+type Router struct {
+	mu     sync.RWMutex
+	sinks  map[string]*sink
+	closed bool
+	options
+}
+
+func New(opts ...Option) *Router {
+	options := defaultOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	r := &Router{}
+	r.options = options
+	r.sinks = make(map[string]*sink)
+	return r
+}
+
+// AddSink 添加存储并启动写入协程，spool 中上次运行遗留的数据会重新写入
+func (r *Router) AddSink(name string, s storage.Storage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+	if _, ok := r.sinks[name]; ok {
+		return fmt.Errorf("duplicate sink %q", name)
+	}
+	k := &sink{
+		name:    name,
+		s:       s,
+		ch:      make(chan op, r.QueueSize),
+		stopped: make(chan struct{}),
+		options: r.options,
+	}
+	if r.spoolDir != "" {
+		k.spool = newSpool(r.spoolDir, name)
+	}
+	r.sinks[name] = k
+	go k.run()
+	return nil
+}
+
+// Sinks 返回所有存储名
+func (r *Router) Sinks() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.sinks))
+	for name := range r.sinks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Route 返回写入指定存储的 storage.Storage，作为任务的存储使用
+func (r *Router) Route(names ...string) (*Route, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, name := range names {
+		if _, ok := r.sinks[name]; !ok {
+			return nil, fmt.Errorf("unknown sink %q", name)
+		}
+	}
+	return &Route{r: r, names: names}, nil
+}
+
+// Save 写入默认的存储
+func (r *Router) Save(dataCells ...*storage.DataCell) error {
+	return r.save([]string{DefaultSink}, dataCells)
+}
+
+// Flush 等待所有存储写入缓存的数据
+func (r *Router) Flush() error {
+	return r.flush(r.Sinks())
+}
+
+// Close 写入缓存的数据并关闭所有存储，spool 中的数据保留到下次运行
+func (r *Router) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	sinks := r.sinks
+	r.mu.Unlock()
+
+	var err error
+	for _, k := range sinks {
+		if e := k.close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// save 将数据放入存储的队列，队列满时写入 spool，不会阻塞
+func (r *Router) save(names []string, cells []*storage.DataCell) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return ErrClosed
+	}
+	var err error
+	for _, name := range names {
+		k, ok := r.sinks[name]
+		if !ok {
+			if err == nil {
+				err = fmt.Errorf("unknown sink %q", name)
+			}
+			continue
+		}
+		for _, cell := range cells {
+			select {
+			case k.ch <- op{cell: cell}:
+			default:
+				k.fail(errors.New("queue is full"), cell)
+			}
+		}
+	}
+	return err
+}
+
+func (r *Router) flush(names []string) error {
+	r.mu.RLock()
+	sinks := make([]*sink, 0, len(names))
+	for _, name := range names {
+		if k, ok := r.sinks[name]; ok {
+			sinks = append(sinks, k)
+		}
+	}
+	closed := r.closed
+	r.mu.RUnlock()
+	if closed {
+		return nil
+	}
+
+	// 所有存储同时写入，一个存储超时不影响其他存储
+	errs := make([]error, len(sinks))
+	var wg sync.WaitGroup
+	for i, k := range sinks {
+		wg.Add(1)
+		go func(i int, k *sink) {
+			defer wg.Done()
+			errs[i] = k.flush()
+		}(i, k)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Route 写入一组存储，Close 不关闭存储，存储由 Router 关闭
+type Route struct {
+	r     *Router
+	names []string
+}
+
+func (rt *Route) Save(dataCells ...*storage.DataCell) error {
+	return rt.r.save(rt.names, dataCells)
+}
+
+func (rt *Route) Flush() error {
+	return rt.r.flush(rt.names)
+}
+
+func (rt *Route) Close() error {
+	return rt.Flush()
+}
+
+// op 存储队列中的操作：写入数据或写入缓存
+type op struct {
+	cell  *storage.DataCell
+	flush chan error
+}
+
+// sink 一个存储及其缓冲队列
+type sink struct {
+	name    string
+	s       storage.Storage
+	ch      chan op
+	spool   *spool
+	stopped chan struct{}
+	options
+}
+
+func (k *sink) run() {
+	defer close(k.stopped)
+	ticker := time.NewTicker(k.RetryInterval)
+	defer ticker.Stop()
+	k.replay()
+	for {
+		select {
+		case o, ok := <-k.ch:
+			if !ok {
+				return
+			}
+			if o.flush != nil {
+				err := k.call(func() error { return k.s.Flush() })
+				if err != nil {
+					k.fail(err, failed(err)...)
+				}
+				o.flush <- err
+				continue
+			}
+			if err := k.call(func() error { return k.s.Save(o.cell) }); err != nil {
+				k.fail(err, failed(err, o.cell)...)
+			}
+		case <-ticker.C:
+			k.replay()
+		}
+	}
+}
+
+// call 调用存储的方法，存储 panic 时返回错误，不影响写入协程
+func (k *sink) call(f func() error) (err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = fmt.Errorf("sink %s panic: %v", k.name, e)
+		}
+	}()
+	return f()
+}
+
+// failed 返回没有写入的数据。存储通过 *storage.SaveError 报告时只返回报告的数据，
+// 其中可能包含之前缓存的数据；没有报告时认为 cells 都没有写入
+func failed(err error, cells ...*storage.DataCell) []*storage.DataCell {
+	if reported, ok := storage.FailedCells(err); ok {
+		return reported
+	}
+	return cells
+}
+
+// fail 记录写入失败的数据并保存到 spool
+func (k *sink) fail(err error, cells ...*storage.DataCell) {
+	if len(cells) == 0 {
+		return
+	}
+	var spoolErr error
+	if k.spool != nil {
+		spoolErr = k.spool.put(cells...)
+	}
+	for _, cell := range cells {
+		fields := []zap.Field{
+			zap.String("sink", k.name),
+			zap.String("task", cell.GetTaskName()),
+			zap.String("rule", cell.GetRuleName()),
+			zap.String("url", cell.GetUrl()),
+			zap.Error(err),
+		}
+		switch {
+		case k.spool == nil:
+			k.logger.Error("save data failed", fields...)
+		case spoolErr != nil:
+			k.logger.Error("save data failed and spool failed", append(fields, zap.NamedError("spoolError", spoolErr))...)
+		default:
+			k.logger.Warn("save data failed, spooled for retry", fields...)
+		}
+	}
+}
+
+// replay 重新写入 spool 中的数据，没有写入的数据放回 spool。
+// 放回失败时保留重新写入的文件，下一次全部重新写入
+func (k *sink) replay() {
+	if k.spool == nil {
+		return
+	}
+	cells, err := k.spool.take()
+	if err != nil {
+		k.logger.Error("read spool failed", zap.String("sink", k.name), zap.Error(err))
+		return
+	}
+	var lost []*storage.DataCell
+	if len(cells) > 0 {
+		lost, err = k.write(cells)
+	}
+	if len(lost) > 0 {
+		if e := k.spool.put(lost...); e != nil {
+			k.logger.Error("put back spool failed", zap.String("sink", k.name), zap.Int("count", len(lost)), zap.Error(e))
+			return
+		}
+	}
+	if e := k.spool.done(); e != nil {
+		k.logger.Error("remove replayed spool failed", zap.String("sink", k.name), zap.Error(e))
+	}
+	if len(cells) == 0 {
+		return
+	}
+	if err != nil {
+		k.logger.Warn("retry spooled data failed", zap.String("sink", k.name), zap.Int("count", len(lost)), zap.Error(err))
+		return
+	}
+	k.logger.Info("retry spooled data", zap.String("sink", k.name), zap.Int("count", len(cells)))
+}
+
+// write 写入数据并写入存储缓存的数据，返回没有写入的数据
+func (k *sink) write(cells []*storage.DataCell) (lost []*storage.DataCell, err error) {
+	reported := false
+	err = k.call(func() error {
+		saveErr := k.s.Save(cells...)
+		if saveErr != nil {
+			if _, ok := storage.FailedCells(saveErr); !ok {
+				return saveErr
+			}
+			lost = failed(saveErr)
+		}
+		flushErr := k.s.Flush()
+		if flushErr != nil {
+			if _, ok := storage.FailedCells(flushErr); !ok {
+				return flushErr
+			}
+			lost = append(lost, failed(flushErr)...)
+		}
+		reported = true
+		if saveErr != nil {
+			return saveErr
+		}
+		return flushErr
+	})
+	if err != nil && !reported {
+		lost = cells
+	}
+	return lost, err
+}
+
+// flush 等待队列中的数据写入存储，再写入存储缓存的数据
+func (k *sink) flush() error {
+	done := make(chan error, 1)
+	timer := time.NewTimer(k.FlushTimeout)
+	defer timer.Stop()
+	select {
+	case k.ch <- op{flush: done}:
+	case <-timer.C:
+		return fmt.Errorf("flush sink %s timeout", k.name)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("flush sink %s failed:%w", k.name, err)
+		}
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("flush sink %s timeout", k.name)
+	}
+}
+
+// close 写入队列中的数据后关闭存储
+func (k *sink) close() error {
+	err := k.flush()
+	close(k.ch)
+	select {
+	case <-k.stopped:
+	case <-time.After(k.FlushTimeout):
+		// 存储一直没有返回，不再等待
+		return fmt.Errorf("close sink %s timeout", k.name)
+	}
+	if e := k.s.Close(); e != nil {
+		k.fail(e, failed(e)...)
+		if err == nil {
+			err = fmt.Errorf("close sink %s failed:%w", k.name, e)
+		}
+	}
+	return err
+}
@@ -0,0 +1,230 @@
+package router_test
+
+import (
+	"bufio"
+	"errors"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/engine"
+	"github.com/Nrich-sunny/crawler/sqldb"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/Nrich-sunny/crawler/storage/natsstorage"
+	"github.com/Nrich-sunny/crawler/storage/router"
+	"github.com/Nrich-sunny/crawler/storage/sqlstorage"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStorage 保存在内存中的存储，failing 为 true 时写入失败
+type memStorage struct {
+	mu      sync.Mutex
+	failing bool
+	titles  []string
+	closed  bool
+}
+
+func (m *memStorage) Save(cells ...*storage.DataCell) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.failing {
+		return errors.New("connection refused")
+	}
+	for _, cell := range cells {
+		m.titles = append(m.titles, cell.Data["Data"].(map[string]interface{})["书名"].(string))
+	}
+	return nil
+}
+
+func (m *memStorage) Flush() error { return nil }
+
+func (m *memStorage) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (m *memStorage) setFailing(failing bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failing = failing
+}
+
+func (m *memStorage) Titles() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.titles...)
+}
+
+func book(task string, title string) *storage.DataCell {
+	return &storage.DataCell{Data: map[string]interface{}{
+		"Task": task,
+		"Rule": "书籍简介",
+		"Url":  "https://book.douban.com/subject/1/",
+		"Time": "2022-11-20 10:00:00",
+		"Data": map[string]interface{}{"书名": title},
+	}}
+}
+
+func TestRouter(t *testing.T) {
+	r := router.New(router.WithSpoolDir(t.TempDir()), router.WithRetryInterval(10*time.Millisecond))
+	sql, files := &memStorage{}, &memStorage{}
+	require.NoError(t, r.AddSink(router.DefaultSink, sql))
+	require.NoError(t, r.AddSink("files", files))
+	assert.Error(t, r.AddSink("files", files))
+	_, err := r.Route("kafka")
+	assert.Error(t, err)
+
+	both, err := r.Route(router.DefaultSink, "files")
+	require.NoError(t, err)
+	require.NoError(t, r.Save(book("movie", "霸王别姬")))
+	require.NoError(t, r.Flush())
+	// 一个存储失败不影响其他存储，失败的数据在存储恢复后重新写入
+	sql.setFailing(true)
+	require.NoError(t, both.Save(book("book", "三体"), book("book", "活着")))
+	require.NoError(t, both.Flush())
+	assert.Equal(t, []string{"三体", "活着"}, files.Titles())
+	assert.Equal(t, []string{"霸王别姬"}, sql.Titles())
+
+	sql.setFailing(false)
+	assert.Eventually(t, func() bool {
+		return len(sql.Titles()) == 3
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"霸王别姬", "三体", "活着"}, sql.Titles())
+
+	require.NoError(t, r.Close())
+	assert.True(t, sql.closed)
+	assert.True(t, files.closed)
+	assert.Equal(t, router.ErrClosed, both.Save(book("book", "围城")))
+}
+
+func TestRouterSpool(t *testing.T) {
+	dir := t.TempDir()
+	// 队列满时数据直接写入 spool，关闭时 spool 中的数据保留到下次运行
+	r := router.New(router.WithSpoolDir(dir), router.WithQueueSize(0), router.WithRetryInterval(time.Hour))
+	s := &memStorage{failing: true}
+	require.NoError(t, r.AddSink(router.DefaultSink, s))
+	require.NoError(t, r.Save(book("book", "三体")))
+	require.NoError(t, r.Close())
+	assert.Empty(t, s.Titles())
+	assert.FileExists(t, filepath.Join(dir, router.DefaultSink+".jsonl"))
+	// 模拟进程在重新写入时退出，重新写入的文件在下次运行时同样会写入
+	require.NoError(t, os.Rename(
+		filepath.Join(dir, router.DefaultSink+".jsonl"),
+		filepath.Join(dir, router.DefaultSink+".replay.jsonl"),
+	))
+
+	r = router.New(router.WithSpoolDir(dir))
+	s = &memStorage{}
+	require.NoError(t, r.AddSink(router.DefaultSink, s))
+	require.NoError(t, r.Close())
+	assert.Equal(t, []string{"三体"}, s.Titles())
+	assert.NoFileExists(t, filepath.Join(dir, router.DefaultSink+".jsonl"))
+	assert.NoFileExists(t, filepath.Join(dir, router.DefaultSink+".replay.jsonl"))
+}
+
+// fakeDB 记录写入的行数，failing 为 true 时写入失败
+type fakeDB struct {
+	mu      sync.Mutex
+	failing bool
+	rows    int
+}
+
+func (d *fakeDB) CreateTable(t sqldb.TableMetaData) error { return nil }
+
+func (d *fakeDB) Insert(t sqldb.TableMetaData) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failing {
+		return errors.New("connection refused")
+	}
+	d.rows += t.DataCount
+	return nil
+}
+
+func (d *fakeDB) Close() error { return nil }
+
+func (d *fakeDB) setFailing(failing bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failing = failing
+}
+
+func (d *fakeDB) Rows() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rows
+}
+
+func TestRouterBatchStorage(t *testing.T) {
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{Trunk: map[string]*collect.Rule{
+			"书籍简介": {ItemFields: []string{"书名"}},
+		}},
+		Options: collect.Options{Name: "router_book"},
+	})
+	db := &fakeDB{failing: true}
+	s, err := sqlstorage.New(sqlstorage.WithDB(db), sqlstorage.WithBatchCount(2), sqlstorage.WithFlushInterval(0))
+	require.NoError(t, err)
+	r := router.New(router.WithSpoolDir(t.TempDir()), router.WithRetryInterval(10*time.Millisecond))
+	require.NoError(t, r.AddSink(router.DefaultSink, s))
+
+	// 批量写入失败时整批数据写入 spool，Flush 失败的数据同样写入 spool
+	require.NoError(t, r.Save(book("router_book", "三体"), book("router_book", "活着"), book("router_book", "围城")))
+	assert.Error(t, r.Flush())
+	assert.Equal(t, 0, db.Rows())
+
+	// 恢复后每条数据只写入一次
+	db.setFailing(false)
+	assert.Eventually(t, func() bool {
+		return db.Rows() == 3
+	}, time.Second, 10*time.Millisecond)
+	require.NoError(t, r.Close())
+	assert.Equal(t, 3, db.Rows())
+}
+
+func TestRouterNatsAckTimeout(t *testing.T) {
+	ns, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1})
+	require.NoError(t, err)
+	go ns.Start()
+	require.True(t, ns.ReadyForConnections(5*time.Second))
+	defer ns.Shutdown()
+	// 订阅者收到消息但不回复确认
+	nc, err := nats.Connect(ns.ClientURL())
+	require.NoError(t, err)
+	defer nc.Close()
+	_, err = nc.SubscribeSync("crawler.items.>")
+	require.NoError(t, err)
+	require.NoError(t, nc.Flush())
+
+	s, err := natsstorage.New(
+		natsstorage.WithUrl(ns.ClientURL()),
+		natsstorage.WithBatchCount(2),
+		natsstorage.WithAckTimeout(50*time.Millisecond),
+		natsstorage.WithRetries(1),
+	)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	r := router.New(router.WithSpoolDir(dir), router.WithRetryInterval(time.Hour))
+	require.NoError(t, r.AddSink(router.DefaultSink, s))
+
+	// 等待确认时超时的数据与 Flush 时没有确认的数据都写入 spool
+	require.NoError(t, r.Save(book("book", "三体"), book("book", "活着"), book("book", "围城")))
+	assert.Error(t, r.Flush())
+	require.NoError(t, r.Close())
+
+	f, err := os.Open(filepath.Join(dir, router.DefaultSink+".jsonl"))
+	require.NoError(t, err)
+	defer f.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); {
+		lines++
+	}
+	assert.Equal(t, 3, lines)
+}
@@ -0,0 +1,99 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/Nrich-sunny/crawler/storage"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// spool 写入失败的数据，每行一条 JSON。
+// 重新写入时数字会变为 float64，存储需要按字段类型转换。
+// 重新写入前文件改名为 {name}.replay.jsonl，写入结束后才删除，进程在重新写入时退出不会丢失数据
+type spool struct {
+	mu     sync.Mutex
+	path   string
+	replay string // 正在重新写入的数据
+}
+
+func newSpool(dir string, name string) *spool {
+	return &spool{
+		path:   filepath.Join(dir, name+".jsonl"),
+		replay: filepath.Join(dir, name+".replay.jsonl"),
+	}
+}
+
+func (s *spool) put(cells ...*storage.DataCell) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, cell := range cells {
+		b, err := json.Marshal(cell.Data)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// take 取出所有数据，无法解析的行被丢弃。数据保留在重新写入的文件中直到调用 done，
+// 上一次重新写入没有结束时（例如进程退出）先取出上一次的数据
+func (s *spool) take() ([]*storage.DataCell, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := os.Stat(s.replay); errors.Is(err, os.ErrNotExist) {
+		if err := os.Rename(s.path, s.replay); errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(s.replay)
+	if err != nil {
+		return nil, err
+	}
+	var cells []*storage.DataCell
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var data map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &data); err != nil {
+			continue
+		}
+		cells = append(cells, &storage.DataCell{Data: data})
+	}
+	err = scanner.Err()
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	return cells, nil
+}
+
+// done 删除重新写入的文件，没有写入的数据需要先通过 put 放回 spool
+func (s *spool) done() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.replay); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -1,15 +1,22 @@
 package sqlstorage
 
-import "go.uber.org/zap"
+import (
+	"github.com/Nrich-sunny/crawler/sqldb"
+	"go.uber.org/zap"
+	"time"
+)
 
 type options struct {
-	logger     *zap.Logger
-	sqlUrl     string
-	BatchCount int // 批量数
+	logger        *zap.Logger
+	sqlUrl        string
+	db            sqldb.DBer    // 不为空时不再根据 sqlUrl 连接数据库
+	BatchCount    int           // 批量数
+	FlushInterval time.Duration // 数据缓存的最长时间，超过后即使不足一批也写入
 }
 
 var defaultOptions = options{
-	logger: zap.NewNop(),
+	logger:        zap.NewNop(),
+	FlushInterval: 5 * time.Second,
 }
 
 type Option func(opts *options)
@@ -31,3 +38,15 @@ func WithBatchCount(batchCount int) Option {
 		opts.BatchCount = batchCount
 	}
 }
+
+func WithFlushInterval(d time.Duration) Option {
+	return func(opts *options) {
+		opts.FlushInterval = d
+	}
+}
+
+func WithDB(db sqldb.DBer) Option {
+	return func(opts *options) {
+		opts.db = db
+	}
+}
@@ -2,20 +2,37 @@ package sqlstorage
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/collect"
 	"github.com/Nrich-sunny/crawler/engine"
 	"github.com/Nrich-sunny/crawler/sqldb"
 	"github.com/Nrich-sunny/crawler/storage"
 	"go.uber.org/zap"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 )
 
+// SqlStorage 将数据分批写入数据库，可以被多个任务并发使用。
+// 缓存的数据达到 BatchCount 条或缓存时间超过 FlushInterval 时写入
 type SqlStorage struct {
+	mu          sync.Mutex
 	dataDocker  []*storage.DataCell // 分批输出结果的缓存
 	columnNames []sqldb.Field       // 标题字段
 	db          sqldb.DBer
-	Table       map[string]struct{}
+	Table       map[string]map[string]string // 表名 -> 字段名 -> 字段类型
+	keyed       map[batchKey]bool            // 已经创建自然键索引的表与规则
+	timer       *time.Timer                  // 缓存中有数据时启动，到期后写入
+	err         error                        // 定时写入的错误，包含没有写入的数据，由下一次 Save 或 Flush 返回
+	closed      bool
 	options
 }
 
+var ErrClosed = errors.New("sql storage is closed")
+
 func New(opts ...Option) (*SqlStorage, error) {
 	options := defaultOptions
 	for _, opt := range opts {
@@ -23,7 +40,12 @@ func New(opts ...Option) (*SqlStorage, error) {
 	}
 	s := &SqlStorage{}
 	s.options = options
-	s.Table = make(map[string]struct{})
+	s.Table = make(map[string]map[string]string)
+	s.keyed = make(map[batchKey]bool)
+	s.db = options.db
+	if s.db != nil {
+		return s, nil
+	}
 	var err error
 	s.db, err = sqldb.New(
 		sqldb.WithConnUrl(s.sqlUrl),
@@ -37,95 +59,539 @@ func New(opts ...Option) (*SqlStorage, error) {
 	return s, nil
 }
 
+// Save 缓存数据，返回创建表、写入数据以及之前定时写入时的第一个错误。
+// 出错时返回 *storage.SaveError，其中包含所有没有写入的数据，包括之前定时写入失败的数据
 func (s *SqlStorage) Save(dataCells ...*storage.DataCell) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	var fs failures
+	fs.add(s.takeErr())
 	for _, cell := range dataCells {
-		name := cell.GetTableName()
-		if _, ok := s.Table[name]; !ok {
-			// 创建表
-			columnNames := getFields(cell)
-
-			err := s.db.CreateTable(sqldb.TableMetaData{
-				TableName:   name,
-				ColumnNames: columnNames,
-				AutoKey:     true,
-			})
-			if err != nil {
-				s.logger.Error("create table falied", zap.Error(err))
-			}
-			s.Table[name] = struct{}{}
+		if e := s.ensureTable(cell); e != nil {
+			s.logger.Error("create table falied", zap.Error(e))
+			fs.add(fmt.Errorf("create table %s failed:%w", cell.GetTableName(), e), cell)
+			continue
 		}
+		s.dataDocker = append(s.dataDocker, cell)
 		if len(s.dataDocker) >= s.BatchCount {
-			if err := s.Flush(); err != nil {
-				s.logger.Error("insert data failed", zap.Error(err))
+			fs.add(s.flush())
+		}
+	}
+	if len(s.dataDocker) > 0 && s.timer == nil && s.FlushInterval > 0 {
+		s.timer = time.AfterFunc(s.FlushInterval, s.flushOnTimer)
+	}
+	return fs.result()
+}
+
+// flushOnTimer 缓存时间超过 FlushInterval 后写入
+func (s *SqlStorage) flushOnTimer() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.flush(); err != nil {
+		s.logger.Error("insert data failed", zap.Error(err))
+		s.err = err
+	}
+}
+
+func (s *SqlStorage) takeErr() error {
+	err := s.err
+	s.err = nil
+	return err
+}
+
+// Flush 写入缓存的数据，出错时返回 *storage.SaveError
+func (s *SqlStorage) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var fs failures
+	fs.add(s.takeErr())
+	fs.add(s.flush())
+	return fs.result()
+}
+
+// Close 写入缓存的数据并关闭数据库连接
+func (s *SqlStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var fs failures
+	fs.add(s.takeErr())
+	fs.add(s.flush())
+	fs.add(s.db.Close())
+	return fs.result()
+}
+
+// failures 汇总没有写入的数据与第一个错误
+type failures struct {
+	cells []*storage.DataCell
+	err   error
+}
+
+// add 记录错误与没有写入的数据，err 为 *storage.SaveError 时记录其中的数据
+func (f *failures) add(err error, cells ...*storage.DataCell) {
+	if err == nil {
+		return
+	}
+	var se *storage.SaveError
+	if errors.As(err, &se) {
+		cells = append(cells, se.Cells...)
+		err = se.Err
+	}
+	f.cells = append(f.cells, cells...)
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// result 没有错误时返回 nil，否则返回 *storage.SaveError
+func (f *failures) result() error {
+	if f.err == nil {
+		return nil
+	}
+	return &storage.SaveError{Cells: f.cells, Err: f.err}
+}
+
+// ensureTable 表不存在或缺少任务规则输出的字段时，创建表或添加字段。
+// 任务声明了自然键时，第一次写入每条规则的数据前创建唯一索引与历史表
+func (s *SqlStorage) ensureTable(cell *storage.DataCell) error {
+	name := cell.GetTableName()
+	columns := getTableFields(cell)
+	k := batchKey{table: name, task: cell.GetTaskName(), rule: cell.GetRuleName()}
+	rule := engine.GetRule(k.task, k.rule)
+	key := engine.GetTableKey(k.task)
+	keyed := len(key) == 0 || s.keyed[k]
+	known, ok := s.Table[name]
+	if ok && keyed {
+		missing := false
+		for _, c := range columns {
+			if _, ok := known[c.Title]; !ok {
+				missing = true
 			}
 		}
-		s.dataDocker = append(s.dataDocker, cell)
+		if !missing {
+			return nil
+		}
+	}
+	t := sqldb.TableMetaData{
+		TableName:   name,
+		ColumnNames: columns,
+		AutoKey:     true,
+	}
+	t.UniqueKey = key
+	if rule != nil {
+		t.History = rule.History
+	}
+	if err := s.db.CreateTable(t); err != nil {
+		return err
+	}
+	s.keyed[k] = true
+	// 表已经存在时（例如进程重启后）字段的类型以数据库中的为准
+	types, err := s.columnTypes(name)
+	if err != nil {
+		return err
+	}
+	if known == nil {
+		known = make(map[string]string, len(columns))
+		s.Table[name] = known
+	}
+	// 已有字段的类型不会改变
+	for _, c := range columns {
+		if _, ok := known[c.Title]; ok {
+			continue
+		}
+		if typ, ok := types[c.Title]; ok {
+			known[c.Title] = typ
+		} else {
+			known[c.Title] = c.Type
+		}
 	}
 	return nil
 }
 
+// columnTypes 查询数据库中表的字段类型，数据库不支持查询时返回空
+func (s *SqlStorage) columnTypes(table string) (map[string]string, error) {
+	d, ok := s.db.(interface {
+		ColumnTypes(table string) (map[string]string, error)
+	})
+	if !ok {
+		return nil, nil
+	}
+	types, err := d.ColumnTypes(table)
+	if err != nil {
+		return nil, fmt.Errorf("query column types of %s failed:%w", table, err)
+	}
+	return types, nil
+}
+
 func getFields(cell *storage.DataCell) []sqldb.Field {
-	taskName := cell.Data["Task"].(string)
-	ruleName := cell.Data["Rule"].(string)
-	fields := engine.GetFields(taskName, ruleName)
+	return columns(engine.GetFields(cell.GetTaskName(), cell.GetRuleName()))
+}
+
+// getTableFields 表的字段，包含任务中所有规则输出的字段，
+// 同一个任务的不同规则输出的数据可以写入同一张表。字段类型优先使用规则 Schema 中声明的类型，
+// 没有声明时由数据中的值推断。自然键中的文本字段使用 VARCHAR，MySQL 不能直接为 TEXT 字段创建索引
+func getTableFields(cell *storage.DataCell) []sqldb.Field {
+	task, ok := engine.Store.Get(cell.GetTaskName())
+	if !ok {
+		return typed(getFields(cell), cell, nil)
+	}
+	names := make([]string, 0, len(task.Rule.Trunk))
+	for name := range task.Rule.Trunk {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var fields []string
+	seen := make(map[string]bool)
+	keys := make(map[string]bool)
+	declared := make(map[string]string)
+	for _, name := range names {
+		for _, f := range task.Rule.Trunk[name].ItemFields {
+			if !seen[f] {
+				seen[f] = true
+				fields = append(fields, f)
+			}
+		}
+		for _, k := range task.Rule.Trunk[name].Key {
+			keys[k] = true
+		}
+		for _, f := range task.Rule.Trunk[name].Schema {
+			if typ := schemaType(f.Type); typ != "" {
+				declared[f.Name] = typ
+			}
+		}
+	}
+	columnNames := typed(columns(fields), cell, declared)
+	for i, c := range columnNames {
+		if keys[c.Title] && c.Type == sqldb.TypeText {
+			columnNames[i].Type = sqldb.TypeString
+		}
+	}
+	return columnNames
+}
 
+func columns(fields []string) []sqldb.Field {
 	var columnNames []sqldb.Field
 	for _, field := range fields {
 		columnNames = append(columnNames, sqldb.Field{
 			Title: field,
-			Type:  "MEDIUMTEXT",
+			Type:  sqldb.TypeText,
 		})
 	}
 	columnNames = append(columnNames,
-		sqldb.Field{Title: "Url", Type: "VARCHAR(255)"},
-		sqldb.Field{Title: "Time", Type: "VARCHAR(255)"},
+		sqldb.Field{Title: "Url", Type: sqldb.TypeString},
+		sqldb.Field{Title: "Time", Type: sqldb.TypeString},
 	)
 	return columnNames
 }
 
-func (s *SqlStorage) Flush() error {
+// typed 使用声明的字段类型，没有声明的字段按数据中的值推断类型，数据中没有的字段使用文本类型
+func typed(fields []sqldb.Field, cell *storage.DataCell, declared map[string]string) []sqldb.Field {
+	data := cell.GetData()
+	for i, f := range fields {
+		if f.Type != sqldb.TypeText {
+			continue
+		}
+		if typ, ok := declared[f.Title]; ok {
+			fields[i].Type = typ
+			continue
+		}
+		if v, ok := data[f.Title]; ok {
+			fields[i].Type = inferType(v)
+		}
+	}
+	return fields
+}
+
+// schemaType Schema 中声明的字段类型对应的字段类型，没有声明类型时返回空
+func schemaType(t collect.FieldType) string {
+	switch t {
+	case collect.FieldString:
+		return sqldb.TypeText
+	case collect.FieldInt:
+		return sqldb.TypeInt
+	case collect.FieldFloat:
+		return sqldb.TypeFloat
+	case collect.FieldBool:
+		return sqldb.TypeBool
+	}
+	return ""
+}
+
+func inferType(v interface{}) string {
+	switch v.(type) {
+	case bool:
+		return sqldb.TypeBool
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return sqldb.TypeInt
+	case float32, float64:
+		return sqldb.TypeFloat
+	}
+	return sqldb.TypeText
+}
+
+// batchKey 同一批写入的数据需要属于同一张表和同一条规则
+type batchKey struct {
+	table string
+	task  string
+	rule  string
+}
+
+// flush 写入缓存的数据，调用时需持有 s.mu。写入失败的数据不再缓存，由返回的 *storage.SaveError 报告。
+// 数据按表和规则分组，每组使用规则自身的字段写入
+func (s *SqlStorage) flush() error {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
 	if len(s.dataDocker) == 0 {
 		return nil
 	}
 	defer func() {
 		s.dataDocker = nil
 	}()
-	args := make([]interface{}, 0)
-	for _, datacell := range s.dataDocker {
-		ruleName := datacell.Data["Rule"].(string)
-		taskName := datacell.Data["Task"].(string)
-		fields := engine.GetFields(taskName, ruleName)
-		data := datacell.Data["Data"].(map[string]interface{})
-		value := []string{}
+
+	var keys []batchKey
+	groups := make(map[batchKey][]*storage.DataCell)
+	for _, cell := range s.dataDocker {
+		k := batchKey{
+			table: cell.GetTableName(),
+			task:  cell.GetTaskName(),
+			rule:  cell.GetRuleName(),
+		}
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], cell)
+	}
+
+	var fs failures
+	for _, k := range keys {
+		if e := s.insert(k.table, groups[k]); e != nil {
+			s.logger.Error("insert data failed", zap.String("table", k.table), zap.String("rule", k.rule), zap.Error(e))
+			// 写入失败时整组数据都没有写入，只有部分数据无法转换时其余数据已经写入
+			failed := groups[k]
+			var convErr *ConvertError
+			if errors.As(e, &convErr) {
+				failed = convErr.Cells
+			}
+			fs.add(fmt.Errorf("insert into %s failed:%w", k.table, e), failed...)
+		}
+	}
+	return fs.result()
+}
+
+// insert 将同一条规则输出的数据写入表中。
+// 任务声明了自然键且规则输出了键的所有字段时，按键插入或更新数据，同一批中键相同的数据只写入最后一条。
+// 没有输出键的字段的规则，键为 NULL，数据直接插入。
+// 字段的值无法转换为字段类型的数据不会写入，返回 *ConvertError
+func (s *SqlStorage) insert(table string, cells []*storage.DataCell) error {
+	fields := engine.GetFields(cells[0].GetTaskName(), cells[0].GetRuleName())
+	rule := engine.GetRule(cells[0].GetTaskName(), cells[0].GetRuleName())
+	columnNames := getFields(cells[0])
+	key := ruleKey(columnNames, engine.GetTableKey(cells[0].GetTaskName()))
+	types := s.Table[table]
+	var rows [][]interface{}
+	index := make(map[string]int) // 自然键 -> rows 中的位置
+	var convErr *ConvertError
+	for _, datacell := range cells {
+		data := datacell.GetData()
+		row := make([]interface{}, 0, len(columnNames))
+		var err error
 		for _, field := range fields {
-			v := data[field]
-			switch v.(type) {
-			case nil:
-				value = append(value, "")
-			case string:
-				value = append(value, v.(string))
-			default:
-				j, err := json.Marshal(v)
-				if err != nil {
-					value = append(value, "")
-				} else {
-					value = append(value, string(j))
-				}
+			var v interface{}
+			if v, err = value(data[field], types[field]); err != nil {
+				err = fmt.Errorf("field %s: %w", field, err)
+				break
+			}
+			row = append(row, v)
+		}
+		if err != nil {
+			if convErr == nil {
+				convErr = &ConvertError{Table: table, Err: err}
 			}
+			convErr.Cells = append(convErr.Cells, datacell)
+			continue
 		}
-		value = append(value,
-			datacell.Data["Url"].(string),
-			datacell.Data["Time"].(string),
+		row = append(row,
+			datacell.GetUrl(),
+			datacell.Data[storage.KeyTime],
 		)
-		for _, v := range value {
-			args = append(args, v)
+		if len(key) == 0 {
+			rows = append(rows, row)
+			continue
 		}
+		k := rowKey(columnNames, row, key)
+		if i, ok := index[k]; ok {
+			rows[i] = row
+			continue
+		}
+		index[k] = len(rows)
+		rows = append(rows, row)
 	}
 
-	return s.db.Insert(sqldb.TableMetaData{
-		TableName:   s.dataDocker[0].GetTableName(),
-		ColumnNames: getFields(s.dataDocker[0]),
+	if len(rows) == 0 {
+		return convErr
+	}
+	args := make([]interface{}, 0, len(rows)*len(columnNames))
+	for _, row := range rows {
+		args = append(args, row...)
+	}
+	t := sqldb.TableMetaData{
+		TableName:   table,
+		ColumnNames: columnNames,
 		Args:        args,
-		DataCount:   len(s.dataDocker),
-	})
+		DataCount:   len(rows),
+	}
+	if len(key) > 0 {
+		t.UniqueKey = key
+		t.History = rule != nil && rule.History
+		t.IgnoreChanges = []string{"Time"}
+	}
+	if err := s.db.Insert(t); err != nil {
+		return err
+	}
+	if convErr != nil {
+		return convErr
+	}
+	return nil
+}
+
+// ConvertError 字段的值无法转换为表中字段的类型，Cells 为没有写入的数据，Err 为第一条数据的错误
+type ConvertError struct {
+	Table string
+	Cells []*storage.DataCell
+	Err   error
+}
+
+func (e *ConvertError) Error() string {
+	return fmt.Sprintf("%d items not written to %s: %v", len(e.Cells), e.Table, e.Err)
+}
+
+func (e *ConvertError) Unwrap() error {
+	return e.Err
+}
+
+// ruleKey 规则写入的字段包含键的所有字段时返回键，否则返回 nil
+func ruleKey(columnNames []sqldb.Field, key []string) []string {
+	for _, k := range key {
+		found := false
+		for _, c := range columnNames {
+			if c.Title == k {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return key
+}
+
+// rowKey 数据的自然键
+func rowKey(columnNames []sqldb.Field, row []interface{}, key []string) string {
+	values := make([]interface{}, 0, len(key))
+	for _, k := range key {
+		for i, c := range columnNames {
+			if c.Title == k {
+				values = append(values, row[i])
+			}
+		}
+	}
+	b, _ := json.Marshal(values)
+	return string(b)
+}
+
+// value 按字段类型转换字段的值，空值为 NULL，无法转换时返回错误。
+// 文本字段中非字符串的值序列化为 JSON
+func value(v interface{}, typ string) (interface{}, error) {
+	switch typ {
+	case sqldb.TypeInt, sqldb.TypeFloat, sqldb.TypeBool:
+		if v == nil || v == "" {
+			return nil, nil
+		}
+	}
+	switch typ {
+	case sqldb.TypeInt:
+		if i, ok := v.(int64); ok {
+			return i, nil
+		}
+		if f, ok := toFloat(v); ok && f == math.Trunc(f) {
+			return int64(f), nil
+		}
+		if s, ok := v.(string); ok {
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return i, nil
+			}
+		}
+		return nil, fmt.Errorf("can not convert %v (%T) to int", v, v)
+	case sqldb.TypeFloat:
+		if f, ok := toFloat(v); ok {
+			return f, nil
+		}
+		if s, ok := v.(string); ok {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f, nil
+			}
+		}
+		return nil, fmt.Errorf("can not convert %v (%T) to float", v, v)
+	case sqldb.TypeBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		if s, ok := v.(string); ok {
+			if b, err := strconv.ParseBool(s); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("can not convert %v (%T) to bool", v, v)
+	}
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	default:
+		j, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return string(j), nil
+	}
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
 }
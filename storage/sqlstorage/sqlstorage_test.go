@@ -0,0 +1,333 @@
+package sqlstorage_test
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/engine"
+	"github.com/Nrich-sunny/crawler/sqldb"
+	"github.com/Nrich-sunny/crawler/storage"
+	"github.com/Nrich-sunny/crawler/storage/sqlstorage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDB 记录写入的数据，insertErr 不为空时写入失败
+type fakeDB struct {
+	mu        sync.Mutex
+	tables    map[string][]sqldb.Field
+	inserts   []sqldb.TableMetaData
+	insertErr error
+	closed    bool
+}
+
+func newFakeDB() *fakeDB {
+	return &fakeDB{tables: make(map[string][]sqldb.Field)}
+}
+
+func (d *fakeDB) CreateTable(t sqldb.TableMetaData) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tables[t.TableName] = t.ColumnNames
+	return nil
+}
+
+func (d *fakeDB) Insert(t sqldb.TableMetaData) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.insertErr != nil {
+		return d.insertErr
+	}
+	d.inserts = append(d.inserts, t)
+	return nil
+}
+
+func (d *fakeDB) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	return nil
+}
+
+func (d *fakeDB) rows() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for _, t := range d.inserts {
+		n += t.DataCount
+	}
+	return n
+}
+
+func init() {
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{Trunk: map[string]*collect.Rule{
+			"书籍简介": {ItemFields: []string{"书名", "作者"}},
+			"书评":   {ItemFields: []string{"书名", "评分"}},
+		}},
+		Options: collect.Options{Name: "sql_book"},
+	})
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{Trunk: map[string]*collect.Rule{
+			"电影": {ItemFields: []string{"片名"}},
+		}},
+		Options: collect.Options{Name: "sql_movie"},
+	})
+}
+
+func cell(task string, rule string, data map[string]interface{}) *storage.DataCell {
+	return &storage.DataCell{Data: map[string]interface{}{
+		"Task": task,
+		"Rule": rule,
+		"Url":  "https://douban.com/subject/1/",
+		"Time": "2022-11-20 10:00:00",
+		"Data": data,
+	}}
+}
+
+func bookCell(title string) *storage.DataCell {
+	return cell("sql_book", "书籍简介", map[string]interface{}{"书名": title, "作者": "刘慈欣"})
+}
+
+func TestSqlStorageFlush(t *testing.T) {
+	db := newFakeDB()
+	s, err := sqlstorage.New(
+		sqlstorage.WithDB(db),
+		sqlstorage.WithBatchCount(100),
+		sqlstorage.WithFlushInterval(20*time.Millisecond),
+	)
+	require.NoError(t, err)
+
+	// 不足一批的数据在 FlushInterval 后写入
+	require.NoError(t, s.Save(bookCell("三体")))
+	assert.Equal(t, 0, db.rows())
+	assert.Eventually(t, func() bool { return db.rows() == 1 }, time.Second, 5*time.Millisecond)
+
+	// 并发保存
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 30; j++ {
+				assert.NoError(t, s.Save(bookCell("三体")))
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, s.Flush())
+	assert.Equal(t, 301, db.rows())
+	// 表包含任务中所有规则的字段
+	assert.Len(t, db.tables["sql_book"], 5)
+
+	// 定时写入的错误由下一次调用返回
+	db.mu.Lock()
+	db.insertErr = errors.New("connection refused")
+	db.mu.Unlock()
+	require.NoError(t, s.Save(bookCell("球状闪电")))
+	time.Sleep(50 * time.Millisecond)
+	// 错误中只包含定时写入失败的数据，本次保存的数据仍在缓存中
+	err = s.Save(bookCell("球状闪电"))
+	cells, ok := storage.FailedCells(err)
+	require.True(t, ok)
+	assert.Len(t, cells, 1)
+	cells, ok = storage.FailedCells(s.Flush())
+	require.True(t, ok)
+	assert.Len(t, cells, 1)
+
+	db.mu.Lock()
+	db.insertErr = nil
+	db.mu.Unlock()
+	require.NoError(t, s.Save(bookCell("流浪地球")))
+	require.NoError(t, s.Close())
+	assert.Equal(t, 302, db.rows())
+	assert.True(t, db.closed)
+	assert.ErrorIs(t, s.Save(bookCell("三体")), sqlstorage.ErrClosed)
+}
+
+func query(t *testing.T, db *sql.DB, query string) []string {
+	rows, err := db.Query(query)
+	require.NoError(t, err)
+	defer rows.Close()
+	var res []string
+	for rows.Next() {
+		var s string
+		require.NoError(t, rows.Scan(&s))
+		res = append(res, s)
+	}
+	require.NoError(t, rows.Err())
+	return res
+}
+
+func TestSqlStorageMultiTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "crawler.db")
+	s, err := sqlstorage.New(sqlstorage.WithSqlUrl("sqlite://"+path), sqlstorage.WithBatchCount(100))
+	require.NoError(t, err)
+
+	// 不同任务、不同规则的数据混在同一批中
+	require.NoError(t, s.Save(
+		cell("sql_book", "书籍简介", map[string]interface{}{"书名": "三体", "作者": "刘慈欣"}),
+		cell("sql_movie", "电影", map[string]interface{}{"片名": "流浪地球"}),
+		cell("sql_book", "书评", map[string]interface{}{"书名": "三体", "评分": 9.3}),
+		cell("sql_book", "书籍简介", map[string]interface{}{"书名": "活着", "作者": "余华"}),
+	))
+	require.NoError(t, s.Close())
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, []string{"三体|刘慈欣|", "活着|余华|", "三体||9.3"},
+		query(t, db, `SELECT "书名" || '|' || COALESCE("作者", '') || '|' || COALESCE("评分", '') FROM "sql_book" ORDER BY id`))
+	assert.Equal(t, []string{"流浪地球"}, query(t, db, `SELECT "片名" FROM "sql_movie"`))
+}
+
+func TestSqlStorageSchemaEvolution(t *testing.T) {
+	task := &collect.Task{
+		Rule: collect.RuleTree{Trunk: map[string]*collect.Rule{
+			"书籍简介": {ItemFields: []string{"书名", "评分", "页数"}},
+		}},
+		Options: collect.Options{Name: "sql_schema"},
+	}
+	engine.Store.Add(task)
+	path := filepath.Join(t.TempDir(), "crawler.db")
+	s, err := sqlstorage.New(sqlstorage.WithSqlUrl("sqlite://"+path), sqlstorage.WithBatchCount(1))
+	require.NoError(t, err)
+
+	// 字段类型由第一条数据推断，之后的数据按字段类型转换
+	require.NoError(t, s.Save(cell("sql_schema", "书籍简介", map[string]interface{}{"书名": "三体", "评分": 9.3, "页数": 302})))
+	require.NoError(t, s.Save(cell("sql_schema", "书籍简介", map[string]interface{}{"书名": "活着", "评分": "9.4", "页数": "191"})))
+
+	// 动态加载的规则新增了字段
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{Trunk: map[string]*collect.Rule{
+			"书籍简介": {ItemFields: []string{"书名", "评分", "页数", "出版社"}},
+		}},
+		Options: collect.Options{Name: "sql_schema"},
+	})
+	require.NoError(t, s.Save(cell("sql_schema", "书籍简介", map[string]interface{}{"书名": "围城", "评分": 9.0, "页数": 359, "出版社": "人民文学出版社"})))
+	require.NoError(t, s.Close())
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, []string{"三体|real|integer|", "活着|real|integer|", "围城|real|integer|人民文学出版社"},
+		query(t, db, `SELECT "书名" || '|' || typeof("评分") || '|' || typeof("页数") || '|' || COALESCE("出版社", '') FROM "sql_schema" ORDER BY id`))
+	assert.Equal(t, []string{"1", "2"}, query(t, db, `SELECT version FROM crawler_migrations WHERE table_name = 'sql_schema' ORDER BY version`))
+}
+
+func TestSqlStorageUpsert(t *testing.T) {
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{Trunk: map[string]*collect.Rule{
+			"书籍简介": {ItemFields: []string{"书名", "评分"}, Key: []string{"Url"}, History: true},
+		}},
+		Options: collect.Options{Name: "sql_upsert"},
+	})
+	book := func(url string, title string, score float64, time string) *storage.DataCell {
+		c := cell("sql_upsert", "书籍简介", map[string]interface{}{"书名": title, "评分": score})
+		c.Data["Url"] = url
+		c.Data["Time"] = time
+		return c
+	}
+	path := filepath.Join(t.TempDir(), "crawler.db")
+	s, err := sqlstorage.New(sqlstorage.WithSqlUrl("sqlite://"+path), sqlstorage.WithBatchCount(10))
+	require.NoError(t, err)
+
+	// 同一批中键相同的数据只写入最后一条
+	require.NoError(t, s.Save(
+		book("https://book.douban.com/subject/1/", "三体", 9.2, "2022-11-20 10:00:00"),
+		book("https://book.douban.com/subject/1/", "三体", 9.3, "2022-11-20 10:00:01"),
+		book("https://book.douban.com/subject/2/", "活着", 9.4, "2022-11-20 10:00:02"),
+	))
+	require.NoError(t, s.Flush())
+	// 重新抓取，内容没有变化时只更新抓取时间
+	require.NoError(t, s.Save(book("https://book.douban.com/subject/1/", "三体", 9.3, "2022-11-21 10:00:00")))
+	require.NoError(t, s.Flush())
+	// 内容变化时旧的数据保存到历史表
+	require.NoError(t, s.Save(book("https://book.douban.com/subject/2/", "活着", 9.5, "2022-11-22 10:00:00")))
+	require.NoError(t, s.Close())
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, []string{"三体|9.3|2022-11-21 10:00:00", "活着|9.5|2022-11-22 10:00:00"},
+		query(t, db, `SELECT "书名" || '|' || "评分" || '|' || "Time" FROM "sql_upsert" ORDER BY id`))
+	assert.Equal(t, []string{"活着|9.4|2022-11-20 10:00:02"},
+		query(t, db, `SELECT "书名" || '|' || "评分" || '|' || "Time" FROM "sql_upsert_history" ORDER BY id`))
+}
+
+func TestSqlStorageColumnTypes(t *testing.T) {
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{Trunk: map[string]*collect.Rule{
+			"书籍简介": {ItemFields: []string{"书名", "评分", "页数"}},
+			"书评":   {Schema: collect.Schema{{Name: "书名"}, {Name: "推荐", Type: collect.FieldBool}, {Name: "星级", Type: collect.FieldFloat}}},
+		}},
+		Options: collect.Options{Name: "sql_types"},
+	})
+	path := filepath.Join(t.TempDir(), "crawler.db")
+	s, err := sqlstorage.New(sqlstorage.WithSqlUrl("sqlite://"+path), sqlstorage.WithBatchCount(1))
+	require.NoError(t, err)
+	// 声明了类型的字段不按第一条数据推断
+	require.NoError(t, s.Save(cell("sql_types", "书籍简介", map[string]interface{}{"书名": "三体", "评分": 9.3, "页数": 302})))
+	require.NoError(t, s.Save(cell("sql_types", "书评", map[string]interface{}{"书名": "三体", "推荐": "true", "星级": 5})))
+	require.NoError(t, s.Close())
+
+	// 重启后字段类型以数据库中的为准，而不是由新的数据推断
+	s, err = sqlstorage.New(sqlstorage.WithSqlUrl("sqlite://"+path), sqlstorage.WithBatchCount(10))
+	require.NoError(t, err)
+	require.NoError(t, s.Save(
+		cell("sql_types", "书籍简介", map[string]interface{}{"书名": "活着", "评分": 9, "页数": "191"}),
+		cell("sql_types", "书籍简介", map[string]interface{}{"书名": "围城", "评分": "9.1", "页数": "三百页"}),
+		cell("sql_types", "书籍简介", map[string]interface{}{"书名": "平凡的世界", "评分": "", "页数": 1200}),
+	))
+	// 无法转换的数据不会写入 NULL，而是返回错误
+	err = s.Flush()
+	var convErr *sqlstorage.ConvertError
+	require.ErrorAs(t, err, &convErr)
+	require.Len(t, convErr.Cells, 1)
+	assert.Equal(t, "围城", convErr.Cells[0].GetData()["书名"])
+	assert.Contains(t, err.Error(), "页数")
+	assert.Equal(t, sqldb.TypeFloat, s.Table["sql_types"]["评分"])
+	require.NoError(t, s.Close())
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, []string{"三体|real|integer", "活着|real|integer", "平凡的世界|null|integer"},
+		query(t, db, `SELECT "书名" || '|' || typeof("评分") || '|' || typeof("页数") FROM "sql_types" WHERE "推荐" IS NULL ORDER BY id`))
+	assert.Equal(t, []string{"1|real"}, query(t, db, `SELECT "推荐" || '|' || typeof("星级") FROM "sql_types" WHERE "推荐" IS NOT NULL`))
+}
+
+func TestSqlStorageSharedKey(t *testing.T) {
+	engine.Store.Add(&collect.Task{
+		Rule: collect.RuleTree{Trunk: map[string]*collect.Rule{
+			"书籍简介": {ItemFields: []string{"书名", "作者"}, Key: []string{"Url"}},
+			"书评":   {ItemFields: []string{"书名", "评分"}},
+		}},
+		Options: collect.Options{Name: "sql_shared_key"},
+	})
+	book := func(rule string, url string, data map[string]interface{}) *storage.DataCell {
+		c := cell("sql_shared_key", rule, data)
+		c.Data["Url"] = url
+		return c
+	}
+	path := filepath.Join(t.TempDir(), "crawler.db")
+	s, err := sqlstorage.New(sqlstorage.WithSqlUrl("sqlite://"+path), sqlstorage.WithBatchCount(1))
+	require.NoError(t, err)
+
+	// 没有声明自然键的规则同样按表的自然键写入，不会违反唯一索引
+	require.NoError(t, s.Save(book("书籍简介", "https://book.douban.com/subject/1/", map[string]interface{}{"书名": "三体", "作者": "刘慈欣"})))
+	require.NoError(t, s.Save(book("书评", "https://book.douban.com/subject/1/", map[string]interface{}{"书名": "三体", "评分": 9.3})))
+	require.NoError(t, s.Save(book("书评", "https://book.douban.com/subject/2/", map[string]interface{}{"书名": "活着", "评分": 9.4})))
+	require.NoError(t, s.Save(book("书评", "https://book.douban.com/subject/2/", map[string]interface{}{"书名": "活着", "评分": 9.5})))
+	require.NoError(t, s.Close())
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, []string{"三体|刘慈欣|9.3", "活着||9.5"},
+		query(t, db, `SELECT "书名" || '|' || COALESCE("作者", '') || '|' || "评分" FROM "sql_shared_key" ORDER BY id`))
+}
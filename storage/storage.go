@@ -1,18 +1,115 @@
 package storage
 
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DataCell.Data 中的键
+const (
+	KeyTask = "Task" // 任务名
+	KeyRule = "Rule" // 规则名
+	KeyUrl  = "Url"  // 数据所在的网址
+	KeyTime = "Time" // 抓取时间，TimeLayout 格式
+	KeyData = "Data" // 规则输出的数据
+)
+
+// TimeLayout 抓取时间的格式
+const TimeLayout = "2006-01-02 15:04:05"
+
 type DataCell struct {
 	Data map[string]interface{}
 }
 
+// Item 规则输出的一条数据及其来源，DataCell 的类型化表示
+type Item struct {
+	Task string
+	Rule string
+	Url  string
+	Time time.Time
+	Data map[string]interface{}
+}
+
+// NewDataCell 由 Item 生成 DataCell
+func NewDataCell(item Item) *DataCell {
+	return &DataCell{Data: map[string]interface{}{
+		KeyTask: item.Task,
+		KeyRule: item.Rule,
+		KeyUrl:  item.Url,
+		KeyTime: item.Time.Format(TimeLayout),
+		KeyData: item.Data,
+	}}
+}
+
+// Item 返回 DataCell 的类型化表示，缺少的键或类型不符的值为零值
+func (d *DataCell) Item() Item {
+	item := Item{
+		Task: d.GetTaskName(),
+		Rule: d.GetRuleName(),
+		Url:  d.GetUrl(),
+		Data: d.GetData(),
+	}
+	if t, err := time.ParseInLocation(TimeLayout, d.str(KeyTime), time.Local); err == nil {
+		item.Time = t
+	}
+	return item
+}
+
+func (d *DataCell) str(key string) string {
+	s, _ := d.Data[key].(string)
+	return s
+}
+
 func (d *DataCell) GetTableName() string {
-	return d.Data["Task"].(string)
+	return d.GetTaskName()
 }
 
 func (d *DataCell) GetTaskName() string {
-	return d.Data["Task"].(string)
+	return d.str(KeyTask)
+}
+
+func (d *DataCell) GetRuleName() string {
+	return d.str(KeyRule)
+}
+
+func (d *DataCell) GetUrl() string {
+	return d.str(KeyUrl)
+}
+
+// GetData 返回规则输出的数据，数据不是 map 时返回 nil
+func (d *DataCell) GetData() map[string]interface{} {
+	data, _ := d.Data[KeyData].(map[string]interface{})
+	return data
 }
 
 // Storage 数据存储的接口
 type Storage interface {
-	Save(datas ...*DataCell) error
+	Save(datas ...*DataCell) error // 保存数据，可以先缓存再批量写入
+	Flush() error                  // 写入缓存的数据
+	Close() error                  // 写入缓存的数据并释放资源，之后不能再保存数据
+}
+
+// SaveError 批量写入的存储报告的写入失败的数据，由 Save、Flush 或 Close 返回。
+// Cells 可能不是本次调用传入的数据，例如之前缓存、定时写入失败的数据，Err 为第一个错误
+type SaveError struct {
+	Cells []*DataCell
+	Err   error
+}
+
+func (e *SaveError) Error() string {
+	return fmt.Sprintf("%d items not saved: %v", len(e.Cells), e.Err)
+}
+
+func (e *SaveError) Unwrap() error {
+	return e.Err
+}
+
+// FailedCells 返回 err 中报告的写入失败的数据，err 没有报告时 ok 为 false
+func FailedCells(err error) (cells []*DataCell, ok bool) {
+	var e *SaveError
+	if !errors.As(err, &e) {
+		return nil, false
+	}
+	return e.Cells, true
 }
@@ -0,0 +1,56 @@
+package taskmodule
+
+/** 本模块负责从文件或 etcd 中加载 JS 任务模块(collect.TaskModule)，
+**	使新的动态规则无需重新编译即可部署到 Worker
+ */
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/collect"
+	"path/filepath"
+	"sigs.k8s.io/yaml"
+	"strings"
+)
+
+const ModulePath = "/modules" // 任务模块在 etcd 中的前缀
+
+// Handler 处理加载到的任务模块，通常为 engine.Store.AddJsTask
+type Handler func(m *collect.TaskModule) error
+
+// EtcdKey 返回任务模块在 etcd 中的 Key
+func EtcdKey(name string) string {
+	return fmt.Sprintf("%s/%s", ModulePath, name)
+}
+
+// IsModuleFile 判断文件是否为支持的任务模块格式
+func IsModuleFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// Decode 按文件扩展名解析 JSON 或 YAML 格式的任务模块，
+// YAML 中的字段名与 JSON 相同，例如 root_script、rule、parse_script
+func Decode(name string, data []byte) (*collect.TaskModule, error) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+	case ".yaml", ".yml":
+		var err error
+		if data, err = yaml.YAMLToJSON(data); err != nil {
+			return nil, fmt.Errorf("decode yaml %s failed:%w", name, err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported task module file %s", name)
+	}
+	m := &collect.TaskModule{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("decode %s failed:%w", name, err)
+	}
+	if m.Name == "" {
+		return nil, fmt.Errorf("task module %s has no name", name)
+	}
+	return m, nil
+}
@@ -0,0 +1,81 @@
+package taskmodule_test
+
+import (
+	"context"
+	"github.com/Nrich-sunny/crawler/collect"
+	"github.com/Nrich-sunny/crawler/taskmodule"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const yamlModule = `
+name: js_douban_group
+url: https://www.douban.com/group/szsh/discussion?start=
+wait_time: 2
+max_depth: 5
+timeout: 1000
+root_script: |
+  var arr = new Array();
+  AddJsReq(arr);
+rule:
+  - name: 解析网站URL
+    item_fields: [url]
+    parse_script: ctx.ParseJSReg("解析阳台房", '(https://www.douban.com/group/topic/[0-9a-z]+/)"[^>]*>([^<]+)</a>');
+`
+
+func TestDirWatcher(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "group.yaml"), []byte(yamlModule), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "book.json"), []byte(`{"name":"js_douban_book","rule":[{"name":"书籍列表"}]}`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("# modules"), 0644))
+
+	loaded := map[string]*collect.TaskModule{}
+	w := taskmodule.NewDirWatcher(dir, func(m *collect.TaskModule) error {
+		loaded[m.Name] = m
+		return nil
+	})
+	n, err := w.Scan()
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	m := loaded["js_douban_group"]
+	require.NotNil(t, m)
+	assert.Equal(t, int64(2), m.WaitTime)
+	assert.Equal(t, int64(1000), m.Timeout)
+	require.Len(t, m.Rules, 1)
+	assert.Equal(t, []string{"url"}, m.Rules[0].ItemFields)
+	assert.Contains(t, m.Root, "AddJsReq(arr);")
+	assert.Equal(t, "书籍列表", loaded["js_douban_book"].Rules[0].Name)
+
+	// 未修改的文件不会重复加载
+	n, err = w.Scan()
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, "book.json"), future, future))
+	n, err = w.Scan()
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = taskmodule.Decode("broken.json", []byte(`{"url":"https://example.com"}`))
+	assert.Error(t, err)
+}
+
+func TestWatchEtcdTimeout(t *testing.T) {
+	// 没有监听的端口，etcd 不可用
+	cli, err := clientv3.New(clientv3.Config{Endpoints: []string{"127.0.0.1:1"}, DialTimeout: time.Second})
+	require.NoError(t, err)
+	defer cli.Close()
+
+	start := time.Now()
+	err = taskmodule.WatchEtcd(context.Background(), cli, func(m *collect.TaskModule) error { return nil },
+		taskmodule.WithTimeout(200*time.Millisecond))
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
@@ -0,0 +1,42 @@
+package taskmodule
+
+import (
+	"go.uber.org/zap"
+	"time"
+)
+
+type options struct {
+	logger   *zap.Logger
+	interval time.Duration // 扫描目录的间隔
+	timeout  time.Duration // 首次读取 etcd 中任务模块的超时时间
+}
+
+var defaultOptions = options{
+	logger:   zap.NewNop(),
+	interval: 10 * time.Second,
+	timeout:  5 * time.Second,
+}
+
+type Option func(opts *options)
+
+func WithLogger(logger *zap.Logger) Option {
+	return func(opts *options) {
+		opts.logger = logger
+	}
+}
+
+func WithInterval(interval time.Duration) Option {
+	return func(opts *options) {
+		if interval > 0 {
+			opts.interval = interval
+		}
+	}
+}
+
+func WithTimeout(timeout time.Duration) Option {
+	return func(opts *options) {
+		if timeout > 0 {
+			opts.timeout = timeout
+		}
+	}
+}
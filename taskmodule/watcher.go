@@ -0,0 +1,165 @@
+package taskmodule
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/Nrich-sunny/crawler/collect"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DirWatcher 定期扫描目录，加载新增或修改过的任务模块文件。
+// 删除文件不会卸载已经加载的任务
+type DirWatcher struct {
+	dir      string
+	handler  Handler
+	modTimes map[string]time.Time // 文件名 -> 上次加载时的修改时间
+	options
+}
+
+func NewDirWatcher(dir string, handler Handler, opts ...Option) *DirWatcher {
+	options := defaultOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	w := &DirWatcher{
+		dir:      dir,
+		handler:  handler,
+		modTimes: make(map[string]time.Time),
+	}
+	w.options = options
+	return w
+}
+
+// Scan 扫描一次目录，返回成功加载的模块数。
+// 单个文件加载失败只记录日志，下次文件修改后会重新加载
+func (w *DirWatcher) Scan() (int, error) {
+	modules, errs, err := LoadDir(w.dir, w.handler, w.unchanged)
+	if err != nil {
+		return 0, err
+	}
+	for name, err := range errs {
+		w.logger.Error("load task module failed", zap.String("file", name), zap.Error(err))
+	}
+	for _, m := range modules {
+		w.logger.Info("load task module", zap.String("name", m.Name))
+	}
+	return len(modules), nil
+}
+
+// unchanged 文件自上次加载后没有修改时返回 true，否则记录文件的修改时间
+func (w *DirWatcher) unchanged(name string, info os.FileInfo) bool {
+	if t, ok := w.modTimes[name]; ok && t.Equal(info.ModTime()) {
+		return true
+	}
+	w.modTimes[name] = info.ModTime()
+	return false
+}
+
+// LoadDir 加载目录中的任务模块，返回成功加载的模块和每个失败文件的错误。
+// skip 不为空时跳过 skip 返回 true 的文件
+func LoadDir(dir string, handler Handler, skip func(name string, info os.FileInfo) bool) ([]*collect.TaskModule, map[string]error, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	var modules []*collect.TaskModule
+	errs := make(map[string]error)
+	for _, e := range entries {
+		if e.IsDir() || !IsModuleFile(e.Name()) {
+			continue
+		}
+		name := filepath.Join(dir, e.Name())
+		if skip != nil {
+			info, err := e.Info()
+			if err != nil || skip(name, info) {
+				continue
+			}
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+		m, err := Decode(name, data)
+		if err == nil {
+			err = handler(m)
+		}
+		if err != nil {
+			errs[name] = err
+			continue
+		}
+		modules = append(modules, m)
+	}
+	return modules, errs, nil
+}
+
+// Run 立即扫描一次目录，之后每隔 interval 扫描一次，直到 ctx 结束
+func (w *DirWatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := w.Scan(); err != nil {
+			w.logger.Error("scan task module dir failed", zap.String("dir", w.dir), zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// WatchEtcd 加载 etcd 中已有的任务模块，并监听之后的新增与修改，直到 ctx 结束。
+// 超过 timeout 仍未读取到已有的任务模块时返回错误
+func WatchEtcd(ctx context.Context, cli *clientv3.Client, handler Handler, opts ...Option) error {
+	options := defaultOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	logger := options.logger
+
+	// etcd 不可用时不能一直阻塞调用方的启动
+	getCtx, cancel := context.WithTimeout(ctx, options.timeout)
+	resp, err := cli.Get(getCtx, ModulePath, clientv3.WithPrefix())
+	cancel()
+	if err != nil {
+		return fmt.Errorf("get task modules failed:%w", err)
+	}
+	handle := func(key string, value []byte) {
+		m := &collect.TaskModule{}
+		if err := json.Unmarshal(value, m); err != nil {
+			logger.Error("decode task module failed", zap.String("key", key), zap.Error(err))
+			return
+		}
+		if err := handler(m); err != nil {
+			logger.Error("load task module failed", zap.String("key", key), zap.Error(err))
+			return
+		}
+		logger.Info("load task module", zap.String("key", key))
+	}
+	for _, kv := range resp.Kvs {
+		handle(string(kv.Key), kv.Value)
+	}
+
+	// 从读取时的版本之后开始监听，避免遗漏
+	watchCh := cli.Watch(ctx, ModulePath, clientv3.WithPrefix(), clientv3.WithRev(resp.Header.Revision+1))
+	go func() {
+		for w := range watchCh {
+			if err := w.Err(); err != nil {
+				logger.Error("watch task modules failed", zap.Error(err))
+				continue
+			}
+			for _, ev := range w.Events {
+				if ev.Type == clientv3.EventTypePut {
+					handle(string(ev.Kv.Key), ev.Kv.Value)
+				}
+			}
+		}
+	}()
+	return nil
+}